@@ -108,17 +108,78 @@ func (m *Mail) AttachInlineWithMimeType(name string, r io.Reader, mimeType strin
 	})
 }
 
+// AttachOpener adds an attachment with name as the filename, with the content
+// read from the io.ReadCloser returned by open.
+//
+// open is not called until Send is called, and the returned reader is closed
+// once the attachment has been written. Unlike Attach, the attachment can be
+// re-opened if the send is retried (see MailYak.Retry), making AttachOpener
+// suitable for content that cannot be rewound, such as HTTP responses:
+//
+//	mail.AttachOpener("report.pdf", func() (io.ReadCloser, error) {
+//		return os.Open("/path/to/report.pdf")
+//	})
+//
+// The MIME type will be detected using
+// https://golang.org/pkg/net/http/#DetectContentType
+func (m *Mail) AttachOpener(name string, open func() (io.ReadCloser, error)) {
+	m.attachments = append(m.attachments, attachment{
+		filename: name,
+		content:  &openReader{open: open},
+		inline:   false,
+	})
+}
+
+// openReader lazily opens a reader with open on the first call to Read,
+// allowing the content to be re-opened once closed.
+type openReader struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	if o.rc == nil {
+		rc, err := o.open()
+		if err != nil {
+			return 0, err
+		}
+		o.rc = rc
+	}
+	return o.rc.Read(p)
+}
+
+// Close closes the opened reader (if any), causing the next Read to open the
+// content again from the start.
+func (o *openReader) Close() error {
+	if o.rc == nil {
+		return nil
+	}
+	err := o.rc.Close()
+	o.rc = nil
+	return err
+}
+
 // ClearAttachments removes all current attachments.
 func (m *Mail) ClearAttachments() {
 	m.attachments = []attachment{}
 }
 
 // writeAttachments loops over the attachments, guesses their content-type and
-// writes the data as a line-broken base64 string (using the splitter mutator).
-func (m *Mail) writeAttachments(mixed partCreator, splitter writeWrapper) error {
+// writes the data as a line-broken base64 string (using the splitter mutator),
+// or as binary if allowed by enc.
+func (m *Mail) writeAttachments(mixed partCreator, splitter writeWrapper, enc transferEncoding) error {
+	if err := m.markAttachmentsRead(); err != nil {
+		return err
+	}
+
 	h := make([]byte, sniffLen)
 
 	for _, item := range m.attachments {
+		if o, ok := item.content.(*openReader); ok {
+			//goland:noinspection GoDeferInLoop
+			defer func() { _ = o.Close() }()
+		}
+
 		hLen, err := io.ReadFull(item.content, h)
 		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			return err
@@ -128,13 +189,32 @@ func (m *Mail) writeAttachments(mixed partCreator, splitter writeWrapper) error
 			item.mimeType = http.DetectContentType(h[:hLen])
 		}
 
-		ctype := fmt.Sprintf("%s;\n\tfilename=%q", item.mimeType, item.filename)
+		ctype := fmt.Sprintf("%s;\r\n\tfilename=%q", item.mimeType, item.filename)
+
+		// Raw attachments are already base64 encoded.
+		cte := "base64"
+		if enc == encodingBinary && !item.raw {
+			cte = "binary"
+		}
 
-		part, err := mixed.CreatePart(getMIMEHeader(item, ctype))
+		part, err := mixed.CreatePart(getMIMEHeader(item, ctype, cte))
 		if err != nil {
 			return err
 		}
 
+		if cte == "binary" {
+			if _, err = part.Write(h[:hLen]); err != nil {
+				return err
+			}
+			// More to write?
+			if hLen == sniffLen {
+				if _, err = io.Copy(part, item.content); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		if item.raw {
 			rawWriter := splitter.new(part)
 			if _, err = rawWriter.Write(h[:hLen]); err != nil {
@@ -169,25 +249,94 @@ func (m *Mail) writeAttachments(mixed partCreator, splitter writeWrapper) error
 	return nil
 }
 
-func getMIMEHeader(a attachment, ctype string) textproto.MIMEHeader {
+// markAttachmentsRead records that the attachments are about to be read, along
+// with the current position of any seekable readers so they can be rewound.
+func (m *Mail) markAttachmentsRead() error {
+	if m.attachmentsRead {
+		return nil
+	}
+
+	m.attachmentOffsets = make([]int64, len(m.attachments))
+	for i, a := range m.attachments {
+		s, ok := a.content.(io.Seeker)
+		if !ok {
+			continue
+		}
+
+		offset, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		m.attachmentOffsets[i] = offset
+	}
+	m.attachmentsRead = true
+
+	return nil
+}
+
+// rewindable returns true if every attachment can be read again once read, as
+// required by rewind.
+func (m *Mail) rewindable() bool {
+	for _, a := range m.attachments {
+		if _, ok := a.content.(*openReader); ok {
+			continue
+		}
+		if _, ok := a.content.(io.Seeker); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// rewind prepares the attachments to be read again, such as when retrying a
+// send.
+//
+// Readers implementing io.Seeker are returned to the position they were at
+// when first read, and attachments added with AttachOpener are re-opened. An
+// error is returned if the attachments have been read and any cannot be
+// rewound.
+func (m *Mail) rewind() error {
+	if !m.attachmentsRead {
+		return nil
+	}
+
+	for i, a := range m.attachments {
+		if _, ok := a.content.(*openReader); ok {
+			continue
+		}
+
+		s, ok := a.content.(io.Seeker)
+		if !ok {
+			return fmt.Errorf("mailyak: attachment %q cannot be rewound", a.filename)
+		}
+		if _, err := s.Seek(m.attachmentOffsets[i], io.SeekStart); err != nil {
+			return err
+		}
+	}
+	m.attachmentsRead = false
+
+	return nil
+}
+
+func getMIMEHeader(a attachment, ctype string, cte string) textproto.MIMEHeader {
 	var disp string
 	var header textproto.MIMEHeader
 
 	cid := fmt.Sprintf("<%s>", a.filename)
 	if a.inline {
-		disp = fmt.Sprintf("inline;\n\tfilename=%q", a.filename)
+		disp = fmt.Sprintf("inline;\r\n\tfilename=%q", a.filename)
 		header = textproto.MIMEHeader{
 			"Content-Type":              {ctype},
 			"Content-Disposition":       {disp},
-			"Content-Transfer-Encoding": {"base64"},
+			"Content-Transfer-Encoding": {cte},
 			"Content-ID":                {cid},
 		}
 	} else {
-		disp = fmt.Sprintf("attachment;\n\tfilename=%q", a.filename)
+		disp = fmt.Sprintf("attachment;\r\n\tfilename=%q", a.filename)
 		header = textproto.MIMEHeader{
 			"Content-Type":              {ctype},
 			"Content-Disposition":       {disp},
-			"Content-Transfer-Encoding": {"base64"},
+			"Content-Transfer-Encoding": {cte},
 			"Content-ID":                {cid},
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"io"
+	"io/ioutil"
 	"net/textproto"
 	"strings"
 	"testing"
@@ -262,32 +263,32 @@ func TestMailWriteAttachments(t *testing.T) {
 		{
 			"Empty",
 			[]attachment{{"Empty", &bytes.Buffer{}, false, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty\"",
-			"attachment;\n\tfilename=\"Empty\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty\"",
+			"attachment;\r\n\tfilename=\"Empty\"",
 			"",
 			false,
 		},
 		{
 			"Short string",
 			[]attachment{{"advice", strings.NewReader("Don't Panic"), false, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"advice\"",
-			"attachment;\n\tfilename=\"advice\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"advice\"",
+			"attachment;\r\n\tfilename=\"advice\"",
 			"RG9uJ3QgUGFuaWM=",
 			false,
 		},
 		{
 			"Space in filename",
 			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty with spaces\"",
-			"attachment;\n\tfilename=\"Empty with spaces\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty with spaces\"",
+			"attachment;\r\n\tfilename=\"Empty with spaces\"",
 			"",
 			false,
 		},
 		{
 			"With specified MIME type",
 			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, false,"text/csv; charset=utf-8"}},
-			"text/csv; charset=utf-8;\n\tfilename=\"Empty with spaces\"",
-			"attachment;\n\tfilename=\"Empty with spaces\"",
+			"text/csv; charset=utf-8;\r\n\tfilename=\"Empty with spaces\"",
+			"attachment;\r\n\tfilename=\"Empty with spaces\"",
 			"",
 			false,
 		},
@@ -305,8 +306,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					"",
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"partyinvite.txt\"",
-			"attachment;\n\tfilename=\"partyinvite.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"partyinvite.txt\"",
+			"attachment;\r\n\tfilename=\"partyinvite.txt\"",
 			"SWYgQmFsZHJpY2sgc2VydmVkIGEgbWVhbCBhdCBIUSBoZSB3b3VsZCBiZSBhcnJlc3Rl" +
 				"ZCBmb3IgdGhlIGJpZ2dlc3QgbWFzcyBwb2lzb25pbmcgc2luY2UgTHVjcmV0aWEgQm9y" +
 				"Z2lhIGludml0ZWQgNTAwIGZyaWVuZHMgZm9yIGEgV2luZSBhbmQgQW50aHJheCBQYXJ0eS4=",
@@ -334,8 +335,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					"",
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"",
-			"attachment;\n\tfilename=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"",
+			"attachment;\r\n\tfilename=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -356,16 +357,16 @@ func TestMailWriteAttachments(t *testing.T) {
 		{
 			"HTML",
 			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), false, false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.html\"",
-			"attachment;\n\tfilename=\"name.html\"",
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.html\"",
+			"attachment;\r\n\tfilename=\"name.html\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
 		{
 			"HTML - wrong extension",
 			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), false, false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.png\"",
-			"attachment;\n\tfilename=\"name.png\"",
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.png\"",
+			"attachment;\r\n\tfilename=\"name.png\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
@@ -374,16 +375,16 @@ func TestMailWriteAttachments(t *testing.T) {
 		{
 			"Empty inline",
 			[]attachment{{"Empty", &bytes.Buffer{}, true, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty\"",
-			"inline;\n\tfilename=\"Empty\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty\"",
+			"inline;\r\n\tfilename=\"Empty\"",
 			"",
 			false,
 		},
 		{
 			"Short string inline",
 			[]attachment{{"advice", strings.NewReader("Don't Panic"), true, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"advice\"",
-			"inline;\n\tfilename=\"advice\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"advice\"",
+			"inline;\r\n\tfilename=\"advice\"",
 			"RG9uJ3QgUGFuaWM=",
 			false,
 		},
@@ -401,8 +402,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					"",
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"partyinvite.txt\"",
-			"inline;\n\tfilename=\"partyinvite.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"partyinvite.txt\"",
+			"inline;\r\n\tfilename=\"partyinvite.txt\"",
 			"SWYgQmFsZHJpY2sgc2VydmVkIGEgbWVhbCBhdCBIUSBoZSB3b3VsZCBiZSBhcnJlc3Rl" +
 				"ZCBmb3IgdGhlIGJpZ2dlc3QgbWFzcyBwb2lzb25pbmcgc2luY2UgTHVjcmV0aWEgQm9y" +
 				"Z2lhIGludml0ZWQgNTAwIGZyaWVuZHMgZm9yIGEgV2luZSBhbmQgQW50aHJheCBQYXJ0eS4=",
@@ -430,8 +431,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					"",
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"",
-			"inline;\n\tfilename=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"",
+			"inline;\r\n\tfilename=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -452,16 +453,16 @@ func TestMailWriteAttachments(t *testing.T) {
 		{
 			"HTML inline",
 			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), true, false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.html\"",
-			"inline;\n\tfilename=\"name.html\"",
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.html\"",
+			"inline;\r\n\tfilename=\"name.html\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
 		{
 			"HTML - wrong extension inline",
 			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), true, false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.png\"",
-			"inline;\n\tfilename=\"name.png\"",
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.png\"",
+			"inline;\r\n\tfilename=\"name.png\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
@@ -489,8 +490,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					mimeType: "",
 				},
 			},
-			ctype: "text/plain; charset=utf-8;\n\tfilename=\"file.pdf\"",
-			disp: "attachment;\n\tfilename=\"file.pdf\"",
+			ctype: "text/plain; charset=utf-8;\r\n\tfilename=\"file.pdf\"",
+			disp: "attachment;\r\n\tfilename=\"file.pdf\"",
 			data: "JVBERi0xLjcKCjEgMCBvYmogICUgZW50cnkgcG9pbnQKPDwKICAvVHlwZSAvQ2F0YWxvZwog" +
 				"IC9QYWdlcyAyIDAgUgo+PgplbmRvYmoKCjIgMCBvYmoKPDwKICAvVHlwZSAvUGFnZXMKICAv" +
 				"TWVkaWFCb3ggWyAwIDAgMjAwIDIwMCBdCiAgL0NvdW50IDEKICAvS2lkcyBbIDMgMCBSIF0K" +
@@ -532,8 +533,8 @@ func TestMailWriteAttachments(t *testing.T) {
 					"",
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"",
-			"attachment;\n\tfilename=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"",
+			"attachment;\r\n\tfilename=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -561,7 +562,7 @@ func TestMailWriteAttachments(t *testing.T) {
 			m.attachments = tt.rattachments
 			pc := testPartCreator{}
 
-			if err := m.writeAttachments(&pc, nopBuilder{}); (err != nil) != tt.wantErr {
+			if err := m.writeAttachments(&pc, nopBuilder{}, encoding7Bit); (err != nil) != tt.wantErr {
 				t.Errorf("%q. Mail.writeAttachments() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
 
@@ -605,8 +606,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			[]attachment{{"name.txt", strings.NewReader("test"), false, false, ""}},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -617,8 +618,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			[]attachment{{"name.txt", strings.NewReader("test"), false,false, "text/csv; charset=utf-8"}},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -632,13 +633,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"",
-					disposition: "attachment;\n\tfilename=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -652,13 +653,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/html; charset=utf-8;\n\tfilename=\"html.txt\"",
-					disposition: "attachment;\n\tfilename=\"html.txt\"",
+					contentType: "text/html; charset=utf-8;\r\n\tfilename=\"html.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -672,13 +673,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "application/xml;\n\tfilename=\"html.txt\"",
-					disposition: "attachment;\n\tfilename=\"html.txt\"",
+					contentType: "application/xml;\r\n\tfilename=\"html.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -718,8 +719,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"",
-					disposition: "attachment;\n\tfilename=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5pc" +
 							"2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlxdW" +
@@ -732,8 +733,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"",
-					disposition: "attachment;\n\tfilename=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -783,8 +784,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"",
-					disposition: "attachment;\n\tfilename=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -797,8 +798,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"",
-					disposition: "attachment;\n\tfilename=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5p" +
 							"c2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlx" +
@@ -820,8 +821,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			[]attachment{{"name.txt", strings.NewReader("test"), true, false, ""}},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -832,8 +833,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			[]attachment{{"name.txt", strings.NewReader("test"), true, false,"text/csv; charset=utf-8"}},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -847,13 +848,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -867,13 +868,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -887,13 +888,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/html; charset=utf-8;\n\tfilename=\"html.txt\"",
-					disposition: "inline;\n\tfilename=\"html.txt\"",
+					contentType: "text/html; charset=utf-8;\r\n\tfilename=\"html.txt\"",
+					disposition: "inline;\r\n\tfilename=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -907,13 +908,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "application/xml;\n\tfilename=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"",
+					contentType: "application/xml;\r\n\tfilename=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -953,8 +954,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"",
-					disposition: "inline;\n\tfilename=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"",
+					disposition: "inline;\r\n\tfilename=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5pc" +
 							"2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlxdW" +
@@ -967,8 +968,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"",
-					disposition: "inline;\n\tfilename=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"",
+					disposition: "inline;\r\n\tfilename=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -1018,8 +1019,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"",
-					disposition: "inline;\n\tfilename=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"",
+					disposition: "inline;\r\n\tfilename=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -1032,8 +1033,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"",
-					disposition: "inline;\n\tfilename=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"",
+					disposition: "inline;\r\n\tfilename=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5p" +
 							"c2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlx" +
@@ -1059,7 +1060,7 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 			m.attachments = tt.rattachments
 			pc := testPartCreator{}
 
-			if err := m.writeAttachments(&pc, nopBuilder{}); (err != nil) != tt.wantErr {
+			if err := m.writeAttachments(&pc, nopBuilder{}, encoding7Bit); (err != nil) != tt.wantErr {
 				t.Errorf("%q. MailYak.writeAttachments() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
 
@@ -1087,3 +1088,81 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		})
 	}
 }
+
+// TestMailRewind ensures attachments can be read again after being rewound,
+// and an error is returned for readers that cannot be rewound.
+func TestMailRewind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		attach  func(m *Mail)
+		wantErr bool
+	}{
+		{
+			name: "seeker",
+			attach: func(m *Mail) {
+				m.Attach("advice", strings.NewReader("Don't Panic"))
+			},
+		},
+		{
+			name: "seeker with offset",
+			attach: func(m *Mail) {
+				r := strings.NewReader("xxDon't Panic")
+				_, _ = r.Seek(2, io.SeekStart)
+				m.Attach("advice", r)
+			},
+		},
+		{
+			name: "opener",
+			attach: func(m *Mail) {
+				m.AttachOpener("advice", func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(strings.NewReader("Don't Panic")), nil
+				})
+			},
+		},
+		{
+			name: "not rewindable",
+			attach: func(m *Mail) {
+				m.Attach("advice", bytes.NewBufferString("Don't Panic"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := getMail()
+			defer putMail(m)
+			tt.attach(m)
+
+			write := func() string {
+				var pc testPartCreator
+				if err := m.writeAttachments(&pc, nopBuilder{}, encoding7Bit); err != nil {
+					t.Fatalf("writeAttachments() error = %v", err)
+				}
+				return pc.attachments[0].data.String()
+			}
+
+			first := write()
+			if want := base64.StdEncoding.EncodeToString([]byte("Don't Panic")); first != want {
+				t.Fatalf("got %q, want %q", first, want)
+			}
+
+			err := m.rewind()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rewind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if second := write(); second != first {
+				t.Errorf("got %q after rewind, want %q", second, first)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package mailyak
+
+import (
+	"errors"
+	"net/smtp"
+)
+
+var (
+	// ErrNoAuthMechanism is returned when authenticating with Credentials and
+	// the SMTP server does not advertise any of the supported mechanisms.
+	ErrNoAuthMechanism = errors.New("mailyak: no supported authentication mechanism advertised by the server")
+
+	// ErrInsecureAuth is returned when authenticating with Credentials and the
+	// only mechanisms supported by the SMTP server would send the password in
+	// plain-text over an unencrypted connection.
+	ErrInsecureAuth = errors.New("mailyak: refusing to send password over an unencrypted connection")
+)
+
+// Credentials is a username and password used to authenticate with the SMTP
+// server, negotiating the authentication mechanism from those advertised by
+// the server.
+//
+//	my := mailyak.New("mail.host.com:25", mailyak.Credentials{
+//		Username: "user",
+//		Password: "pass",
+//	}.Auth())
+//
+// The strongest mechanism supported by the server is used, in order of
+// preference: SCRAM-SHA-256, CRAM-MD5, PLAIN and LOGIN.
+type Credentials struct {
+	Username string
+	Password string
+
+	// AllowInsecure permits the PLAIN and LOGIN mechanisms, which send the
+	// password in plain-text, over unencrypted connections to servers other
+	// than localhost. Defaults to false.
+	AllowInsecure bool
+}
+
+// Auth returns a smtp.Auth that authenticates with the credentials.
+func (c Credentials) Auth() smtp.Auth {
+	return &credentialsAuth{creds: c}
+}
+
+// conversationAuth is implemented by smtp.Auth implementations that hold state
+// for the duration of an authentication exchange.
+//
+// Sessions call conversation to obtain a fresh instance for each exchange,
+// allowing a single smtp.Auth to be used by concurrent connections.
+type conversationAuth interface {
+	conversation() smtp.Auth
+}
+
+// conversation returns auth, or a new instance of auth if it holds state for
+// the authentication exchange.
+func conversation(auth smtp.Auth) smtp.Auth {
+	if c, ok := auth.(conversationAuth); ok {
+		return c.conversation()
+	}
+	return auth
+}
+
+// credentialsAuth negotiates an authentication mechanism in Start, and
+// delegates the exchange to it.
+type credentialsAuth struct {
+	creds Credentials
+
+	// mechanism is the negotiated mechanism, set by Start.
+	mechanism smtp.Auth
+}
+
+func (a *credentialsAuth) conversation() smtp.Auth {
+	return &credentialsAuth{creds: a.creds}
+}
+
+func (a *credentialsAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	advertised := make(map[string]bool, len(server.Auth))
+	for _, m := range server.Auth {
+		advertised[m] = true
+	}
+
+	username, password := a.creds.Username, a.creds.Password
+	switch {
+	case advertised["SCRAM-SHA-256"]:
+		a.mechanism = newScramAuth(username, password)
+	case advertised["CRAM-MD5"]:
+		a.mechanism = smtp.CRAMMD5Auth(username, password)
+	case advertised["PLAIN"] || advertised["LOGIN"]:
+		if !server.TLS && !isLocalhost(server.Name) && !a.creds.AllowInsecure {
+			return "", nil, ErrInsecureAuth
+		}
+		if advertised["PLAIN"] {
+			a.mechanism = &plainAuth{username: username, password: password}
+		} else {
+			a.mechanism = &loginAuth{username: username, password: password}
+		}
+	default:
+		return "", nil, ErrNoAuthMechanism
+	}
+
+	return a.mechanism.Start(server)
+}
+
+func (a *credentialsAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	return a.mechanism.Next(fromServer, more)
+}
+
+// plainAuth implements the PLAIN authentication mechanism (RFC 4616).
+//
+// Unlike smtp.PlainAuth, it does not check the connection is encrypted - this
+// is left to credentialsAuth.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("mailyak: unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements the (non-standard) LOGIN authentication mechanism,
+// sending the username and password in response to the server's first and
+// second challenges.
+type loginAuth struct {
+	username, password string
+	step               int
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	a.step = 0
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	a.step++
+	switch a.step {
+	case 1:
+		return []byte(a.username), nil
+	case 2:
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("mailyak: unexpected server challenge")
+	}
+}
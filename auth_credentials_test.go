@@ -0,0 +1,288 @@
+package mailyak
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestCredentialsNegotiation ensures the strongest mechanism advertised by the
+// server is chosen, and plain-text mechanisms are refused over unencrypted
+// connections unless allowed.
+func TestCredentialsNegotiation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		creds  Credentials
+		server smtp.ServerInfo
+
+		wantMechanism string
+		wantResp      string
+		wantErr       error
+	}{
+		{
+			name:          "prefer scram",
+			server:        smtp.ServerInfo{Name: "smtp.example.org", TLS: true, Auth: []string{"LOGIN", "PLAIN", "CRAM-MD5", "SCRAM-SHA-256"}},
+			wantMechanism: "SCRAM-SHA-256",
+		},
+		{
+			name:          "cram-md5 over plain",
+			server:        smtp.ServerInfo{Name: "smtp.example.org", Auth: []string{"LOGIN", "PLAIN", "CRAM-MD5"}},
+			wantMechanism: "CRAM-MD5",
+		},
+		{
+			name:          "plain over login",
+			server:        smtp.ServerInfo{Name: "smtp.example.org", TLS: true, Auth: []string{"LOGIN", "PLAIN"}},
+			wantMechanism: "PLAIN",
+			wantResp:      "\x00user\x00pass",
+		},
+		{
+			name:          "login",
+			server:        smtp.ServerInfo{Name: "smtp.example.org", TLS: true, Auth: []string{"LOGIN"}},
+			wantMechanism: "LOGIN",
+		},
+		{
+			name:    "insecure refused",
+			server:  smtp.ServerInfo{Name: "smtp.example.org", Auth: []string{"LOGIN", "PLAIN"}},
+			wantErr: ErrInsecureAuth,
+		},
+		{
+			name:          "insecure localhost",
+			server:        smtp.ServerInfo{Name: "localhost", Auth: []string{"LOGIN"}},
+			wantMechanism: "LOGIN",
+		},
+		{
+			name:          "insecure allowed",
+			creds:         Credentials{AllowInsecure: true},
+			server:        smtp.ServerInfo{Name: "smtp.example.org", Auth: []string{"PLAIN"}},
+			wantMechanism: "PLAIN",
+			wantResp:      "\x00user\x00pass",
+		},
+		{
+			name:    "unsupported",
+			server:  smtp.ServerInfo{Name: "smtp.example.org", TLS: true, Auth: []string{"GSSAPI"}},
+			wantErr: ErrNoAuthMechanism,
+		},
+		{
+			name:    "no auth",
+			server:  smtp.ServerInfo{Name: "smtp.example.org", TLS: true},
+			wantErr: ErrNoAuthMechanism,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.creds.Username = "user"
+			tt.creds.Password = "pass"
+
+			mechanism, resp, err := conversation(tt.creds.Auth()).Start(&tt.server)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if mechanism != tt.wantMechanism {
+				t.Errorf("got mechanism %q, want %q", mechanism, tt.wantMechanism)
+			}
+			if tt.wantResp != "" && string(resp) != tt.wantResp {
+				t.Errorf("got response %q, want %q", resp, tt.wantResp)
+			}
+		})
+	}
+}
+
+// TestCredentialsSend ensures emails are sent using negotiated authentication,
+// with concurrent sends using the same Credentials.
+func TestCredentialsSend(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewUnstartedServer(smtptest.ModeStartTLS)
+	srv.Credentials = map[string]string{"user": "pass"}
+	srv.RequireAuth = true
+	srv.Start()
+	defer srv.Close()
+
+	m := New(srv.Addr, Credentials{Username: "user", Password: "pass"}.Auth())
+	m.StartTLSConfig(srv.ClientTLSConfig())
+
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			errs <- m.Send(mail)
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	txns := srv.Transactions()
+	if len(txns) != 5 {
+		t.Errorf("got %d transactions, want 5", len(txns))
+	}
+	for _, txn := range txns {
+		if txn.Username != "user" {
+			t.Errorf("got username %q, want %q", txn.Username, "user")
+		}
+	}
+}
+
+// TestCredentialsLogin ensures the LOGIN mechanism sends the username and
+// password in response to the server challenges, regardless of the challenge
+// text.
+func TestCredentialsLogin(t *testing.T) {
+	t.Parallel()
+
+	auth := Credentials{Username: "user", Password: "pass"}.Auth()
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "localhost", Auth: []string{"LOGIN"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"user", "pass"} {
+		got, err := auth.Next([]byte("User Name\x00"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if _, err := auth.Next([]byte("Bananas"), true); err == nil {
+		t.Error("expected error for unexpected challenge")
+	}
+}
+
+// TestScramAuth ensures the SCRAM-SHA-256 exchange matches the test vector in
+// RFC 7677, section 3, and the server signature must be verified whether it is
+// sent as a challenge or in the success reply.
+func TestScramAuth(t *testing.T) {
+	t.Parallel()
+
+	const (
+		serverFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+		clientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+		serverFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+	)
+
+	newAuth := func() *scramAuth {
+		a := newScramAuth("user", "pencil")
+		a.nonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+		return a
+	}
+
+	tests := []struct {
+		name        string
+		serverFirst string
+		serverFinal string
+
+		// successReply sends serverFinal as the text of the 235 reply
+		// instead of a 334 challenge.
+		successReply bool
+
+		wantFirstErr bool
+		wantErr      bool
+	}{
+		{
+			name:        "ok",
+			serverFinal: serverFinal,
+		},
+		{
+			name:        "bad server signature",
+			serverFinal: "v=" + base64.StdEncoding.EncodeToString([]byte("bananas")),
+			wantErr:     true,
+		},
+		{
+			name:        "server error",
+			serverFinal: "e=invalid-proof",
+			wantErr:     true,
+		},
+		{
+			name:         "success reply",
+			serverFinal:  "2.7.0 " + serverFinal,
+			successReply: true,
+		},
+		{
+			name:         "base64 success reply",
+			serverFinal:  base64.StdEncoding.EncodeToString([]byte(serverFinal)),
+			successReply: true,
+		},
+		{
+			name:         "bad server signature in success reply",
+			serverFinal:  "2.7.0 v=" + base64.StdEncoding.EncodeToString([]byte("bananas")),
+			successReply: true,
+			wantErr:      true,
+		},
+		{
+			name:         "no server signature",
+			serverFinal:  "2.7.0 Authentication successful",
+			successReply: true,
+			wantErr:      true,
+		},
+		{
+			name:         "too many iterations",
+			serverFirst:  strings.Replace(serverFirst, "i=4096", "i=100000000", 1),
+			wantFirstErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.serverFirst == "" {
+				tt.serverFirst = serverFirst
+			}
+
+			a := newAuth()
+			mechanism, resp, err := a.Start(&smtp.ServerInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mechanism != "SCRAM-SHA-256" || string(resp) != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+				t.Fatalf("got %q %q", mechanism, resp)
+			}
+
+			resp, err = a.Next([]byte(tt.serverFirst), true)
+			if tt.wantFirstErr {
+				if err == nil {
+					t.Fatal("got nil error, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resp) != clientFinal {
+				t.Errorf("got %q, want %q", resp, clientFinal)
+			}
+
+			if !tt.successReply {
+				_, err = a.Next([]byte(tt.serverFinal), true)
+				if err != nil {
+					if !tt.wantErr {
+						t.Fatalf("got error %v, want nil", err)
+					}
+					return
+				}
+				tt.serverFinal = "2.7.0 Authentication successful"
+			}
+
+			_, err = a.Next([]byte(tt.serverFinal), false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package mailyak
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// TokenProvider returns an OAuth 2.0 access token used to authenticate with the
+// SMTP server.
+//
+// It is called each time a connection is authenticated, and should return a
+// valid (refreshed if necessary) token - for example, by calling Token() on an
+// oauth2.TokenSource.
+type TokenProvider func() (string, error)
+
+// OAuthError is returned when the SMTP server rejects an OAuth access token,
+// describing the failure using the JSON error challenge sent by the server
+// (RFC 7628, section 3.2.2).
+type OAuthError struct {
+	// Status is the error status, such as "invalid_token" or "400".
+	Status string `json:"status"`
+
+	// Schemes lists the HTTP authentication schemes supported by the server,
+	// such as "bearer".
+	Schemes string `json:"schemes,omitempty"`
+
+	// Scope lists the OAuth scopes needed to access the server.
+	Scope string `json:"scope,omitempty"`
+
+	// Challenge is the raw challenge sent by the server.
+	Challenge string `json:"-"`
+}
+
+// Error returns a description of the authentication failure.
+func (e *OAuthError) Error() string {
+	if e.Status == "" {
+		return fmt.Sprintf("mailyak: oauth authentication failed: %s", e.Challenge)
+	}
+
+	msg := "mailyak: oauth authentication failed: status " + e.Status
+	if e.Scope != "" {
+		msg += ", scope " + e.Scope
+	}
+	return msg
+}
+
+// newOAuthError parses the JSON error challenge sent by the server. If the
+// challenge is not valid JSON, only the Challenge field is populated.
+func newOAuthError(challenge []byte) *OAuthError {
+	e := &OAuthError{}
+	_ = json.Unmarshal(challenge, e)
+	e.Challenge = string(challenge)
+	return e
+}
+
+// oauthAuth implements the XOAUTH2 and OAUTHBEARER authentication mechanisms.
+type oauthAuth struct {
+	mechanism string
+	username  string
+	token     TokenProvider
+}
+
+// XOAuth2Auth returns a smtp.Auth that implements the XOAUTH2 authentication
+// mechanism used by Gmail and Microsoft 365, authenticating as username with
+// an access token obtained from token for each connection.
+//
+// If the server rejects the token, the error returned by Send is a
+// *OAuthError.
+//
+// As with smtp.PlainAuth, the token is only sent over TLS connections, or to
+// localhost.
+func XOAuth2Auth(username string, token TokenProvider) smtp.Auth {
+	return &oauthAuth{
+		mechanism: "XOAUTH2",
+		username:  username,
+		token:     token,
+	}
+}
+
+// OAuthBearerAuth returns a smtp.Auth that implements the OAUTHBEARER
+// authentication mechanism defined in RFC 7628, authenticating as username
+// with an access token obtained from token for each connection.
+//
+// If the server rejects the token, the error returned by Send is a
+// *OAuthError.
+//
+// As with smtp.PlainAuth, the token is only sent over TLS connections, or to
+// localhost.
+func OAuthBearerAuth(username string, token TokenProvider) smtp.Auth {
+	return &oauthAuth{
+		mechanism: "OAUTHBEARER",
+		username:  username,
+		token:     token,
+	}
+}
+
+func (a *oauthAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("mailyak: refusing to send oauth token over unencrypted connection")
+	}
+
+	token, err := a.token()
+	if err != nil {
+		return "", nil, fmt.Errorf("mailyak: failed to obtain oauth token: %w", err)
+	}
+
+	var resp string
+	switch a.mechanism {
+	case "XOAUTH2":
+		resp = "user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"
+	default:
+		resp = "n,a=" + saslName(a.username) + ",\x01host=" + server.Name +
+			"\x01auth=Bearer " + token + "\x01\x01"
+	}
+
+	return a.mechanism, []byte(resp), nil
+}
+
+func (a *oauthAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	// A challenge after the initial response is always the JSON error
+	// describing why the token was rejected.
+	return nil, newOAuthError(fromServer)
+}
+
+// saslName escapes a username for use in a GS2 header (RFC 5801).
+func saslName(s string) string {
+	s = strings.ReplaceAll(s, "=", "=3D")
+	return strings.ReplaceAll(s, ",", "=2C")
+}
+
+// isLocalhost returns true if name refers to the local machine.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,204 @@
+package mailyak
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/smtp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestOAuth ensures the XOAUTH2 and OAUTHBEARER mechanisms send a fresh token
+// for each connection, and surface the server's JSON error challenge.
+func TestOAuth(t *testing.T) {
+	t.Parallel()
+
+	const testTimeout = 15 * time.Second
+
+	b64 := base64.StdEncoding.EncodeToString
+	errChallenge := `{"status":"invalid_token","schemes":"bearer","scope":"https://mail.google.com/"}`
+
+	tests := []struct {
+		name     string
+		auth     func(token TokenProvider) smtp.Auth
+		tokenErr error
+
+		connFn func(c *connAsserts)
+
+		wantErr      string
+		wantOAuthErr *OAuthError
+	}{
+		{
+			name: "xoauth2",
+			auth: func(token TokenProvider) smtp.Auth {
+				return XOAuth2Auth("dom@itsallbroken.com", token)
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n250 AUTH XOAUTH2\r\n")
+				c.Expect("AUTH XOAUTH2 " + b64([]byte("user=dom@itsallbroken.com\x01auth=Bearer token-1\x01\x01")) + "\r\n")
+				c.Respond("235 2.7.0 Accepted\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("DATA\r\n")
+				c.Respond("354 OK\r\n")
+				c.Expect("bananas\r\n.\r\n")
+				c.Respond("250 Will do friend\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+		},
+		{
+			name: "oauthbearer",
+			auth: func(token TokenProvider) smtp.Auth {
+				return OAuthBearerAuth("dom,=@itsallbroken.com", token)
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n250 AUTH OAUTHBEARER\r\n")
+				c.Expect("AUTH OAUTHBEARER " + b64([]byte("n,a=dom=2C=3D@itsallbroken.com,\x01host=127.0.0.1\x01auth=Bearer token-1\x01\x01")) + "\r\n")
+				c.Respond("235 2.7.0 Accepted\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("DATA\r\n")
+				c.Respond("354 OK\r\n")
+				c.Expect("bananas\r\n.\r\n")
+				c.Respond("250 Will do friend\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+		},
+		{
+			name: "error challenge",
+			auth: func(token TokenProvider) smtp.Auth {
+				return XOAuth2Auth("dom@itsallbroken.com", token)
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n250 AUTH XOAUTH2\r\n")
+				c.Expect("AUTH XOAUTH2 " + b64([]byte("user=dom@itsallbroken.com\x01auth=Bearer token-1\x01\x01")) + "\r\n")
+				c.Respond("334 " + b64([]byte(errChallenge)) + "\r\n")
+				c.Expect("*\r\n")
+				c.Respond("501 5.7.0 Cancelled\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantErr: "mailyak: oauth authentication failed: status invalid_token, scope https://mail.google.com/",
+			wantOAuthErr: &OAuthError{
+				Status:    "invalid_token",
+				Schemes:   "bearer",
+				Scope:     "https://mail.google.com/",
+				Challenge: errChallenge,
+			},
+		},
+		{
+			name: "token error",
+			auth: func(token TokenProvider) smtp.Auth {
+				return XOAuth2Auth("dom@itsallbroken.com", token)
+			},
+			tokenErr: errors.New("refresh failed"),
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n250 AUTH XOAUTH2\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantErr: "mailyak: failed to obtain oauth token: refresh failed",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			socket, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to bind to localhost: %v", err)
+			}
+			defer socket.Close()
+
+			handlerDone := make(chan struct{})
+			go func() {
+				defer close(handlerDone)
+				conn, err := socket.Accept()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				tt.connFn(newConnAsserts(conn, t))
+			}()
+
+			calls := 0
+			token := func() (string, error) {
+				calls++
+				if tt.tokenErr != nil {
+					return "", tt.tokenErr
+				}
+				return "token-1", nil
+			}
+
+			mail := &mockMail{
+				toAddrs:  []string{"to@example.org"},
+				fromAddr: "from@example.org",
+				mime:     "bananas",
+			}
+
+			m := New(socket.Addr().String(), tt.auth(token))
+
+			sendErr := make(chan error, 1)
+			go func() {
+				_, err := m.sender.Send(context.Background(), mail)
+				sendErr <- err
+			}()
+
+			select {
+			case <-time.After(testTimeout):
+				t.Fatal("timeout waiting for Send() to return")
+			case err := <-sendErr:
+				if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+
+				var oauthErr *OAuthError
+				errors.As(err, &oauthErr)
+				if tt.wantOAuthErr != nil && !reflect.DeepEqual(oauthErr, tt.wantOAuthErr) {
+					t.Errorf("got %+v, want %+v", oauthErr, tt.wantOAuthErr)
+				}
+			}
+			<-handlerDone
+
+			if calls != 1 {
+				t.Errorf("token provider called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+// TestOAuthUnencrypted ensures tokens are not sent over plain-text connections
+// to remote servers.
+func TestOAuthUnencrypted(t *testing.T) {
+	t.Parallel()
+
+	auth := XOAuth2Auth("dom@itsallbroken.com", func() (string, error) {
+		t.Error("token provider called for unencrypted connection")
+		return "", nil
+	})
+
+	_, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.org", TLS: false})
+	if err == nil {
+		t.Fatal("expected error for unencrypted connection")
+	}
+}
@@ -0,0 +1,219 @@
+package mailyak
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// scramAuth implements the SCRAM-SHA-256 authentication mechanism (RFC 5802,
+// RFC 7677), which proves knowledge of the password without sending it to the
+// server, and verifies the server also knows it.
+//
+// Channel binding is not supported.
+type scramAuth struct {
+	username, password string
+
+	// nonce returns the client nonce, replaced in tests.
+	nonce func() (string, error)
+
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+
+	// verified is set once the server signature has been verified.
+	verified bool
+}
+
+// scramMaxIterations caps the iteration count the server may request, as each
+// iteration costs the client a HMAC computation.
+const scramMaxIterations = 1 << 20
+
+func newScramAuth(username, password string) *scramAuth {
+	return &scramAuth{
+		username: username,
+		password: password,
+		nonce:    scramNonce,
+	}
+}
+
+// scramNonce returns a random printable nonce.
+func scramNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+func (a *scramAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	nonce, err := a.nonce()
+	if err != nil {
+		return "", nil, err
+	}
+
+	username := strings.ReplaceAll(a.username, "=", "=3D")
+	username = strings.ReplaceAll(username, ",", "=2C")
+
+	a.step = 0
+	a.verified = false
+	a.clientFirstBare = "n=" + username + ",r=" + nonce
+
+	// No channel binding, and no authorisation identity.
+	return "SCRAM-SHA-256", []byte("n,," + a.clientFirstBare), nil
+}
+
+func (a *scramAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		// Some servers include the server-final-message in the success reply
+		// instead of a challenge, which must still be verified.
+		if !a.verified && a.step == 1 {
+			if final, ok := scramSuccessFinal(string(fromServer)); ok {
+				if err := a.verify(final); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !a.verified {
+			return nil, errors.New("mailyak: scram server signature not received")
+		}
+		return nil, nil
+	}
+
+	a.step++
+	switch a.step {
+	case 1:
+		return a.clientFinal(string(fromServer))
+	case 2:
+		if err := a.verify(string(fromServer)); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	default:
+		return nil, errors.New("mailyak: unexpected server challenge")
+	}
+}
+
+// verify checks the server signature in the server-final-message serverFinal.
+func (a *scramAuth) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("mailyak: scram authentication failed: %s", e)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(sig, a.serverSignature) {
+		return errors.New("mailyak: scram server signature mismatch")
+	}
+
+	a.verified = true
+	return nil
+}
+
+// scramSuccessFinal returns the server-final-message included in the text of a
+// successful AUTH reply, either as is or base64 encoded, such as
+// "2.7.0 v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=".
+func scramSuccessFinal(text string) (string, bool) {
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "v=") || strings.HasPrefix(field, "e=") {
+			return field, true
+		}
+		if b, err := base64.StdEncoding.DecodeString(field); err == nil {
+			if final := string(b); strings.HasPrefix(final, "v=") || strings.HasPrefix(final, "e=") {
+				return final, true
+			}
+		}
+	}
+	return "", false
+}
+
+// clientFinal returns the client-final-message in response to the
+// server-first-message serverFirst.
+func (a *scramAuth) clientFinal(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	if e, ok := attrs["e"]; ok {
+		return nil, fmt.Errorf("mailyak: scram authentication failed: %s", e)
+	}
+
+	clientNonce := a.clientFirstBare[strings.Index(a.clientFirstBare, ",r=")+3:]
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, clientNonce) || len(nonce) == len(clientNonce) {
+		return nil, errors.New("mailyak: invalid scram server nonce")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return nil, errors.New("mailyak: invalid scram salt")
+	}
+
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 || iterations > scramMaxIterations {
+		return nil, errors.New("mailyak: invalid scram iteration count")
+	}
+
+	// "biws" is the base64 encoded GS2 header "n,,"
+	clientFinalBare := "c=biws,r=" + nonce
+	authMessage := []byte(a.clientFirstBare + "," + serverFirst + "," + clientFinalBare)
+
+	saltedPassword := scramHi([]byte(a.password), salt, iterations)
+	clientKey := scramHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSignature := scramHMAC(storedKey[:], authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := scramHMAC(saltedPassword, []byte("Server Key"))
+	a.serverSignature = scramHMAC(serverKey, authMessage)
+
+	return []byte(clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// scramAttributes parses the comma separated key=value attributes of a SCRAM
+// message.
+func scramAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, field := range strings.Split(msg, ",") {
+		if len(field) >= 2 && field[1] == '=' {
+			attrs[field[:1]] = field[2:]
+		}
+	}
+	return attrs
+}
+
+// scramHMAC returns HMAC-SHA-256(key, msg).
+func scramHMAC(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// scramHi implements the SCRAM Hi() function, which is PBKDF2 with
+// HMAC-SHA-256 producing a single block of output.
+func scramHi(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	_, _ = mac.Write(salt)
+	_, _ = mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	out := make([]byte, len(u))
+	copy(out, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		_, _ = mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+
+	return out
+}
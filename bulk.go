@@ -0,0 +1,322 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBulkSenderClosed is returned when submitting an email to a BulkSender
+// after Shutdown has been called.
+var ErrBulkSenderClosed = errors.New("mailyak: bulk sender is shut down")
+
+// BulkResult describes the outcome of sending a single email with a
+// BulkSender.
+type BulkResult struct {
+	// Seq is the order the email was submitted in, starting from 0.
+	Seq int
+
+	// Envelope is the envelope of the email, captured when it was submitted.
+	Envelope Envelope
+
+	// Result describes the recipients accepted and rejected, as returned by
+	// MailYak.Deliver, and may be nil.
+	Result *SendResult
+
+	// Err is the error sending the email, or nil if successful.
+	Err error
+
+	// Duration is the time taken to send the email, excluding the time spent
+	// queued.
+	Duration time.Duration
+}
+
+// BulkStats are aggregate statistics for the emails sent by a BulkSender.
+type BulkStats struct {
+	// Submitted is the number of emails submitted.
+	Submitted int
+
+	// Sent is the number of emails sent without error.
+	Sent int
+
+	// Failed is the number of emails that returned an error, including
+	// partial deliveries.
+	Failed int
+
+	// Accepted and Rejected are the total number of recipients accepted and
+	// rejected by the SMTP server, across all emails.
+	Accepted int
+	Rejected int
+
+	// Elapsed is the time since the BulkSender was created, or the total time
+	// taken once it has been shut down.
+	Elapsed time.Duration
+}
+
+// BulkSender sends emails concurrently using a fixed number of workers, as
+// created by MailYak.NewBulkSender.
+//
+// A BulkSender is safe for concurrent use.
+type BulkSender struct {
+	m       *MailYak
+	results chan<- BulkResult
+
+	queue     chan bulkItem
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// ctx is passed to each send, and is cancelled if Shutdown gives up
+	// waiting for in-flight sends to complete.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu is held for reading while submitting to queue, and for writing
+	// when closing it.
+	mu     sync.RWMutex
+	closed bool
+
+	wg sync.WaitGroup
+
+	statsMu  sync.Mutex
+	seq      int
+	stats    BulkStats
+	started  time.Time
+	finished time.Time
+}
+
+// bulkItem is an email queued for sending, and its envelope when submitted.
+type bulkItem struct {
+	seq      int
+	mail     *Mail
+	envelope Envelope
+}
+
+// NewBulkSender returns a BulkSender that sends emails using m with the given
+// number of workers (at least 1).
+//
+//	bulk := my.NewBulkSender(8, results)
+//	go func() {
+//		for r := range results {
+//			log.Printf("email %d: %v", r.Seq, r.Err)
+//		}
+//	}()
+//
+//	err := bulk.SendAll(ctx, mails)
+//	...
+//	stats, err := bulk.Shutdown(ctx)
+//
+// The outcome of each email is sent to results if non-nil, which must be
+// received from until it is closed by Shutdown. The workers block while
+// results is full.
+//
+// Each email is sent over a new SMTP connection unless session pooling has
+// been enabled on m with PoolSize - typically with a size of at least workers,
+// so each worker reuses its connection. m should then be closed with Close
+// once no longer needed.
+func (m *MailYak) NewBulkSender(workers int, results chan<- BulkResult) *BulkSender {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &BulkSender{
+		m:       m,
+		results: results,
+		queue:   make(chan bulkItem, workers),
+		closing: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		started: time.Now(),
+	}
+
+	b.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go b.worker()
+	}
+
+	return b
+}
+
+// Send queues mail to be sent by the next available worker, blocking while all
+// workers are busy and the queue is full.
+//
+// Send returns ErrBulkSenderClosed if Shutdown has been called, or ctx.Err()
+// if ctx is cancelled before mail is queued. If an error is returned mail was
+// not queued and may be reused, otherwise it is returned to the pool once sent
+// and must not be used again.
+func (b *BulkSender) Send(ctx context.Context, mail *Mail) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBulkSenderClosed
+	}
+
+	select {
+	case <-b.closing:
+		return ErrBulkSenderClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	item := bulkItem{mail: mail, envelope: mail.Envelope()}
+	b.statsMu.Lock()
+	item.seq = b.seq
+	b.seq++
+	b.stats.Submitted++
+	b.statsMu.Unlock()
+
+	select {
+	case b.queue <- item:
+		return nil
+	case <-b.closing:
+		b.unsubmit()
+		return ErrBulkSenderClosed
+	case <-ctx.Done():
+		b.unsubmit()
+		return ctx.Err()
+	}
+}
+
+// unsubmit reverts the submission count of an email that was not queued.
+//
+// The sequence number is not reused, so gaps in the sequence indicate emails
+// that were never queued.
+func (b *BulkSender) unsubmit() {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	b.stats.Submitted--
+}
+
+// SendAll queues each email received from mails until it is closed, returning
+// the first error from Send.
+func (b *BulkSender) SendAll(ctx context.Context, mails <-chan *Mail) error {
+	for {
+		select {
+		case mail, ok := <-mails:
+			if !ok {
+				return nil
+			}
+			if err := b.Send(ctx, mail); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SendEach queues each email returned by next until it returns nil, returning
+// the first error from Send.
+func (b *BulkSender) SendEach(ctx context.Context, next func() *Mail) error {
+	for mail := next(); mail != nil; mail = next() {
+		if err := b.Send(ctx, mail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the aggregate statistics.
+func (b *BulkSender) Stats() BulkStats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	stats := b.stats
+	if b.finished.IsZero() {
+		stats.Elapsed = time.Since(b.started)
+	} else {
+		stats.Elapsed = b.finished.Sub(b.started)
+	}
+	return stats
+}
+
+// Shutdown stops accepting new emails and waits for all queued and in-flight
+// emails to be sent, then closes the results channel and returns the final
+// statistics.
+//
+// If ctx is cancelled before all emails have been sent, the remaining sends
+// are aborted (failing with context.Canceled) and ctx.Err() is returned once
+// the workers have stopped.
+//
+// Shutdown does not close the MailYak instance.
+func (b *BulkSender) Shutdown(ctx context.Context) (BulkStats, error) {
+	// Unblock any pending calls to Send, then wait for them to return before
+	// closing the queue.
+	b.closeOnce.Do(func() { close(b.closing) })
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return b.Stats(), ErrBulkSenderClosed
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		b.cancel()
+		<-done
+	}
+	b.cancel()
+
+	if b.results != nil {
+		close(b.results)
+	}
+
+	b.statsMu.Lock()
+	b.finished = time.Now()
+	b.statsMu.Unlock()
+
+	return b.Stats(), err
+}
+
+// worker sends queued emails until the queue is closed.
+func (b *BulkSender) worker() {
+	defer b.wg.Done()
+
+	for item := range b.queue {
+		r := BulkResult{
+			Seq:      item.seq,
+			Envelope: item.envelope,
+		}
+
+		start := time.Now()
+		r.Result, r.Err = b.m.Deliver(b.ctx, item.mail)
+		r.Duration = time.Since(start)
+
+		b.record(r)
+		if b.results != nil {
+			b.results <- r
+		}
+	}
+}
+
+// record adds r to the aggregate statistics.
+func (b *BulkSender) record(r BulkResult) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	if r.Err == nil {
+		b.stats.Sent++
+	} else {
+		b.stats.Failed++
+	}
+	if r.Result != nil {
+		b.stats.Accepted += len(r.Result.Accepted)
+		b.stats.Rejected += len(r.Result.Rejected)
+	}
+}
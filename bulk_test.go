@@ -0,0 +1,302 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestBulkSender ensures every submitted email is sent and reported on the
+// results channel, with aggregate statistics.
+func TestBulkSender(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		submit func(ctx context.Context, b *BulkSender, mails []*Mail) error
+	}{
+		{
+			name: "send",
+			submit: func(ctx context.Context, b *BulkSender, mails []*Mail) error {
+				for _, mail := range mails {
+					if err := b.Send(ctx, mail); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name: "send all",
+			submit: func(ctx context.Context, b *BulkSender, mails []*Mail) error {
+				ch := make(chan *Mail)
+				go func() {
+					for _, mail := range mails {
+						ch <- mail
+					}
+					close(ch)
+				}()
+				return b.SendAll(ctx, ch)
+			},
+		},
+		{
+			name: "send each",
+			submit: func(ctx context.Context, b *BulkSender, mails []*Mail) error {
+				return b.SendEach(ctx, func() *Mail {
+					if len(mails) == 0 {
+						return nil
+					}
+					mail := mails[0]
+					mails = mails[1:]
+					return mail
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+			srv.AddRule(smtptest.Rule{Command: "RCPT", Arg: "bad@example.org", Code: 550, Message: "5.1.1 No such user"})
+
+			m := New(srv.Addr, nil)
+			defer m.Close()
+
+			var mails []*Mail
+			for i := 0; i < 20; i++ {
+				mail := m.NewMail()
+				mail.From("from@example.org")
+				if i%5 == 0 {
+					mail.To("bad@example.org")
+				} else {
+					mail.To(fmt.Sprintf("to%d@example.org", i))
+				}
+				mail.Plain().SetString("bananas")
+				mails = append(mails, mail)
+			}
+
+			results := make(chan BulkResult)
+			b := m.NewBulkSender(4, results)
+
+			var got []BulkResult
+			received := make(chan struct{})
+			go func() {
+				defer close(received)
+				for r := range results {
+					got = append(got, r)
+				}
+			}()
+
+			if err := tt.submit(context.Background(), b, mails); err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err := b.Shutdown(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-received
+
+			want := BulkStats{Submitted: 20, Sent: 16, Failed: 4, Accepted: 16, Rejected: 4}
+			stats.Elapsed = 0
+			if stats != want {
+				t.Errorf("got stats %+v, want %+v", stats, want)
+			}
+
+			if len(got) != 20 {
+				t.Fatalf("got %d results, want 20", len(got))
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Seq < got[j].Seq })
+			for i, r := range got {
+				if r.Seq != i {
+					t.Fatalf("got seq %d at %d", r.Seq, i)
+				}
+				if wantErr := i%5 == 0; (r.Err != nil) != wantErr {
+					t.Errorf("email %d (%v) got error %v", i, r.Envelope.To, r.Err)
+				}
+			}
+
+			if n := len(srv.Transactions()); n != 16 {
+				t.Errorf("got %d transactions, want 16", n)
+			}
+
+			if err := b.Send(context.Background(), m.NewMail()); err != ErrBulkSenderClosed {
+				t.Errorf("got error %v after shutdown, want %v", err, ErrBulkSenderClosed)
+			}
+		})
+	}
+}
+
+// TestBulkSenderShutdownTimeout ensures in-flight sends are aborted if the
+// Shutdown context is cancelled before they complete.
+func TestBulkSenderShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+	srv.AddRule(smtptest.Rule{Command: "MESSAGE", Delay: time.Second})
+
+	m := New(srv.Addr, nil)
+	defer m.Close()
+
+	b := m.NewBulkSender(1, nil)
+	for i := 0; i < 2; i++ {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+
+		if err := b.Send(context.Background(), mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	stats, err := b.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v", elapsed)
+	}
+	if stats.Submitted != 2 || stats.Failed != 2 {
+		t.Errorf("got stats %+v, want 2 submitted and failed", stats)
+	}
+}
+
+// TestBulkSenderSendContext ensures Send returns when ctx is cancelled while
+// the queue is full.
+func TestBulkSenderSendContext(t *testing.T) {
+	t.Parallel()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	m := NewWithTransport(&blockingTransport{block: block})
+	b := m.NewBulkSender(1, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+		err = b.Send(ctx, mail)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// One email is in flight, and one queued.
+	if got := b.Stats().Submitted; got != 2 {
+		t.Errorf("got %d submitted, want 2", got)
+	}
+}
+
+// TestBulkSenderPoolSize ensures NewBulkSender leaves session pooling as
+// configured with PoolSize, rather than enabling it itself.
+func TestBulkSenderPoolSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		poolSize int
+		want     int
+	}{
+		{name: "disabled", poolSize: 0, want: 0},
+		{name: "enabled", poolSize: 2, want: 2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			defer m.Close()
+			m.PoolSize(tt.poolSize)
+
+			b := m.NewBulkSender(3, nil)
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			if err := b.Send(context.Background(), mail); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			m.pool.mu.Lock()
+			defer m.pool.mu.Unlock()
+			if got := cap(m.pool.slots); got != tt.want {
+				t.Errorf("got pool size %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBulkSenderEnvelope ensures the envelope reported for each email is the
+// one it had when submitted.
+func TestBulkSenderEnvelope(t *testing.T) {
+	t.Parallel()
+
+	block := make(chan struct{})
+	m := NewWithTransport(&blockingTransport{block: block})
+
+	results := make(chan BulkResult, 2)
+	b := m.NewBulkSender(1, results)
+
+	var mails []*Mail
+	for _, to := range []string{"a@example.org", "b@example.org"} {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To(to)
+		if err := b.Send(context.Background(), mail); err != nil {
+			t.Fatal(err)
+		}
+		mails = append(mails, mail)
+	}
+
+	// The second email is queued behind the first, which is blocked in the
+	// transport, when it is changed.
+	mails[1].To("changed@example.org")
+	close(block)
+
+	if _, err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for r := range results {
+		want := []string{"a@example.org", "b@example.org"}[r.Seq]
+		if len(r.Envelope.To) != 1 || r.Envelope.To[0] != want {
+			t.Errorf("email %d got envelope to %q, want %q", r.Seq, r.Envelope.To, want)
+		}
+	}
+}
+
+// blockingTransport blocks each send until block is closed.
+type blockingTransport struct {
+	block chan struct{}
+}
+
+func (b *blockingTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	<-b.block
+	return nil, nil
+}
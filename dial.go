@@ -0,0 +1,131 @@
+package mailyak
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// DialFunc opens a connection to addr over the named network, as
+// net.Dialer.DialContext does.
+//
+// A DialFunc can be used to connect through a proxy (see SOCKS5 and
+// ProxyFromEnvironment), over a Unix socket, or with any other custom
+// connection logic.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dial opens a connection to addr using the configured DialFunc or
+// net.Dialer, applying the keep-alive settings and connection limit.
+func (c *smtpConfig) dial(ctx context.Context, addr string) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	release := func() {}
+	if c.limiter != nil {
+		release, err = c.limiter.acquireConn(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.dialFunc != nil {
+		conn, err = c.dialFunc(ctx, "tcp", addr)
+	} else {
+		d := &net.Dialer{}
+		if c.dialer != nil {
+			copied := *c.dialer
+			d = &copied
+		}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok && c.keepAlive != 0 {
+		if c.keepAlive < 0 {
+			_ = tcpConn.SetKeepAlive(false)
+		} else {
+			_ = tcpConn.SetKeepAlive(true)
+			_ = tcpConn.SetKeepAlivePeriod(c.keepAlive)
+		}
+	}
+
+	if c.limiter != nil {
+		return &limitedConn{Conn: conn, release: release}, nil
+	}
+	return conn, nil
+}
+
+// dialContext returns a context bounded by the configured dial timeout.
+func (c *smtpConfig) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.dialTimeout > 0 {
+		return context.WithTimeout(ctx, c.dialTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handshake calls fn to perform a handshake over conn, aborting any blocked IO
+// if ctx is cancelled or its deadline is exceeded before fn returns.
+//
+// The connection deadline is cleared once fn returns.
+func handshake(ctx context.Context, conn net.Conn, fn func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	close(done)
+	<-exited
+
+	if err != nil {
+		var netErr net.Error
+		if _, ok := ctx.Deadline(); ok && errors.As(err, &netErr) && netErr.Timeout() {
+			// The connection deadline was set from the context deadline, and
+			// may fire fractionally before the context is done.
+			<-ctx.Done()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// dialTLS opens a connection to addr and performs a TLS handshake according to
+// tlsConfig.
+func (c *smtpConfig) dialTLS(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	ctx, cancel := c.dialContext(ctx)
+	defer cancel()
+
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := handshake(ctx, conn, tlsConn.Handshake); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,127 @@
+package mailyak
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestDialOptions ensures the EHLO name and custom dial function are used by
+// both the STARTTLS and explicit TLS senders.
+func TestDialOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		mode smtptest.Mode
+		new  func(srv *smtptest.Server) (*MailYak, error)
+	}{
+		{
+			name: "starttls",
+			mode: smtptest.ModeStartTLS,
+			new: func(srv *smtptest.Server) (*MailYak, error) {
+				m := New("127.0.0.1:25", nil)
+				m.StartTLSConfig(srv.ClientTLSConfig())
+				return m, nil
+			},
+		},
+		{
+			name: "explicit tls",
+			mode: smtptest.ModeImplicitTLS,
+			new: func(srv *smtptest.Server) (*MailYak, error) {
+				return NewWithTLS("127.0.0.1:465", nil, srv.ClientTLSConfig())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(tt.mode)
+			defer srv.Close()
+
+			m, err := tt.new(srv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Redirect connections for the configured address to the test
+			// server.
+			var dialed []string
+			m.DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed = append(dialed, addr)
+				d := &net.Dialer{}
+				return d.DialContext(ctx, network, srv.Addr)
+			})
+			m.LocalName("mail.example.org")
+			m.KeepAlive(time.Minute)
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			if err := m.Send(mail); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(dialed) != 1 || dialed[0] == srv.Addr {
+				t.Errorf("got dials %v, want 1 dial to configured host", dialed)
+			}
+
+			txns := srv.Transactions()
+			if len(txns) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txns))
+			}
+			if txns[0].Hello != "mail.example.org" {
+				t.Errorf("got EHLO %q, want %q", txns[0].Hello, "mail.example.org")
+			}
+			if !txns[0].TLS {
+				t.Error("message not sent over TLS")
+			}
+		})
+	}
+}
+
+// TestDialTimeoutTLS ensures the dial timeout bounds the TLS handshake of
+// explicit TLS connections.
+func TestDialTimeoutTLS(t *testing.T) {
+	t.Parallel()
+
+	// Accept connections, but never respond to the TLS handshake.
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	go func() {
+		conn, err := socket.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+
+	m, err := NewWithTLS(socket.Addr().String(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DialTimeout(50 * time.Millisecond)
+
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+
+	start := time.Now()
+	if err := m.Send(mail); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("send returned after %v", d)
+	}
+}
@@ -0,0 +1,166 @@
+package mailyak
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrDSNUnsupported is returned when an email requires delivery status
+// notifications (see DSN.Required), but the SMTP server does not support the
+// DSN extension.
+var ErrDSNUnsupported = errors.New("mailyak: server does not support DSN")
+
+// DSNReturn controls how much of the email is returned in a failure
+// notification.
+type DSNReturn string
+
+const (
+	// DSNReturnFull requests the full email is returned (RET=FULL).
+	DSNReturnFull DSNReturn = "FULL"
+
+	// DSNReturnHeaders requests only the email headers are returned
+	// (RET=HDRS).
+	DSNReturnHeaders DSNReturn = "HDRS"
+)
+
+// DSNNotify is a set of conditions to send a delivery status notification for,
+// combined with bitwise OR.
+type DSNNotify int
+
+const (
+	// DSNNotifySuccess requests a notification on successful delivery.
+	DSNNotifySuccess DSNNotify = 1 << iota
+
+	// DSNNotifyFailure requests a notification if delivery fails.
+	DSNNotifyFailure
+
+	// DSNNotifyDelay requests a notification if delivery is delayed.
+	DSNNotifyDelay
+
+	// DSNNotifyNever requests no notifications are sent, and cannot be
+	// combined with the other conditions.
+	DSNNotifyNever
+)
+
+// String returns the value of the NOTIFY parameter for n, such as
+// "SUCCESS,FAILURE".
+func (n DSNNotify) String() string {
+	if n&DSNNotifyNever != 0 {
+		return "NEVER"
+	}
+
+	var conditions []string
+	if n&DSNNotifySuccess != 0 {
+		conditions = append(conditions, "SUCCESS")
+	}
+	if n&DSNNotifyFailure != 0 {
+		conditions = append(conditions, "FAILURE")
+	}
+	if n&DSNNotifyDelay != 0 {
+		conditions = append(conditions, "DELAY")
+	}
+	return strings.Join(conditions, ",")
+}
+
+// DSN configures the delivery status notifications (RFC 3461) requested for
+// an email, as set with Mail.DSN.
+type DSN struct {
+	// Return controls how much of the email is returned in a failure
+	// notification (the RET parameter). If empty, the server decides.
+	Return DSNReturn
+
+	// EnvelopeID is an identifier included in any notification, allowing it
+	// to be matched to the email (the ENVID parameter). Optional.
+	EnvelopeID string
+
+	// Notify is the set of conditions notifications are sent for (the
+	// NOTIFY parameter). If 0, the server decides - typically notifying of
+	// failures and delays only.
+	Notify DSNNotify
+
+	// OriginalRecipient includes each recipient address in the notifications
+	// sent for it (the ORCPT parameter), allowing the recipient to be
+	// identified if the address is rewritten in transit.
+	OriginalRecipient bool
+
+	// Required fails the send with ErrDSNUnsupported if the SMTP server does
+	// not support DSN. If false, the email is sent without requesting
+	// notifications.
+	Required bool
+}
+
+// dsnMessage is implemented by messages requesting delivery status
+// notifications, such as a Mail with DSN set.
+type dsnMessage interface {
+	// getDSN should return the DSN options if configured, nil if not.
+	getDSN() *DSN
+}
+
+// extensionChecker reports whether the SMTP server supports an extension, as
+// implemented by smtpclient.Client.
+type extensionChecker interface {
+	Extension(ext string) (bool, string)
+}
+
+// dsnParams returns the ESMTP parameters requesting the notifications set on
+// msg, for the MAIL FROM command and each RCPT TO command.
+//
+// If the server does not support DSN, no parameters are returned, or
+// ErrDSNUnsupported if they are required.
+func dsnParams(c extensionChecker, msg Message) ([]string, func(addr string) []string, error) {
+	noRcptParams := func(string) []string { return nil }
+
+	m, ok := msg.(dsnMessage)
+	if !ok || m.getDSN() == nil {
+		return nil, noRcptParams, nil
+	}
+	dsn := m.getDSN()
+
+	if ok, _ := c.Extension("DSN"); !ok {
+		if dsn.Required {
+			return nil, noRcptParams, ErrDSNUnsupported
+		}
+		return nil, noRcptParams, nil
+	}
+
+	var mailParams []string
+	if dsn.Return != "" {
+		mailParams = append(mailParams, "RET="+string(dsn.Return))
+	}
+	if dsn.EnvelopeID != "" {
+		mailParams = append(mailParams, "ENVID="+xtext(dsn.EnvelopeID))
+	}
+
+	rcptParams := func(addr string) []string {
+		var params []string
+		if dsn.Notify != 0 {
+			params = append(params, "NOTIFY="+dsn.Notify.String())
+		}
+		if dsn.OriginalRecipient {
+			params = append(params, "ORCPT=rfc822;"+xtext(addr))
+		}
+		return params
+	}
+
+	return mailParams, rcptParams, nil
+}
+
+// xtext encodes s as xtext (RFC 3461, section 4), escaping "+", "=" and any
+// characters outside the printable ASCII range as "+" followed by two
+// uppercase hex digits.
+func xtext(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			b.WriteByte('+')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
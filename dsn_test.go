@@ -0,0 +1,215 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestDSN ensures DSN parameters are sent on the MAIL FROM and RCPT TO
+// commands only when the server supports them.
+func TestDSN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		extensions []string
+		dsn        *DSN
+
+		wantErr        error
+		wantMailParams []string
+		wantRcptParams [][]string
+	}{
+		{
+			name:       "all parameters",
+			extensions: []string{"DSN"},
+			dsn: &DSN{
+				Return:            DSNReturnHeaders,
+				EnvelopeID:        "invoice+1234",
+				Notify:            DSNNotifySuccess | DSNNotifyFailure,
+				OriginalRecipient: true,
+			},
+			wantMailParams: []string{"RET=HDRS", "ENVID=invoice+2B1234"},
+			wantRcptParams: [][]string{
+				{"NOTIFY=SUCCESS,FAILURE", "ORCPT=rfc822;a@example.org"},
+				{"NOTIFY=SUCCESS,FAILURE", "ORCPT=rfc822;b+2Btag@example.org"},
+			},
+		},
+		{
+			name:           "never notify",
+			extensions:     []string{"DSN"},
+			dsn:            &DSN{Notify: DSNNotifyNever | DSNNotifyFailure},
+			wantRcptParams: [][]string{{"NOTIFY=NEVER"}, {"NOTIFY=NEVER"}},
+		},
+		{
+			name:           "not requested",
+			extensions:     []string{"DSN"},
+			wantRcptParams: [][]string{nil, nil},
+		},
+		{
+			name:           "unsupported fallback",
+			dsn:            &DSN{Return: DSNReturnFull, Notify: DSNNotifyFailure},
+			wantRcptParams: [][]string{nil, nil},
+		},
+		{
+			name:    "unsupported required",
+			dsn:     &DSN{Return: DSNReturnFull, Notify: DSNNotifyFailure, Required: true},
+			wantErr: ErrDSNUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("a@example.org", "b+tag@example.org")
+			if tt.dsn != nil {
+				mail.DSN(*tt.dsn)
+			}
+
+			if err := m.Send(mail); err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			txs := srv.Transactions()
+			if tt.wantErr != nil {
+				if len(txs) != 0 {
+					t.Errorf("got %d transactions, want 0", len(txs))
+				}
+				return
+			}
+
+			if len(txs) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txs))
+			}
+			if !reflect.DeepEqual(txs[0].MailParams, tt.wantMailParams) {
+				t.Errorf("got MAIL params %q, want %q", txs[0].MailParams, tt.wantMailParams)
+			}
+			if !reflect.DeepEqual(txs[0].RcptParams, tt.wantRcptParams) {
+				t.Errorf("got RCPT params %q, want %q", txs[0].RcptParams, tt.wantRcptParams)
+			}
+		})
+	}
+}
+
+// TestDSNRequired ensures an email requiring DSN is not sent to a server
+// without DSN support when sent with VERP, by MX lookup or from a Queue.
+func TestDSNRequired(t *testing.T) {
+	t.Parallel()
+
+	newMail := func(m *MailYak) *Mail {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("a@example.org", "b@example.org")
+		mail.DSN(DSN{Notify: DSNNotifyFailure, Required: true})
+		return mail
+	}
+
+	tests := []struct {
+		name string
+		send func(t *testing.T, addr string) error
+	}{
+		{
+			name: "verp",
+			send: func(t *testing.T, addr string) error {
+				m := New(addr, nil)
+				mail := newMail(m)
+				mail.VERP("bounces+{local}={domain}@example.org")
+				return m.Send(mail)
+			},
+		},
+		{
+			name: "mx",
+			send: func(t *testing.T, addr string) error {
+				m := NewMX(stubResolver{
+					"example.org": {{Host: "mx.example.org.", Pref: 10}},
+				})
+				m.DialFunc(func(ctx context.Context, network, _ string) (net.Conn, error) {
+					d := &net.Dialer{}
+					return d.DialContext(ctx, network, addr)
+				})
+				return m.Send(newMail(m))
+			},
+		},
+		{
+			name: "queue",
+			send: func(t *testing.T, addr string) error {
+				m := New(addr, nil)
+				bounced := make(chan error, 1)
+				q, err := OpenQueue(t.TempDir(), m.Transport(), QueueOptions{
+					Bounce: func(_ QueueItem, err error) {
+						bounced <- err
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer q.Close()
+
+				if _, err := q.Enqueue(newMail(m)); err != nil {
+					t.Fatal(err)
+				}
+
+				select {
+				case err := <-bounced:
+					return err
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for bounce")
+					return nil
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+
+			if err := tt.send(t, srv.Addr); !errors.Is(err, ErrDSNUnsupported) {
+				t.Fatalf("got error %v, want %v", err, ErrDSNUnsupported)
+			}
+			if txs := srv.Transactions(); len(txs) != 0 {
+				t.Errorf("got %d transactions, want 0", len(txs))
+			}
+		})
+	}
+}
+
+// TestXText ensures strings are encoded as xtext.
+func TestXText(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a+b=c", "a+2Bb+3Dc"},
+		{"with space", "with+20space"},
+		{"café", "caf+C3+A9"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := xtext(tt.in); got != tt.want {
+			t.Errorf("xtext(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
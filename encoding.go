@@ -0,0 +1,125 @@
+package mailyak
+
+import "io"
+
+// transferEncoding is the most compact set of Content-Transfer-Encodings the
+// SMTP server accepts for the email content.
+type transferEncoding int
+
+const (
+	// encoding7Bit quoted-printable encodes the body and base64 encodes
+	// attachments, as accepted by every server.
+	encoding7Bit transferEncoding = iota
+
+	// encoding8Bit sends the body as 8bit where possible, for servers
+	// supporting 8BITMIME (RFC 6152).
+	encoding8Bit
+
+	// encodingBinary sends the body as 8bit (or binary) and attachments as
+	// binary, for servers supporting BINARYMIME and CHUNKING (RFC 3030).
+	encodingBinary
+)
+
+// max8BitLineLen is the maximum length of a line of 8bit content, excluding
+// the CRLF (RFC 2045, section 2.8).
+const max8BitLineLen = 998
+
+// bdatChunkSize is the maximum size of each chunk of content sent with BDAT.
+const bdatChunkSize = 1 << 20
+
+// encodedMessage is implemented by messages able to use the 8bit and binary
+// transfer encodings when supported by the server, such as Mail.
+type encodedMessage interface {
+	// writeMIMEEncoded should write the MIME content of the message using
+	// the transfer encodings allowed by enc.
+	writeMIMEEncoded(w io.Writer, enc transferEncoding) error
+}
+
+// transferMode returns the transfer encoding to write msg with, the BODY
+// parameter for the MAIL FROM command (if any), and true if the content
+// should be sent with BDAT rather than DATA.
+//
+// BDAT is only used for BODY=BINARYMIME, which cannot be sent with DATA. The
+// content is sent with BDAT exactly as written, whereas DATA converts any bare
+// LF line breaks to CRLF as RFC 5321 requires.
+func transferMode(c extensionChecker, msg Message) (transferEncoding, string, bool) {
+	chunking, _ := c.Extension("CHUNKING")
+	binary, _ := c.Extension("BINARYMIME")
+	eightBit, _ := c.Extension("8BITMIME")
+
+	_, encodable := msg.(encodedMessage)
+	switch {
+	case encodable && chunking && binary:
+		return encodingBinary, "BODY=BINARYMIME", true
+	case eightBit:
+		// As with smtp.Client.Mail, BODY=8BITMIME is sent whenever it is
+		// supported.
+		return encoding8Bit, "BODY=8BITMIME", false
+	default:
+		return encoding7Bit, "", false
+	}
+}
+
+// writeMIMEEncoded writes msg to w, using the transfer encodings allowed by enc
+// if msg supports them.
+func writeMIMEEncoded(w io.Writer, msg Message, enc transferEncoding) error {
+	if m, ok := msg.(encodedMessage); ok {
+		return m.writeMIMEEncoded(w, enc)
+	}
+	return msg.WriteMIME(w)
+}
+
+// bodyTransferEncoding returns the Content-Transfer-Encoding to write the text
+// body data with.
+//
+// The body is sent as 8bit if allowed by enc and data is valid 8bit content,
+// as binary if it is not but enc allows binary, and otherwise as
+// quoted-printable.
+func bodyTransferEncoding(data []byte, enc transferEncoding) string {
+	switch {
+	case enc == encoding7Bit:
+		return "quoted-printable"
+	case is8BitSafe(data):
+		return "8bit"
+	case enc == encodingBinary:
+		return "binary"
+	default:
+		return "quoted-printable"
+	}
+}
+
+// is8BitSafe returns true if data can be sent as 8bit content once any bare
+// LF line breaks are converted to CRLF - it contains no NUL or bare CR bytes,
+// and no lines longer than max8BitLineLen.
+func is8BitSafe(data []byte) bool {
+	lineLen := 0
+	for i, c := range data {
+		switch c {
+		case 0:
+			return false
+		case '\r':
+			if i+1 == len(data) || data[i+1] != '\n' {
+				return false
+			}
+		case '\n':
+			lineLen = 0
+		default:
+			if lineLen++; lineLen > max8BitLineLen {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// toCRLF returns data with any bare LF line breaks converted to CRLF.
+func toCRLF(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
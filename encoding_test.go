@@ -0,0 +1,199 @@
+package mailyak
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestTransferEncoding ensures the body and attachments are sent using the
+// most compact encodings supported by the server, in BDAT chunks for
+// BINARYMIME, and with every line ending in CRLF.
+func TestTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	// Content that must be escaped by both quoted-printable and base64.
+	attachment := []byte("\x00\x01binary=data\xff")
+
+	tests := []struct {
+		name       string
+		extensions []string
+
+		wantMailParams []string
+		wantBody       string
+		wantAttachment string
+		wantChunks     int
+	}{
+		{
+			name:           "no extensions",
+			wantBody:       "quoted-printable",
+			wantAttachment: "base64",
+		},
+		{
+			name:           "8bitmime",
+			extensions:     []string{"8BITMIME"},
+			wantMailParams: []string{"BODY=8BITMIME"},
+			wantBody:       "8bit",
+			wantAttachment: "base64",
+		},
+		{
+			name:           "chunking",
+			extensions:     []string{"CHUNKING"},
+			wantBody:       "quoted-printable",
+			wantAttachment: "base64",
+		},
+		{
+			name:           "8bitmime with chunking",
+			extensions:     []string{"8BITMIME", "CHUNKING"},
+			wantMailParams: []string{"BODY=8BITMIME"},
+			wantBody:       "8bit",
+			wantAttachment: "base64",
+		},
+		{
+			name:           "binarymime without chunking",
+			extensions:     []string{"8BITMIME", "BINARYMIME"},
+			wantMailParams: []string{"BODY=8BITMIME"},
+			wantBody:       "8bit",
+			wantAttachment: "base64",
+		},
+		{
+			name:           "binarymime",
+			extensions:     []string{"8BITMIME", "BINARYMIME", "CHUNKING"},
+			wantMailParams: []string{"BODY=BINARYMIME"},
+			wantBody:       "8bit",
+			wantAttachment: "binary",
+			wantChunks:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			mail.Plain().SetString("Grüße aus München\n")
+			mail.AttachWithMimeType("data.bin", bytes.NewReader(attachment), "application/octet-stream")
+
+			if err := m.Send(mail); err != nil {
+				t.Fatal(err)
+			}
+
+			txs := srv.Transactions()
+			if len(txs) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txs))
+			}
+			tx := txs[0]
+
+			if !reflect.DeepEqual(tx.MailParams, tt.wantMailParams) {
+				t.Errorf("got MAIL params %q, want %q", tx.MailParams, tt.wantMailParams)
+			}
+			if tx.Chunks != tt.wantChunks {
+				t.Errorf("got %d BDAT chunks, want %d", tx.Chunks, tt.wantChunks)
+			}
+
+			if i := bareLF(tx.Data); i >= 0 {
+				t.Errorf("got bare LF at offset %d:\n%s", i, tx.Data)
+			}
+
+			data := string(tx.Data)
+			if want := "Content-Transfer-Encoding: " + tt.wantBody + "\r\nContent-Type: text/plain"; !strings.Contains(data, want) {
+				t.Errorf("body not sent as %s:\n%s", tt.wantBody, data)
+			}
+			if tt.wantBody == "8bit" && !strings.Contains(data, "Grüße aus München\r\n") {
+				t.Errorf("8bit body not found:\n%s", data)
+			}
+
+			if want := "Content-Transfer-Encoding: " + tt.wantAttachment + "\r\n"; !strings.Contains(data, want) {
+				t.Errorf("attachment not sent as %s:\n%s", tt.wantAttachment, data)
+			}
+			if got := bytes.Contains(tx.Data, attachment); got != (tt.wantAttachment == "binary") {
+				t.Errorf("got raw attachment content %v, want %v", got, tt.wantAttachment == "binary")
+			}
+		})
+	}
+}
+
+// bareLF returns the offset of the first LF in data not preceded by a CR, or
+// -1 if there is none.
+func bareLF(data []byte) int {
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestTransferEncodingChunks ensures content larger than a single chunk is
+// sent in multiple BDAT commands.
+func TestTransferEncodingChunks(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+	srv.Extensions = []string{"8BITMIME", "BINARYMIME", "CHUNKING"}
+	srv.Start()
+	defer srv.Close()
+
+	attachment := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, bdatChunkSize/2)
+
+	m := New(srv.Addr, nil)
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Plain().SetString("bananas")
+	mail.Attach("large.bin", bytes.NewReader(attachment))
+
+	if err := m.Send(mail); err != nil {
+		t.Fatal(err)
+	}
+
+	txs := srv.Transactions()
+	if len(txs) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(txs))
+	}
+	if txs[0].Chunks != 3 {
+		t.Errorf("got %d BDAT chunks, want 3", txs[0].Chunks)
+	}
+	if !bytes.Contains(txs[0].Data, attachment) {
+		t.Error("attachment content not found")
+	}
+}
+
+// TestBodyTransferEncoding ensures bodies are only sent as 8bit when they are
+// valid 8bit content.
+func TestBodyTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data string
+		enc  transferEncoding
+		want string
+	}{
+		{"7bit", "Grüße", encoding7Bit, "quoted-printable"},
+		{"8bit", "Grüße\r\nline\n", encoding8Bit, "8bit"},
+		{"long line", strings.Repeat("a", max8BitLineLen+1), encoding8Bit, "quoted-printable"},
+		{"max line", strings.Repeat("a", max8BitLineLen) + "\r\n", encoding8Bit, "8bit"},
+		{"nul", "a\x00b", encoding8Bit, "quoted-printable"},
+		{"bare cr", "a\rb", encoding8Bit, "quoted-printable"},
+		{"binary", "a\rb", encodingBinary, "binary"},
+	}
+
+	for _, tt := range tests {
+		if got := bodyTransferEncoding([]byte(tt.data), tt.enc); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package mailyak
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xenking/mailyak/v3/internal/smtpclient"
+)
+
+// enhancedCodeRegex matches a RFC 3463 enhanced status code at the start of a
+// SMTP reply line, such as "5.1.1".
+var enhancedCodeRegex = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})(?:\s+|$)`)
+
+// SMTPError is an error reply received from the SMTP server.
+//
+// Use errors.As to inspect the reply code of an error returned by Send:
+//
+//	var smtpErr *mailyak.SMTPError
+//	if errors.As(err, &smtpErr) && smtpErr.Temporary() {
+//		// Try again later
+//	}
+type SMTPError struct {
+	// Code is the three digit SMTP reply code, such as 550.
+	Code int
+
+	// EnhancedCode is the RFC 3463 enhanced status code included in the
+	// reply, such as "5.1.1", or empty if the server did not send one.
+	EnhancedCode string
+
+	// Message is the human readable reply text, excluding the reply code and
+	// enhanced status code. Multi-line replies are separated by "\n".
+	Message string
+}
+
+// Error returns the reply as sent by the server, such as
+// "550 5.1.1 No such user".
+func (e *SMTPError) Error() string {
+	if e.EnhancedCode == "" {
+		return fmt.Sprintf("%03d %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%03d %s %s", e.Code, e.EnhancedCode, e.Message)
+}
+
+// Temporary returns true for 4xx transient failures, where retrying the same
+// request later may succeed.
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// Permanent returns true for 5xx permanent failures, where retrying the same
+// request will not succeed.
+func (e *SMTPError) Permanent() bool {
+	return e.Code >= 500 && e.Code < 600
+}
+
+// newSMTPError parses the reply text msg into a SMTPError with the given reply
+// code, splitting out any enhanced status code.
+func newSMTPError(code int, msg string) *SMTPError {
+	enhanced, msg := splitEnhancedCode(msg)
+	return &SMTPError{
+		Code:         code,
+		EnhancedCode: enhanced,
+		Message:      msg,
+	}
+}
+
+// splitEnhancedCode extracts the RFC 3463 enhanced status code from the reply
+// text msg, returning the code and the text with the code removed from each
+// line.
+//
+// If msg does not start with an enhanced status code, an empty code and the
+// unmodified msg are returned.
+func splitEnhancedCode(msg string) (string, string) {
+	match := enhancedCodeRegex.FindStringSubmatch(msg)
+	if match == nil {
+		return "", msg
+	}
+	code := match[1]
+
+	// Multi-line replies repeat the enhanced code on each line.
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		if m := enhancedCodeRegex.FindStringSubmatch(line); m != nil && m[1] == code {
+			lines[i] = line[len(m[0]):]
+		}
+	}
+
+	return code, strings.Join(lines, "\n")
+}
+
+// toSMTPError converts an error reply returned by the SMTP client (or a
+// textproto.Error) into a SMTPError, returning all other errors unmodified.
+func toSMTPError(err error) error {
+	var replyErr *smtpclient.Error
+	if errors.As(err, &replyErr) {
+		return newSMTPError(replyErr.Reply.Code, replyErr.Reply.Text())
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return newSMTPError(protoErr.Code, protoErr.Msg)
+	}
+	return err
+}
+
+// RecipientStatus describes the SMTP server's reply to the RCPT TO command for
+// a single recipient.
+type RecipientStatus struct {
+	// Address is the recipient email address.
+	Address string
+
+	// Code is the three digit SMTP reply code, such as 250 or 550.
+	Code int
+
+	// EnhancedCode is the RFC 3463 enhanced status code included in the
+	// reply, such as "2.1.5", or empty if the server did not send one.
+	EnhancedCode string
+
+	// Message is the human readable reply text.
+	Message string
+}
+
+// Err returns the reply as a SMTPError, or nil if the recipient was accepted.
+func (r RecipientStatus) Err() *SMTPError {
+	if r.Code < 400 {
+		return nil
+	}
+	return &SMTPError{
+		Code:         r.Code,
+		EnhancedCode: r.EnhancedCode,
+		Message:      r.Message,
+	}
+}
+
+// newRecipientStatus parses the RCPT TO reply for addr.
+func newRecipientStatus(addr string, code int, msg string) RecipientStatus {
+	enhanced, msg := splitEnhancedCode(msg)
+	return RecipientStatus{
+		Address:      addr,
+		Code:         code,
+		EnhancedCode: enhanced,
+		Message:      msg,
+	}
+}
+
+// SendResult describes the outcome of sending an email.
+type SendResult struct {
+	// Accepted lists the recipients accepted by the SMTP server.
+	Accepted []RecipientStatus
+
+	// Rejected lists the recipients refused by the SMTP server.
+	Rejected []RecipientStatus
+
+	// Endpoint is the address of the SMTP server the email was sent to when
+	// using NewWithFailover, and is empty otherwise.
+	Endpoint string
+
+	// Domains describes the outcome for each recipient domain when
+	// delivering directly to MX hosts (see NewMX), and is nil otherwise.
+	Domains []DomainResult
+}
+
+// partialDelivery is implemented by the errors returned when an email was
+// delivered to some recipients, but not others: *PartialDeliveryError,
+// *SplitDeliveryError, *VERPDeliveryError and *MXDeliveryError.
+//
+// Sending the email again would deliver a duplicate to the recipients that
+// already received it.
+type partialDelivery interface {
+	error
+	partialDelivery()
+}
+
+// isPartialDelivery returns true if err is (or wraps) an error reporting that
+// the email was delivered to some of the recipients.
+func isPartialDelivery(err error) bool {
+	var p partialDelivery
+	return errors.As(err, &p)
+}
+
+// PartialDeliveryError is returned when partial delivery is allowed and the
+// email was accepted for some, but not all of the recipients.
+//
+// The email was sent to the accepted recipients.
+type PartialDeliveryError struct {
+	// Rejected lists the recipients refused by the SMTP server.
+	Rejected []RecipientStatus
+}
+
+func (e *PartialDeliveryError) partialDelivery() {}
+
+// Error returns a description of the rejected recipients.
+func (e *PartialDeliveryError) Error() string {
+	var b strings.Builder
+	b.WriteString("mailyak: ")
+	b.WriteString(strconv.Itoa(len(e.Rejected)))
+	b.WriteString(" recipient(s) rejected: ")
+
+	for i, r := range e.Rejected {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Address)
+		b.WriteString(" (")
+		b.WriteString(r.Err().Error())
+		b.WriteString(")")
+	}
+
+	return b.String()
+}
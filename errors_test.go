@@ -0,0 +1,97 @@
+package mailyak
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/internal/smtpclient"
+)
+
+// TestToSMTPError ensures SMTP client and textproto errors are converted into
+// SMTPError instances with the enhanced status code split from the reply text.
+func TestToSMTPError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		err           error
+		want          error
+		wantString    string
+		wantTemporary bool
+		wantPermanent bool
+	}{
+		{
+			name:          "enhanced code",
+			err:           &textproto.Error{Code: 550, Msg: "5.1.1 No such user"},
+			want:          &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+			wantString:    "550 5.1.1 No such user",
+			wantPermanent: true,
+		},
+		{
+			name:          "no enhanced code",
+			err:           &textproto.Error{Code: 451, Msg: "Greylisted, try again later"},
+			want:          &SMTPError{Code: 451, Message: "Greylisted, try again later"},
+			wantString:    "451 Greylisted, try again later",
+			wantTemporary: true,
+		},
+		{
+			name:          "multi-line",
+			err:           &textproto.Error{Code: 452, Msg: "4.5.3 Too many recipients\n4.5.3 Try again"},
+			want:          &SMTPError{Code: 452, EnhancedCode: "4.5.3", Message: "Too many recipients\nTry again"},
+			wantString:    "452 4.5.3 Too many recipients\nTry again",
+			wantTemporary: true,
+		},
+		{
+			name:          "wrapped",
+			err:           fmt.Errorf("wrapped: %w", &textproto.Error{Code: 554, Msg: "5.7.1 Relay denied"}),
+			want:          &SMTPError{Code: 554, EnhancedCode: "5.7.1", Message: "Relay denied"},
+			wantString:    "554 5.7.1 Relay denied",
+			wantPermanent: true,
+		},
+		{
+			name: "client reply",
+			err: &smtpclient.Error{Reply: smtpclient.Reply{
+				Code:  452,
+				Lines: []string{"4.5.3 Too many recipients", "4.5.3 Try again"},
+			}},
+			want:          &SMTPError{Code: 452, EnhancedCode: "4.5.3", Message: "Too many recipients\nTry again"},
+			wantString:    "452 4.5.3 Too many recipients\nTry again",
+			wantTemporary: true,
+		},
+		{
+			name: "not a protocol error",
+			err:  errors.New("bananas"),
+			want: errors.New("bananas"),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := toSMTPError(tt.err)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+
+			smtpErr, ok := got.(*SMTPError)
+			if !ok {
+				return
+			}
+
+			if got := smtpErr.Error(); got != tt.wantString {
+				t.Errorf("Error() = %q, want %q", got, tt.wantString)
+			}
+			if got := smtpErr.Temporary(); got != tt.wantTemporary {
+				t.Errorf("Temporary() = %v, want %v", got, tt.wantTemporary)
+			}
+			if got := smtpErr.Permanent(); got != tt.wantPermanent {
+				t.Errorf("Permanent() = %v, want %v", got, tt.wantPermanent)
+			}
+		})
+	}
+}
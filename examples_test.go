@@ -4,9 +4,13 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net/smtp"
+	"os"
+	"time"
 )
 
 func Example() {
@@ -148,3 +152,53 @@ func ExampleMail_AttachInline() {
 		panic(" :( ")
 	}
 }
+
+func ExampleMailYak_SendContext() {
+	my := New("mail.host.com:25", smtp.PlainAuth("", "user", "pass", "mail.host.com"))
+
+	// Bound the time spent on each phase of the SMTP conversation.
+	my.DialTimeout(5 * time.Second)
+	my.CommandTimeout(10 * time.Second)
+	my.DataTimeout(time.Minute)
+
+	mail := my.NewMail()
+	mail.To("dom@itsallbroken.com")
+	mail.From("jsmith@example.com")
+	mail.Plain().SetString("Don't keep me waiting")
+
+	// Give up on the send entirely after 30 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := my.SendContext(ctx, mail); err != nil {
+		panic(" :( ")
+	}
+}
+
+// loggingTransport is an example Transport that writes emails to an io.Writer.
+type loggingTransport struct {
+	w io.Writer
+}
+
+func (l *loggingTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	// The envelope contains the sender and all recipients, including BCC.
+	_, _ = fmt.Fprintf(l.w, "From: %s, To: %v\n", msg.Envelope().From, msg.Envelope().To)
+
+	// Write the raw MIME content of the email.
+	return nil, msg.WriteMIME(l.w)
+}
+
+func ExampleNewWithTransport() {
+	// Create a MailYak instance that delivers emails using a custom
+	// Transport, such as a HTTP API client or local spool.
+	my := NewWithTransport(&loggingTransport{w: os.Stdout})
+
+	mail := my.NewMail()
+	mail.To("dom@itsallbroken.com")
+	mail.From("jsmith@example.com")
+	mail.Plain().SetString("Delivered without SMTP")
+
+	if err := my.Send(mail); err != nil {
+		panic(" :( ")
+	}
+}
@@ -0,0 +1,202 @@
+package mailyak
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// defaultFailoverCooldown is the time an endpoint is skipped for after a
+// failure, unless changed with FailoverCooldown.
+const defaultFailoverCooldown = 30 * time.Second
+
+// Endpoint is a SMTP server used by NewWithFailover.
+type Endpoint struct {
+	// Host is the address of the SMTP server, including the port number (i.e.
+	// "smtp.itsallbroken.com:587").
+	Host string
+
+	// Auth is used to authenticate with the SMTP server if non-nil.
+	Auth smtp.Auth
+
+	// TLS connects to the SMTP server over an explicit TLS connection, as
+	// NewWithTLS does. If false, the connection is upgraded with STARTTLS
+	// according to the configured StartTLSPolicy, as New does.
+	TLS bool
+
+	// TLSConfig is used for the TLS connection or STARTTLS upgrade. If nil,
+	// the StartTLSConfig is used for STARTTLS upgrades, and a default
+	// configuration for explicit TLS connections.
+	TLSConfig *tls.Config
+}
+
+// NewWithFailover returns an instance of MailYak that sends emails using the
+// first available SMTP server in endpoints.
+//
+//	my, err := mailyak.NewWithFailover([]mailyak.Endpoint{
+//		{Host: "primary.example.org:465", Auth: primaryAuth, TLS: true},
+//		{Host: "secondary.example.org:587", Auth: secondaryAuth},
+//	})
+//
+// If an endpoint cannot be connected to, or responds with a temporary (4xx)
+// error, the email is sent using the next endpoint. The failed endpoint is
+// then skipped for subsequent emails until the FailoverCooldown has passed,
+// unless all endpoints are cooling down. Permanent (5xx) errors are returned
+// without trying the next endpoint.
+//
+// The endpoint the email was sent to is reported in SendResult.Endpoint by
+// Deliver. If every endpoint fails, the error from the last endpoint is
+// returned.
+//
+// Connections are not pooled, and PoolSize has no effect.
+func NewWithFailover(endpoints []Endpoint) (*MailYak, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("mailyak: no endpoints")
+	}
+
+	config := &smtpConfig{}
+	t := &failoverTransport{
+		config:    config,
+		endpoints: make([]Endpoint, len(endpoints)),
+		cooldown:  defaultFailoverCooldown,
+		unhealthy: make(map[int]time.Time),
+	}
+	copy(t.endpoints, endpoints)
+
+	// Validate the endpoints up front, rather than on the first send.
+	for _, ep := range t.endpoints {
+		if _, err := t.sender(ep); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MailYak{
+		sender: t,
+		config: config,
+	}, nil
+}
+
+// FailoverCooldown sets the duration an endpoint is skipped for after failing,
+// for MailYak instances created with NewWithFailover. Defaults to 30 seconds.
+//
+// A duration of 0 always tries every endpoint in order.
+func (m *MailYak) FailoverCooldown(d time.Duration) {
+	if t, ok := m.sender.(*failoverTransport); ok {
+		t.setCooldown(d)
+	}
+}
+
+// failoverTransport sends emails using the first healthy endpoint.
+type failoverTransport struct {
+	config    *smtpConfig
+	endpoints []Endpoint
+
+	mu       sync.Mutex
+	cooldown time.Duration
+
+	// unhealthy maps the index of failed endpoints to the time they may be
+	// used again.
+	unhealthy map[int]time.Time
+}
+
+func (t *failoverTransport) setCooldown(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cooldown = d
+}
+
+// Send sends msg using each endpoint in turn until it is accepted, or fails
+// with an error that should not be retried on another endpoint.
+func (t *failoverTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	var (
+		result *SendResult
+		err    error
+	)
+	for n, i := range t.order() {
+		ep := t.endpoints[i]
+
+		if n > 0 {
+			// Prepare the attachments to be read again for the next
+			// endpoint, giving up if they cannot be.
+			if r, ok := msg.(rewinder); ok {
+				if rewindErr := r.rewind(); rewindErr != nil {
+					return result, err
+				}
+			}
+		}
+
+		var sender Transport
+		sender, err = t.sender(ep)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err = sender.Send(ctx, msg)
+		if err == nil || !tryNextHost(ctx, err) {
+			t.markHealthy(i)
+			if result != nil {
+				result.Endpoint = ep.Host
+			}
+			return result, err
+		}
+
+		t.markUnhealthy(i)
+	}
+
+	return result, err
+}
+
+// order returns the indexes of the endpoints in the order they should be
+// tried: healthy endpoints first, followed by those cooling down.
+func (t *failoverTransport) order() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]int, 0, len(t.endpoints))
+	var coolingDown []int
+	for i := range t.endpoints {
+		if until, ok := t.unhealthy[i]; ok && now.Before(until) {
+			coolingDown = append(coolingDown, i)
+			continue
+		}
+		healthy = append(healthy, i)
+	}
+
+	return append(healthy, coolingDown...)
+}
+
+func (t *failoverTransport) markHealthy(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.unhealthy, i)
+}
+
+func (t *failoverTransport) markUnhealthy(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cooldown > 0 {
+		t.unhealthy[i] = time.Now().Add(t.cooldown)
+	}
+}
+
+// sender returns a Transport sending to ep, using a copy of the shared
+// connection settings with the endpoint's TLS configuration applied.
+func (t *failoverTransport) sender(ep Endpoint) (Transport, error) {
+	config := *t.config
+
+	if ep.TLS {
+		return newSenderWithExplicitTLS(ep.Host, ep.Auth, ep.TLSConfig, &config)
+	}
+
+	if ep.TLSConfig != nil {
+		config.tlsConfig = ep.TLSConfig
+	}
+	return newSenderWithStartTLS(ep.Host, ep.Auth, &config), nil
+}
@@ -0,0 +1,205 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestFailover ensures emails are sent using the next endpoint after a
+// connection error or temporary failure, and failed endpoints are skipped
+// until their cooldown expires.
+func TestFailover(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+
+		// primaryRules script the primary endpoint for the first send.
+		primaryRules []smtptest.Rule
+		primaryDown  bool
+
+		wantEndpoint  []string // per send, "primary" or "secondary"
+		wantErr       error
+		wantPrimary   int
+		wantSecondary int
+	}{
+		{
+			name:          "primary ok",
+			wantEndpoint:  []string{"primary", "primary"},
+			wantPrimary:   2,
+			wantSecondary: 0,
+		},
+		{
+			name:          "connection error",
+			primaryDown:   true,
+			wantEndpoint:  []string{"secondary", "secondary"},
+			wantPrimary:   0,
+			wantSecondary: 2,
+		},
+		{
+			name:          "temporary error with cooldown",
+			cooldown:      time.Hour,
+			primaryRules:  []smtptest.Rule{{Command: "MAIL", Code: 451, Message: "4.3.0 Try again", Times: 1}},
+			wantEndpoint:  []string{"secondary", "secondary"},
+			wantPrimary:   0,
+			wantSecondary: 2,
+		},
+		{
+			name:          "temporary error without cooldown",
+			primaryRules:  []smtptest.Rule{{Command: "CONNECT", Code: 421, Message: "4.3.2 Busy", Times: 1}},
+			wantEndpoint:  []string{"secondary", "primary"},
+			wantPrimary:   1,
+			wantSecondary: 1,
+		},
+		{
+			name:          "permanent error",
+			primaryRules:  []smtptest.Rule{{Command: "RCPT", Code: 550, Message: "5.1.1 No such user"}},
+			wantEndpoint:  []string{"primary"},
+			wantErr:       &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+			wantPrimary:   0,
+			wantSecondary: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			primary := smtptest.NewServer(smtptest.ModeStartTLS)
+			defer primary.Close()
+			for _, r := range tt.primaryRules {
+				primary.AddRule(r)
+			}
+			if tt.primaryDown {
+				primary.Close()
+			}
+
+			secondary := smtptest.NewServer(smtptest.ModeImplicitTLS)
+			defer secondary.Close()
+
+			m, err := NewWithFailover([]Endpoint{
+				{Host: primary.Addr, TLSConfig: primary.ClientTLSConfig()},
+				{Host: secondary.Addr, TLS: true, TLSConfig: secondary.ClientTLSConfig()},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.StartTLS(StartTLSMandatory)
+			m.FailoverCooldown(tt.cooldown)
+
+			names := map[string]string{
+				primary.Addr:   "primary",
+				secondary.Addr: "secondary",
+			}
+
+			var gotEndpoint []string
+			for range tt.wantEndpoint {
+				mail := m.NewMail()
+				mail.From("from@example.org")
+				mail.To("to@example.org")
+
+				result, err := m.Deliver(context.Background(), mail)
+				if !reflect.DeepEqual(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if result == nil {
+					result = &SendResult{}
+				}
+				gotEndpoint = append(gotEndpoint, names[result.Endpoint])
+			}
+
+			if !reflect.DeepEqual(gotEndpoint, tt.wantEndpoint) {
+				t.Errorf("got endpoints %v, want %v", gotEndpoint, tt.wantEndpoint)
+			}
+			if got := len(primary.Transactions()); got != tt.wantPrimary {
+				t.Errorf("primary got %d transactions, want %d", got, tt.wantPrimary)
+			}
+			if got := len(secondary.Transactions()); got != tt.wantSecondary {
+				t.Errorf("secondary got %d transactions, want %d", got, tt.wantSecondary)
+			}
+		})
+	}
+}
+
+// TestFailoverAllFailed ensures the error from the last endpoint is returned if
+// every endpoint fails.
+func TestFailoverAllFailed(t *testing.T) {
+	t.Parallel()
+
+	var endpoints []Endpoint
+	for i := 0; i < 2; i++ {
+		srv := smtptest.NewServer(smtptest.ModePlain)
+		defer srv.Close()
+		srv.AddRule(smtptest.Rule{Command: "CONNECT", Code: 421, Message: "4.3.2 Busy"})
+
+		endpoints = append(endpoints, Endpoint{Host: srv.Addr})
+	}
+
+	m, err := NewWithFailover(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+
+	want := &SMTPError{Code: 421, EnhancedCode: "4.3.2", Message: "Busy"}
+	if err := m.Send(mail); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+// TestFailoverSplitDelivery ensures an email delivered in some of the
+// transactions it was split across (see MaxRecipients) is not sent again
+// using the next endpoint, so no recipient receives it twice.
+func TestFailoverSplitDelivery(t *testing.T) {
+	t.Parallel()
+
+	primary := smtptest.NewServer(smtptest.ModePlain)
+	defer primary.Close()
+	primary.AddRule(smtptest.Rule{Command: "RCPT", Arg: "b@example.org", Code: 451, Message: "4.2.1 Mailbox busy"})
+
+	secondary := smtptest.NewServer(smtptest.ModePlain)
+	defer secondary.Close()
+
+	m, err := NewWithFailover([]Endpoint{{Host: primary.Addr}, {Host: secondary.Addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.MaxRecipients(1)
+
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("a@example.org", "b@example.org", "c@example.org")
+
+	err = m.Send(mail)
+
+	var splitErr *SplitDeliveryError
+	if !errors.As(err, &splitErr) {
+		t.Fatalf("got error %v, want *SplitDeliveryError", err)
+	}
+	if len(splitErr.Failed) != 1 || splitErr.Failed[0].Address != "b@example.org" {
+		t.Errorf("got failed %+v, want b@example.org", splitErr.Failed)
+	}
+
+	received := map[string]int{}
+	for _, srv := range []*smtptest.Server{primary, secondary} {
+		for _, tx := range srv.Transactions() {
+			for _, to := range tx.To {
+				received[to]++
+			}
+		}
+	}
+	want := map[string]int{"a@example.org": 1, "c@example.org": 1}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("got received %v, want %v", received, want)
+	}
+}
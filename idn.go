@@ -0,0 +1,341 @@
+package mailyak
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrSMTPUTF8Required is returned when an email address has a non-ASCII local
+// part (such as "josé@example.org"), but the SMTP server does not support the
+// SMTPUTF8 extension needed to deliver it.
+//
+// Unlike the domain, the local part of an address cannot be converted to
+// ASCII.
+var ErrSMTPUTF8Required = errors.New("mailyak: non-ASCII local part requires SMTPUTF8")
+
+// internationalMessage is implemented by messages that may contain
+// internationalized email addresses in their headers, such as Mail.
+type internationalMessage interface {
+	// requiresSMTPUTF8 should return true if the MIME content contains
+	// addresses that can only be sent to servers supporting SMTPUTF8.
+	requiresSMTPUTF8() bool
+}
+
+// requiresSMTPUTF8 returns true if the MIME content of msg can only be sent to
+// servers supporting SMTPUTF8.
+func requiresSMTPUTF8(msg Message) bool {
+	m, ok := msg.(internationalMessage)
+	return ok && m.requiresSMTPUTF8()
+}
+
+// envelopeAddresses prepares the envelope addresses of msg for a server that
+// does (or does not) support SMTPUTF8, returning the envelope and true if the
+// SMTPUTF8 parameter is required.
+//
+// Without SMTPUTF8, internationalized domains are converted to ASCII, and an
+// error wrapping ErrSMTPUTF8Required is returned for any non-ASCII local part.
+func envelopeAddresses(msg Message, smtputf8 bool) (Envelope, bool, error) {
+	envelope := msg.Envelope()
+
+	international := requiresSMTPUTF8(msg)
+	if !international {
+		international = !isASCII(envelope.From)
+		for _, to := range envelope.To {
+			international = international || !isASCII(to)
+		}
+	}
+
+	switch {
+	case !international:
+		return envelope, false, nil
+	case smtputf8:
+		return envelope, true, nil
+	case requiresSMTPUTF8(msg):
+		return envelope, false, ErrSMTPUTF8Required
+	}
+
+	from, err := addressToASCII(envelope.From)
+	if err != nil {
+		return envelope, false, err
+	}
+
+	to := make([]string, len(envelope.To))
+	for i, addr := range envelope.To {
+		if to[i], err = addressToASCII(addr); err != nil {
+			return envelope, false, err
+		}
+	}
+
+	return Envelope{From: from, To: to}, false, nil
+}
+
+// addressToASCII returns addr with any internationalized domain converted to
+// ASCII, or an error if the local part contains non-ASCII characters.
+func addressToASCII(addr string) (string, error) {
+	if isASCII(addr) {
+		return addr, nil
+	}
+
+	local, domain := splitAddress(addr)
+	if !isASCII(local) {
+		return "", fmt.Errorf("%w: %q", ErrSMTPUTF8Required, addr)
+	}
+
+	domain, err := domainToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("mailyak: invalid domain in %q: %w", addr, err)
+	}
+	return local + "@" + domain, nil
+}
+
+// headerAddress formats addr (either "user@example.org" or
+// "Name <user@example.org>") for use in a header.
+//
+// Internationalized domains are converted to ASCII when the local part is
+// ASCII, so the header can be sent to any server. Addresses with a non-ASCII
+// local part are left as UTF-8, requiring SMTPUTF8 (RFC 6532).
+func headerAddress(addr string) string {
+	if isASCII(addr) {
+		return addr
+	}
+
+	prefix, spec, suffix := "", addr, ""
+	if start, end := strings.LastIndexByte(addr, '<'), strings.LastIndexByte(addr, '>'); start >= 0 && end > start {
+		prefix, spec, suffix = addr[:start+1], addr[start+1:end], addr[end:]
+	}
+
+	if ascii, err := addressToASCII(spec); err == nil {
+		spec = ascii
+	}
+	return prefix + spec + suffix
+}
+
+// headerRequiresSMTPUTF8 returns true if the address addr, as formatted by
+// headerAddress, contains non-ASCII characters.
+func headerRequiresSMTPUTF8(addr string) bool {
+	return !isASCII(headerAddress(addr))
+}
+
+// splitAddress splits addr at the last "@" into the local part and domain.
+func splitAddress(addr string) (string, string) {
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return addr[:i], addr[i+1:]
+	}
+	return addr, ""
+}
+
+// isASCII returns true if s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// errUnnormalized is returned for internationalized domain labels that may
+// not be in Unicode Normalization Form C.
+var errUnnormalized = errors.New("label contains combining characters")
+
+// domainToASCII converts each non-ASCII label of domain to its "xn--"
+// punycode form (RFC 3490 ToASCII), after mapping it with nameprepMap.
+//
+// The ideographic full stops recognised by RFC 3490 are treated as label
+// separators.
+//
+// Labels must be in Unicode Normalization Form C, as typically produced by
+// keyboards and browsers. As the normalization tables are not available
+// without additional dependencies, labels containing combining marks or
+// conjoining Hangul jamo, which could compose with the preceding character,
+// are rejected with errUnnormalized rather than partially normalized. Such
+// domains can be given in their "xn--" ASCII form instead.
+func domainToASCII(domain string) (string, error) {
+	domain = labelSeparators.Replace(domain)
+
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+
+		label = nameprepMap(label)
+		if isASCII(label) {
+			labels[i] = label
+			continue
+		}
+		if !composed(label) {
+			return "", errUnnormalized
+		}
+
+		encoded, err := punycode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+		if len(labels[i]) > 63 {
+			return "", errors.New("label too long")
+		}
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// labelSeparators replaces the characters RFC 3490, section 3.1 treats as
+// dots with a full stop.
+var labelSeparators = strings.NewReplacer("\u3002", ".", "\uff0e", ".", "\uff61", ".")
+
+// nameprepMap prepares label for punycode encoding, applying the mappings of
+// Nameprep (RFC 3491) commonly needed for domains typed by users: characters
+// mapped to nothing are removed, fullwidth ASCII is mapped to ASCII, and
+// letters are lowercased.
+//
+// Other compatibility mappings of NFKC are not applied.
+func nameprepMap(label string) string {
+	var b strings.Builder
+	b.Grow(len(label))
+	for _, r := range label {
+		switch {
+		case mappedToNothing(r):
+			continue
+		case r >= 0xff01 && r <= 0xff5e:
+			// Fullwidth forms of the printable ASCII characters.
+			r -= 0xff01 - '!'
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// mappedToNothing returns true for the characters removed by Nameprep
+// (RFC 3454, table B.1), such as the soft hyphen and zero width space.
+func mappedToNothing(r rune) bool {
+	switch r {
+	case 0x00ad, 0x034f, 0x1806, 0x180b, 0x180c, 0x180d, 0x200b, 0x200c, 0x200d, 0x2060, 0xfeff:
+		return true
+	}
+	return r >= 0xfe00 && r <= 0xfe0f
+}
+
+// composed returns false if label contains a combining mark or a conjoining
+// Hangul jamo (U+1100 to U+11FF), either of which may compose with the
+// preceding character in Normalization Form C.
+func composed(label string) bool {
+	for _, r := range label {
+		if unicode.IsMark(r) || r >= 0x1100 && r <= 0x11ff {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters (RFC 3492, section 5).
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes s using the Punycode algorithm (RFC 3492, section 6.3).
+func punycode(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", errors.New("invalid UTF-8")
+	}
+
+	runes := []rune(s)
+
+	var out strings.Builder
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out.WriteRune(r)
+		}
+	}
+
+	basic := out.Len()
+	handled := basic
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled < len(runes) {
+		// Find the smallest code point not yet handled.
+		m := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (0x7fffffff-delta)/(handled+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out.WriteByte(punyDigit(t + (q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out.WriteByte(punyDigit(q))
+
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return out.String(), nil
+}
+
+// punyAdapt is the bias adaptation function (RFC 3492, section 6.1).
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// punyDigit returns the basic code point for the digit d (0 to 35).
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,287 @@
+package mailyak
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestPunycode ensures labels are encoded as specified by RFC 3492.
+func TestPunycode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"münchen", "mnchen-3ya"},
+		{"exämple", "exmple-cua"},
+		{"bücher", "bcher-kva"},
+		{"ü", "tda"},
+		{"abc", "abc-"},
+
+		// RFC 3492, section 7.1. The mixed-case annotations are not
+		// produced, so the encoded digits are lowercase.
+		{"\u0644\u064a\u0647\u0645\u0627\u0628\u062a\u0643\u0644\u0645\u0648\u0634\u0639\u0631\u0628\u064a\u061f", "egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+		{"Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+		{"\u05dc\u05de\u05d4\u05d4\u05dd\u05e4\u05e9\u05d5\u05d8\u05dc\u05d0\u05de\u05d3\u05d1\u05e8\u05d9\u05dd\u05e2\u05d1\u05e8\u05d9\u05ea", "4dbcagdahymbxekheh6e0a7fei0b"},
+		{"\u092f\u0939\u0932\u094b\u0917\u0939\u093f\u0928\u094d\u0926\u0940\u0915\u094d\u092f\u094b\u0902\u0928\u0939\u0940\u0902\u092c\u094b\u0932\u0938\u0915\u0924\u0947\u0939\u0948\u0902", "i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd"},
+		{"なぜみんな日本語を話してくれないのか", "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+		{"세계의모든사람들이한국어를이해한다면얼마나좋을까", "989aomsvi5e83db1d2a355cv1e0vak1dwrv93d5xbh15a0dt30a5jpsd879ccm6fea98c"},
+		{"почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{"PorquénopuedensimplementehablarenEspañol", "PorqunopuedensimplementehablarenEspaol-fmd56a"},
+		{"TạisaohọkhôngthểchỉnóitiếngViệt", "TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g"},
+		{"3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+		{"Hello-Another-Way-それぞれの場所", "Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+		{"ひとつ屋根の下2", "2-u9tlzr9756bt3uc0v"},
+		{"MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+		{"パフィーdeルンバ", "de-jg4avhby1noc0d"},
+		{"そのスピードで", "d9juau41awczczp"},
+		{"-> $1.00 <-", "-> $1.00 <--"},
+	}
+	for _, tt := range tests {
+		got, err := punycode(tt.in)
+		if err != nil {
+			t.Errorf("punycode(%q) got error %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("punycode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestAddressToASCII ensures internationalized domains are converted to
+// ASCII, and non-ASCII local parts and domains that may need normalizing are
+// rejected.
+func TestAddressToASCII(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr error
+	}{
+		{in: "dom@example.org", want: "dom@example.org"},
+		{in: "dom@exämple.de", want: "dom@xn--exmple-cua.de"},
+		{in: "dom@MÜNCHEN.example", want: "dom@xn--mnchen-3ya.example"},
+		{in: "dom@mün\u00adchen.example", want: "dom@xn--mnchen-3ya.example"},
+		{in: "dom@münchen\u3002example", want: "dom@xn--mnchen-3ya.example"},
+		{in: "dom@ｅｘａｍｐｌｅ.org", want: "dom@example.org"},
+		{in: "dom@\ud55c\uad6d.kr", want: "dom@xn--3e0b707e.kr"},
+		{in: "dom@mu\u0308nchen.example", wantErr: errUnnormalized},
+		{in: "dom@MU\u0308NCHEN.example", wantErr: errUnnormalized},
+		{in: "dom@\u1112\u1161\u11ab\u1100\u116e\u11a8.kr", wantErr: errUnnormalized},
+		{in: "josé@example.org", wantErr: ErrSMTPUTF8Required},
+	}
+	for _, tt := range tests {
+		got, err := addressToASCII(tt.in)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("addressToASCII(%q) got error %v, want %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("addressToASCII(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestHeaderAddress ensures header addresses are converted to ASCII where
+// possible.
+func TestHeaderAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"dom@example.org", "dom@example.org"},
+		{"dom@exämple.de", "dom@xn--exmple-cua.de"},
+		{"Dom <dom@exämple.de>", "Dom <dom@xn--exmple-cua.de>"},
+		{"josé@exämple.de", "josé@exämple.de"},
+	}
+	for _, tt := range tests {
+		if got := headerAddress(tt.in); got != tt.want {
+			t.Errorf("headerAddress(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestSMTPUTF8 ensures internationalized addresses are sent with SMTPUTF8 when
+// supported, converted to ASCII when not, and rejected when they cannot be
+// converted.
+func TestSMTPUTF8(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		extensions []string
+		from       string
+		to         string
+
+		wantErr        error
+		wantFrom       string
+		wantTo         []string
+		wantMailParams []string
+		wantHeader     string
+	}{
+		{
+			name:     "ascii",
+			from:     "from@example.org",
+			to:       "to@example.org",
+			wantFrom: "from@example.org",
+			wantTo:   []string{"to@example.org"},
+		},
+		{
+			name:       "ascii with smtputf8",
+			extensions: []string{"SMTPUTF8"},
+			from:       "from@example.org",
+			to:         "to@example.org",
+			wantFrom:   "from@example.org",
+			wantTo:     []string{"to@example.org"},
+		},
+		{
+			name:       "idn domain converted",
+			from:       "from@exämple.de",
+			to:         "to@münchen.example",
+			wantFrom:   "from@xn--exmple-cua.de",
+			wantTo:     []string{"to@xn--mnchen-3ya.example"},
+			wantHeader: "To: to@xn--mnchen-3ya.example\r\n",
+		},
+		{
+			name:           "utf8 local part",
+			extensions:     []string{"SMTPUTF8"},
+			from:           "from@example.org",
+			to:             "josé@exämple.de",
+			wantFrom:       "from@example.org",
+			wantTo:         []string{"josé@exämple.de"},
+			wantMailParams: []string{"SMTPUTF8"},
+			wantHeader:     "To: josé@exämple.de\r\n",
+		},
+		{
+			name:    "utf8 local part unsupported",
+			from:    "from@example.org",
+			to:      "josé@exämple.de",
+			wantErr: ErrSMTPUTF8Required,
+		},
+		{
+			name:    "utf8 sender unsupported",
+			from:    "josé@example.org",
+			to:      "to@example.org",
+			wantErr: ErrSMTPUTF8Required,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			mail := m.NewMail()
+			mail.From(tt.from)
+			mail.To(tt.to)
+			mail.Plain().SetString("Grüße")
+
+			if err := m.Send(mail); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			txs := srv.Transactions()
+			if tt.wantErr != nil {
+				if len(txs) != 0 {
+					t.Errorf("got %d transactions, want 0", len(txs))
+				}
+				return
+			}
+
+			if len(txs) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txs))
+			}
+			if txs[0].From != tt.wantFrom {
+				t.Errorf("got from %q, want %q", txs[0].From, tt.wantFrom)
+			}
+			if !reflect.DeepEqual(txs[0].To, tt.wantTo) {
+				t.Errorf("got to %q, want %q", txs[0].To, tt.wantTo)
+			}
+			if !reflect.DeepEqual(txs[0].MailParams, tt.wantMailParams) {
+				t.Errorf("got MAIL params %q, want %q", txs[0].MailParams, tt.wantMailParams)
+			}
+			if tt.wantHeader != "" && !bytes.Contains(txs[0].Data, []byte(tt.wantHeader)) {
+				t.Errorf("data does not contain %q:\n%s", tt.wantHeader, txs[0].Data)
+			}
+		})
+	}
+}
+
+// TestMailRequiresSMTPUTF8 ensures only headers that cannot be converted to
+// ASCII require SMTPUTF8.
+func TestMailRequiresSMTPUTF8(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		setup func(m *Mail)
+		want  bool
+	}{
+		{
+			name:  "ascii",
+			setup: func(m *Mail) { m.To("to@example.org") },
+		},
+		{
+			name:  "idn domain",
+			setup: func(m *Mail) { m.To("to@exämple.de") },
+		},
+		{
+			name:  "utf8 reply-to",
+			setup: func(m *Mail) { m.ReplyTo("josé@example.org") },
+			want:  true,
+		},
+		{
+			name:  "utf8 bcc without header",
+			setup: func(m *Mail) { m.Bcc("josé@example.org") },
+		},
+		{
+			name: "utf8 bcc with header",
+			setup: func(m *Mail) {
+				m.WriteBccHeader(true)
+				m.Bcc("josé@example.org")
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mail := New("", nil).NewMail()
+			mail.From("from@example.org")
+			tt.setup(mail)
+
+			if got := mail.requiresSMTPUTF8(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			var buf bytes.Buffer
+			if err := mail.WriteMIME(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := !isASCII(strings.SplitN(buf.String(), "\r\n\r\n", 2)[0]); got != tt.want {
+				t.Errorf("got non-ASCII headers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
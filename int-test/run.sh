@@ -5,6 +5,10 @@
 # 
 # 	https://github.com/mailhog/MailHog
 #
+# By default the integration tests use the in-process smtptest server - this
+# script is only needed to test against MailHog, by setting
+# MAILYAK_PLAINTEXT_ENDPOINT and MAILYAK_TLS_ENDPOINT to the ports below.
+#
 # If you wish to run these tests, ensure mailhog and socat are in your path.
 # You'll probably need OpenSSL too.
 # 
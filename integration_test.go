@@ -11,24 +11,34 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
 )
 
+// tlsEndpoint returns the address of the SMTP server set in
+// MAILYAK_TLS_ENDPOINT, or starts a smtptest server accepting implicit TLS
+// connections if unset.
 func tlsEndpoint(t *testing.T) string {
-	s := os.Getenv("MAILYAK_TLS_ENDPOINT")
-	if s == "" {
-		t.Log("set MAILYAK_TLS_ENDPOINT to run TLS integration tests")
-		t.SkipNow()
+	if s := os.Getenv("MAILYAK_TLS_ENDPOINT"); s != "" {
+		return s
 	}
-	return s
+	return testServer(t, smtptest.ModeImplicitTLS)
 }
 
+// plaintextEndpoint returns the address of the SMTP server set in
+// MAILYAK_PLAINTEXT_ENDPOINT, or starts a plain-text smtptest server if unset.
 func plaintextEndpoint(t *testing.T) string {
-	s := os.Getenv("MAILYAK_PLAINTEXT_ENDPOINT")
-	if s == "" {
-		t.Log("set MAILYAK_PLAINTEXT_ENDPOINT to run plain-text integration tests")
-		t.SkipNow()
+	if s := os.Getenv("MAILYAK_PLAINTEXT_ENDPOINT"); s != "" {
+		return s
 	}
-	return s
+	return testServer(t, smtptest.ModePlain)
+}
+
+// testServer starts a smtptest server for the duration of the test.
+func testServer(t *testing.T, mode smtptest.Mode) string {
+	srv := smtptest.NewServer(mode)
+	t.Cleanup(srv.Close)
+	return srv.Addr
 }
 
 func TestIntegration_TLS(t *testing.T) {
@@ -0,0 +1,500 @@
+// Package smtpclient implements the client side of the SMTP protocol, for use
+// by mailyak in place of the frozen net/smtp package.
+//
+// The Client follows the behaviour of smtp.Client for the greeting, EHLO/HELO,
+// STARTTLS and authentication (accepting the same smtp.Auth implementations),
+// and additionally:
+//
+//   - exposes the parameters of each extension advertised in the EHLO reply
+//   - sends arbitrary ESMTP parameters with the MAIL FROM and RCPT TO commands
+//   - returns the full Reply to each command, including multi-line text
+//   - supports pipelining commands (RFC 2920) with Send and ReadReply
+//   - sends message content with BDAT (RFC 3030)
+//   - reports every command and reply to optional Hooks
+//
+// Replies with an unexpected code are returned as a *Error.
+package smtpclient
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Hooks are called as commands are sent and replies received, typically for
+// logging or tracing.
+type Hooks struct {
+	// Command is called with each command line sent, without the trailing
+	// CRLF. The arguments of AUTH commands are omitted, and the responses
+	// sent during the authentication exchange are not reported.
+	Command func(line string)
+
+	// Reply is called with each reply read.
+	Reply func(r Reply)
+}
+
+// Client is a client connection to a SMTP server.
+//
+// A Client is not safe for concurrent use.
+type Client struct {
+	// Hooks are called for each command and reply.
+	Hooks Hooks
+
+	conn       net.Conn
+	text       *textproto.Conn
+	serverName string
+	localName  string
+	tls        bool
+
+	// didHello is set once the EHLO/HELO greeting has been attempted, and
+	// helloErr holds its error.
+	didHello bool
+	helloErr error
+
+	// ext maps each extension advertised in the EHLO reply (in upper case)
+	// to its parameters, and auth lists the advertised AUTH mechanisms.
+	ext  map[string]string
+	auth []string
+}
+
+// NewClient returns a new Client using conn, reading the server's 220
+// greeting.
+//
+// serverName is the hostname of the server, used for authentication.
+func NewClient(conn net.Conn, serverName string) (*Client, error) {
+	c := &Client{
+		conn:       conn,
+		text:       textproto.NewConn(conn),
+		serverName: serverName,
+		localName:  "localhost",
+	}
+	_, c.tls = conn.(*tls.Conn)
+
+	if _, err := c.ReadReply(220); err != nil {
+		_ = c.text.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the connection without sending a QUIT command.
+func (c *Client) Close() error {
+	return c.text.Close()
+}
+
+// Hello sends the EHLO (or HELO, if EHLO is not supported) command, with
+// localName as the client hostname.
+//
+// Calling Hello is only necessary to use a hostname other than "localhost",
+// and must happen before any other method is called.
+func (c *Client) Hello(localName string) error {
+	if err := validateLine(localName); err != nil {
+		return err
+	}
+	if c.didHello {
+		return errors.New("smtp: Hello called after other methods")
+	}
+	c.localName = localName
+	return c.hello()
+}
+
+// hello sends the EHLO/HELO greeting if it has not already been attempted.
+func (c *Client) hello() error {
+	if !c.didHello {
+		c.didHello = true
+		if err := c.ehlo(); err != nil {
+			c.helloErr = c.helo()
+		}
+	}
+	return c.helloErr
+}
+
+func (c *Client) helo() error {
+	c.ext = nil
+	_, err := c.Cmd(250, "HELO "+c.localName)
+	return err
+}
+
+func (c *Client) ehlo() error {
+	r, err := c.Cmd(250, "EHLO "+c.localName)
+	if err != nil {
+		return err
+	}
+
+	// The first line of the reply is the server greeting, each following
+	// line describes an extension.
+	ext := make(map[string]string)
+	for _, line := range r.Lines[1:] {
+		kv := strings.SplitN(line, " ", 2)
+		name := strings.ToUpper(kv[0])
+		if len(kv) == 2 {
+			ext[name] = kv[1]
+		} else {
+			ext[name] = ""
+		}
+	}
+	c.ext = ext
+
+	c.auth = nil
+	if mechs, ok := ext["AUTH"]; ok {
+		c.auth = strings.Split(mechs, " ")
+	}
+
+	return nil
+}
+
+// Extension reports whether the server advertised the named extension in its
+// EHLO reply, and returns its parameters. The name is case insensitive.
+//
+// The EHLO greeting is sent if it has not already been.
+func (c *Client) Extension(name string) (bool, string) {
+	if err := c.hello(); err != nil {
+		return false, ""
+	}
+	params, ok := c.ext[strings.ToUpper(name)]
+	return ok, params
+}
+
+// Capabilities returns a copy of the extensions advertised by the server,
+// mapping each upper case extension name to its parameters, or nil if the
+// server does not support EHLO.
+//
+// The EHLO greeting is sent if it has not already been.
+func (c *Client) Capabilities() map[string]string {
+	if err := c.hello(); err != nil || c.ext == nil {
+		return nil
+	}
+
+	out := make(map[string]string, len(c.ext))
+	for k, v := range c.ext {
+		out[k] = v
+	}
+	return out
+}
+
+// TLS reports whether the connection is using TLS.
+func (c *Client) TLS() bool {
+	return c.tls
+}
+
+// StartTLS sends the STARTTLS command and upgrades the connection to TLS
+// using config, sending the EHLO greeting again once the handshake completes.
+func (c *Client) StartTLS(config *tls.Config) error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	if _, err := c.Cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+
+	c.conn = tls.Client(c.conn, config)
+	c.text = textproto.NewConn(c.conn)
+	c.tls = true
+	return c.ehlo()
+}
+
+// Auth authenticates using a, which is given the advertised AUTH mechanisms.
+//
+// If the authentication exchange fails, the exchange is cancelled and the
+// connection is closed with a QUIT command.
+func (c *Client) Auth(a smtp.Auth) error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+
+	encoding := base64.StdEncoding
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: c.serverName, TLS: c.tls, Auth: c.auth})
+	if err != nil {
+		_ = c.Quit()
+		return err
+	}
+
+	c.hookCommand("AUTH " + mech)
+	line := strings.TrimSpace("AUTH " + mech + " " + encoding.EncodeToString(resp))
+	r, err := c.cmd(0, line)
+	for err == nil {
+		var msg []byte
+		switch r.Code {
+		case 334:
+			msg, err = encoding.DecodeString(r.Text())
+		case 235:
+			// Some servers include data with the final reply.
+			msg = []byte(r.Text())
+		default:
+			err = &Error{Reply: r}
+		}
+		if err == nil {
+			resp, err = a.Next(msg, r.Code == 334)
+		}
+		if err != nil {
+			// Cancel the exchange.
+			_, _ = c.Cmd(501, "*")
+			_ = c.Quit()
+			break
+		}
+		if resp == nil {
+			break
+		}
+		r, err = c.cmd(0, encoding.EncodeToString(resp))
+	}
+
+	return err
+}
+
+// Mail sends the MAIL FROM command for the from address, with the given ESMTP
+// parameters (such as "SIZE=1024"), and returns the server's reply.
+func (c *Client) Mail(from string, params []string) (Reply, error) {
+	if err := c.hello(); err != nil {
+		return Reply{}, err
+	}
+	line, err := CommandLine("MAIL FROM:", from, params)
+	if err != nil {
+		return Reply{}, err
+	}
+	return c.Cmd(250, line)
+}
+
+// Rcpt sends the RCPT TO command for the to address, with the given ESMTP
+// parameters (such as "NOTIFY=FAILURE"), and returns the server's reply.
+//
+// Unlike smtp.Client.Rcpt, the reply is returned for rejected recipients too,
+// alongside the *Error.
+func (c *Client) Rcpt(to string, params []string) (Reply, error) {
+	line, err := CommandLine("RCPT TO:", to, params)
+	if err != nil {
+		return Reply{}, err
+	}
+	return c.Cmd(25, line)
+}
+
+// Data sends the DATA command and returns a writer for the message content,
+// which is dot-stuffed as it is written. Closing the writer sends the
+// terminating "." line and reads the server's reply.
+func (c *Client) Data() (io.WriteCloser, error) {
+	if _, err := c.Cmd(354, "DATA"); err != nil {
+		return nil, err
+	}
+	return c.DotWriter(), nil
+}
+
+// DotWriter returns a writer for the message content following a DATA command
+// the server has already accepted, such as one sent with Send.
+func (c *Client) DotWriter() io.WriteCloser {
+	return &dataWriter{c: c, w: c.text.DotWriter()}
+}
+
+// BDAT returns a writer that sends the message content in BDAT chunks of up to
+// chunkSize bytes, reading the server's reply to each. Closing the writer sends
+// the remaining content as the LAST chunk.
+func (c *Client) BDAT(chunkSize int) io.WriteCloser {
+	return &bdatWriter{c: c, size: chunkSize}
+}
+
+// Reset sends the RSET command, aborting any mail transaction.
+func (c *Client) Reset() error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	_, err := c.Cmd(250, "RSET")
+	return err
+}
+
+// Noop sends the NOOP command, typically used to check the connection is
+// alive.
+func (c *Client) Noop() error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	_, err := c.Cmd(250, "NOOP")
+	return err
+}
+
+// Quit sends the QUIT command and closes the connection.
+func (c *Client) Quit() error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	if _, err := c.Cmd(221, "QUIT"); err != nil {
+		return err
+	}
+	return c.text.Close()
+}
+
+// Cmd sends the command line and reads the reply, returning an *Error if its
+// code does not match expect (as described for ReadReply).
+func (c *Client) Cmd(expect int, line string) (Reply, error) {
+	if err := validateLine(line); err != nil {
+		return Reply{}, err
+	}
+	c.hookCommand(line)
+	return c.cmd(expect, line)
+}
+
+// cmd sends line without reporting it to the Command hook.
+func (c *Client) cmd(expect int, line string) (Reply, error) {
+	id, err := c.text.Cmd("%s", line)
+	if err != nil {
+		return Reply{}, err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+
+	return c.ReadReply(expect)
+}
+
+// Send writes each command line in a single batch, without reading any
+// replies, for servers supporting PIPELINING. The replies must then be read
+// in order with ReadReply.
+func (c *Client) Send(lines ...string) error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if err := validateLine(line); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range lines {
+		c.hookCommand(line)
+		if _, err := c.text.W.WriteString(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.text.W.Flush()
+}
+
+// ReadReply reads the next reply from the server.
+//
+// If expect is a three digit code, the reply must have that code. A one or two
+// digit expect value matches any code it is a prefix of, so 25 accepts both 250
+// and 251, and 0 accepts any code. An *Error is returned alongside the Reply if
+// the code does not match.
+func (c *Client) ReadReply(expect int) (Reply, error) {
+	code, msg, err := c.text.ReadResponse(0)
+	if err != nil {
+		return Reply{}, err
+	}
+
+	r := Reply{Code: code, Lines: strings.Split(msg, "\n")}
+	if c.Hooks.Reply != nil {
+		c.Hooks.Reply(r)
+	}
+
+	if !matches(code, expect) {
+		return r, &Error{Reply: r}
+	}
+	return r, nil
+}
+
+func (c *Client) hookCommand(line string) {
+	if c.Hooks.Command != nil {
+		c.Hooks.Command(line)
+	}
+}
+
+// CommandLine formats a MAIL FROM or RCPT TO command for addr with the given
+// ESMTP parameters, returning an error if it contains a line break.
+func CommandLine(cmd, addr string, params []string) (string, error) {
+	line := cmd + "<" + addr + ">"
+	if len(params) > 0 {
+		line += " " + strings.Join(params, " ")
+	}
+	if err := validateLine(line); err != nil {
+		return "", err
+	}
+	return line, nil
+}
+
+// validateLine returns an error if line contains a CR or LF.
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return errors.New("smtp: A line must not contain CR or LF")
+	}
+	return nil
+}
+
+// dataWriter writes the message content following a DATA command, reading the
+// server's reply once closed.
+type dataWriter struct {
+	c *Client
+	w io.WriteCloser
+}
+
+func (d *dataWriter) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+func (d *dataWriter) Close() error {
+	if err := d.w.Close(); err != nil {
+		return err
+	}
+	_, err := d.c.ReadReply(250)
+	return err
+}
+
+// bdatWriter sends the content written to it in BDAT chunks of up to size
+// bytes, sending the final chunk when closed.
+type bdatWriter struct {
+	c    *Client
+	size int
+	buf  []byte
+}
+
+func (w *bdatWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == w.size {
+			if err := w.send(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := w.size - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close sends the remaining content as the LAST chunk, returning the server's
+// reply to the message.
+func (w *bdatWriter) Close() error {
+	return w.send(true)
+}
+
+// send issues a BDAT command for the buffered content, waiting for the
+// server's reply.
+func (w *bdatWriter) send(last bool) error {
+	line := "BDAT " + strconv.Itoa(len(w.buf))
+	if last {
+		line += " LAST"
+	}
+	w.c.hookCommand(line)
+
+	text := w.c.text
+	if _, err := text.W.WriteString(line + "\r\n"); err != nil {
+		return err
+	}
+	if _, err := text.W.Write(w.buf); err != nil {
+		return err
+	}
+	if err := text.W.Flush(); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+
+	_, err := w.c.ReadReply(250)
+	return err
+}
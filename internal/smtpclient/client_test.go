@@ -0,0 +1,314 @@
+package smtpclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// dial connects a Client to srv.
+func dial(t *testing.T, srv *smtptest.Server) *Client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(conn, "localhost")
+	if err != nil {
+		_ = conn.Close()
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestCapabilities ensures the extensions advertised in the EHLO reply are
+// parsed, with their parameters, before and after a STARTTLS upgrade.
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewUnstartedServer(smtptest.ModeStartTLS)
+	srv.Extensions = []string{"SIZE 1024", "pipelining", "8BITMIME"}
+	srv.Start()
+	defer srv.Close()
+
+	c := dial(t, srv)
+	defer c.Close()
+
+	want := map[string]string{
+		"STARTTLS":   "",
+		"AUTH":       "PLAIN LOGIN",
+		"SIZE":       "1024",
+		"PIPELINING": "",
+		"8BITMIME":   "",
+	}
+	if got := c.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got capabilities %v, want %v", got, want)
+	}
+	if ok, params := c.Extension("size"); !ok || params != "1024" {
+		t.Errorf("got SIZE extension %v %q, want true %q", ok, params, "1024")
+	}
+	if ok, _ := c.Extension("CHUNKING"); ok {
+		t.Error("got CHUNKING extension, want not supported")
+	}
+
+	if err := c.StartTLS(srv.ClientTLSConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.TLS() {
+		t.Error("got TLS false after STARTTLS, want true")
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("got STARTTLS extension after upgrade, want not advertised")
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSend ensures emails are sent with ESMTP parameters using DATA, BDAT and
+// pipelined commands, reporting each command and reply to the hooks.
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	const body = "Subject: Bananas\r\n\r\n.dotted line\r\nbananas\r\n"
+
+	tests := []struct {
+		name       string
+		extensions []string
+		send       func(c *Client) (io.WriteCloser, error)
+
+		wantCommands []string
+		wantChunks   int
+	}{
+		{
+			name: "data",
+			send: func(c *Client) (io.WriteCloser, error) {
+				if _, err := c.Mail("from@example.org", []string{"SIZE=100"}); err != nil {
+					return nil, err
+				}
+				if _, err := c.Rcpt("to@example.org", []string{"NOTIFY=FAILURE"}); err != nil {
+					return nil, err
+				}
+				return c.Data()
+			},
+			wantCommands: []string{
+				"EHLO localhost",
+				"MAIL FROM:<from@example.org> SIZE=100",
+				"RCPT TO:<to@example.org> NOTIFY=FAILURE",
+				"DATA",
+			},
+		},
+		{
+			name:       "bdat",
+			extensions: []string{"CHUNKING"},
+			send: func(c *Client) (io.WriteCloser, error) {
+				if _, err := c.Mail("from@example.org", []string{"SIZE=100"}); err != nil {
+					return nil, err
+				}
+				if _, err := c.Rcpt("to@example.org", []string{"NOTIFY=FAILURE"}); err != nil {
+					return nil, err
+				}
+				return c.BDAT(16), nil
+			},
+			wantCommands: []string{
+				"EHLO localhost",
+				"MAIL FROM:<from@example.org> SIZE=100",
+				"RCPT TO:<to@example.org> NOTIFY=FAILURE",
+				"BDAT 16",
+				"BDAT 16",
+				"BDAT 11 LAST",
+			},
+			wantChunks: 3,
+		},
+		{
+			name:       "pipelined",
+			extensions: []string{"PIPELINING"},
+			send: func(c *Client) (io.WriteCloser, error) {
+				err := c.Send(
+					"MAIL FROM:<from@example.org> SIZE=100",
+					"RCPT TO:<to@example.org> NOTIFY=FAILURE",
+					"DATA",
+				)
+				if err != nil {
+					return nil, err
+				}
+				for _, code := range []int{250, 25, 354} {
+					if _, err := c.ReadReply(code); err != nil {
+						return nil, err
+					}
+				}
+				return c.DotWriter(), nil
+			},
+			wantCommands: []string{
+				"EHLO localhost",
+				"MAIL FROM:<from@example.org> SIZE=100",
+				"RCPT TO:<to@example.org> NOTIFY=FAILURE",
+				"DATA",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			c := dial(t, srv)
+			defer c.Close()
+
+			var commands []string
+			var replies []Reply
+			c.Hooks = Hooks{
+				Command: func(line string) { commands = append(commands, line) },
+				Reply:   func(r Reply) { replies = append(replies, r) },
+			}
+
+			w, err := tt.send(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, body); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(commands, tt.wantCommands) {
+				t.Errorf("got commands %q, want %q", commands, tt.wantCommands)
+			}
+
+			// The EHLO reply has a line for the greeting, AUTH and each
+			// extension.
+			if got, want := len(replies[0].Lines), 2+len(tt.extensions); got != want {
+				t.Errorf("got %d EHLO reply lines, want %d: %q", got, want, replies[0].Lines)
+			}
+			if got := replies[len(replies)-1].Code; got != 250 {
+				t.Errorf("got final reply code %d, want 250", got)
+			}
+
+			txs := srv.Transactions()
+			if len(txs) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txs))
+			}
+			tx := txs[0]
+			if !reflect.DeepEqual(tx.MailParams, []string{"SIZE=100"}) {
+				t.Errorf("got MAIL params %q, want SIZE=100", tx.MailParams)
+			}
+			if !reflect.DeepEqual(tx.RcptParams, [][]string{{"NOTIFY=FAILURE"}}) {
+				t.Errorf("got RCPT params %q, want NOTIFY=FAILURE", tx.RcptParams)
+			}
+			if string(tx.Data) != body {
+				t.Errorf("got data %q, want %q", tx.Data, body)
+			}
+			if tx.Chunks != tt.wantChunks {
+				t.Errorf("got %d chunks, want %d", tx.Chunks, tt.wantChunks)
+			}
+		})
+	}
+}
+
+// TestReplyError ensures unexpected replies are returned in full, alongside an
+// *Error.
+func TestReplyError(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+	srv.AddRule(smtptest.Rule{Command: "RCPT", Code: 550, Message: "5.1.1 No such user\n5.1.1 Really"})
+
+	c := dial(t, srv)
+	defer c.Close()
+
+	if _, err := c.Mail("from@example.org", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Rcpt("to@example.org", nil)
+	want := Reply{Code: 550, Lines: []string{"5.1.1 No such user", "5.1.1 Really"}}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got reply %+v, want %+v", r, want)
+	}
+
+	var replyErr *Error
+	if !errors.As(err, &replyErr) || !reflect.DeepEqual(replyErr.Reply, want) {
+		t.Fatalf("got error %v, want *Error", err)
+	}
+	if got, want := err.Error(), "550 5.1.1 No such user\n5.1.1 Really"; got != want {
+		t.Errorf("got error string %q, want %q", got, want)
+	}
+
+	if _, err := c.Mail("from@example.org\r\nRSET", nil); err == nil {
+		t.Error("got nil error for command containing CRLF")
+	}
+}
+
+// TestAuth ensures authentication uses smtp.Auth implementations, cancelling
+// the exchange when rejected, and the credentials are not passed to the hooks.
+func TestAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		password string
+		wantCode int
+	}{
+		{
+			name:     "accepted",
+			password: "bananas",
+		},
+		{
+			name:     "rejected",
+			password: "apples",
+			wantCode: 535,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Credentials = map[string]string{"user": "bananas"}
+			srv.Start()
+			defer srv.Close()
+
+			c := dial(t, srv)
+			defer c.Close()
+
+			var commands []string
+			c.Hooks.Command = func(line string) { commands = append(commands, line) }
+
+			err := c.Auth(smtp.PlainAuth("", "user", tt.password, "localhost"))
+			var replyErr *Error
+			switch {
+			case tt.wantCode != 0:
+				if !errors.As(err, &replyErr) || replyErr.Reply.Code != tt.wantCode {
+					t.Fatalf("got error %v, want code %d", err, tt.wantCode)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			for _, cmd := range commands {
+				if strings.Contains(cmd, "AUTH") && cmd != "AUTH PLAIN" {
+					t.Errorf("got command %q passed to hook, want credentials omitted", cmd)
+				}
+			}
+		})
+	}
+}
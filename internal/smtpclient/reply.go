@@ -0,0 +1,49 @@
+package smtpclient
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reply is a SMTP server reply.
+type Reply struct {
+	// Code is the three digit reply code, such as 250 or 550.
+	Code int
+
+	// Lines holds the text of each line of the reply, without the reply
+	// code. Any RFC 3463 enhanced status code is left in place.
+	Lines []string
+}
+
+// Text returns the lines of the reply separated by "\n".
+func (r Reply) Text() string {
+	return strings.Join(r.Lines, "\n")
+}
+
+// Error is returned when the server replies with an unexpected reply code.
+type Error struct {
+	Reply Reply
+}
+
+// Error formats the reply as sent by the server, as textproto.Error does.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%03d %s", e.Reply.Code, e.Reply.Text())
+}
+
+// matches returns true if code satisfies expect, which is either a full three
+// digit reply code, or a one or two digit prefix of one, with the same meaning
+// as for textproto.Conn.ReadResponse.
+//
+// An expect value of 0 matches every code.
+func matches(code, expect int) bool {
+	switch {
+	case expect <= 0:
+		return true
+	case expect < 10:
+		return code/100 == expect
+	case expect < 100:
+		return code/10 == expect
+	default:
+		return code == expect
+	}
+}
@@ -0,0 +1,279 @@
+package mailyak
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limits configures client-side limits on the rate emails are sent, and the
+// number of concurrent connections to each SMTP server, as set with
+// MailYak.Limits.
+//
+// A zero value applies no limits.
+type Limits struct {
+	// MessagesPerSecond is the maximum average number of emails sent per
+	// second, or 0 for no limit. Bursts of up to MessagesPerSecond (rounded
+	// up) emails are sent without waiting.
+	MessagesPerSecond float64
+
+	// RecipientsPerMinute is the maximum number of recipients emails are sent
+	// to per minute, or 0 for no limit. An email with more recipients than the
+	// limit waits for the full minute's allowance.
+	RecipientsPerMinute int
+
+	// MaxConnsPerHost is the maximum number of concurrent connections to each
+	// SMTP server address, or 0 for no limit.
+	MaxConnsPerHost int
+
+	// NoWait causes sends exceeding a limit to fail with a *RateLimitError,
+	// rather than waiting until the send is permitted.
+	NoWait bool
+}
+
+// RateLimitError is returned when a send exceeds a limit and Limits.NoWait is
+// set.
+type RateLimitError struct {
+	// Limit names the limit exceeded - one of "messages per second",
+	// "recipients per minute" or "connections per host".
+	Limit string
+
+	// RetryAfter is the estimated duration until the send would be
+	// permitted, or 0 if unknown (as for connection limits).
+	RetryAfter time.Duration
+}
+
+// Error returns a description of the limit exceeded.
+func (e *RateLimitError) Error() string {
+	msg := "mailyak: rate limit exceeded: " + e.Limit
+	if e.RetryAfter > 0 {
+		msg += " (retry after " + e.RetryAfter.String() + ")"
+	}
+	return msg
+}
+
+// Limits configures client-side limits on the rate emails are sent and the
+// number of concurrent connections to each SMTP server, replacing any limits
+// previously set. By default there are no limits.
+//
+//	my.Limits(mailyak.Limits{
+//		MessagesPerSecond: 14,
+//		MaxConnsPerHost:   10,
+//	})
+//
+// By default a send exceeding a limit waits until it is permitted, or the
+// context passed to SendContext is cancelled. If Limits.NoWait is set, a
+// *RateLimitError is returned instead.
+//
+// The message and recipient rates are applied to each send attempt, including
+// retries. The connection limit also bounds the PoolSize.
+func (m *MailYak) Limits(l Limits) {
+	m.config.limiter = newLimiter(l)
+	m.resizePool()
+}
+
+// limiter enforces the configured Limits.
+type limiter struct {
+	noWait bool
+
+	messages   *tokenBucket // nil if unlimited
+	recipients *tokenBucket // nil if unlimited
+
+	maxConnsPerHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// newLimiter returns a limiter enforcing l, or nil if l applies no limits.
+func newLimiter(l Limits) *limiter {
+	if l.MessagesPerSecond <= 0 && l.RecipientsPerMinute <= 0 && l.MaxConnsPerHost <= 0 {
+		return nil
+	}
+
+	lim := &limiter{
+		noWait:          l.NoWait,
+		maxConnsPerHost: l.MaxConnsPerHost,
+		hosts:           make(map[string]chan struct{}),
+	}
+	if l.MessagesPerSecond > 0 {
+		lim.messages = newTokenBucket(l.MessagesPerSecond, math.Ceil(l.MessagesPerSecond))
+	}
+	if l.RecipientsPerMinute > 0 {
+		lim.recipients = newTokenBucket(float64(l.RecipientsPerMinute)/60, float64(l.RecipientsPerMinute))
+	}
+
+	return lim
+}
+
+// wait blocks until an email to the given number of recipients may be sent,
+// or ctx is done.
+func (l *limiter) wait(ctx context.Context, recipients int) error {
+	if l.messages == nil && l.recipients == nil {
+		return nil
+	}
+
+	var d time.Duration
+	if l.messages != nil {
+		wait, ok := l.messages.reserve(1, !l.noWait)
+		if !ok {
+			return &RateLimitError{Limit: "messages per second", RetryAfter: wait}
+		}
+		d = wait
+	}
+	if l.recipients != nil {
+		wait, ok := l.recipients.reserve(float64(recipients), !l.noWait)
+		if !ok {
+			if l.messages != nil {
+				l.messages.cancel(1)
+			}
+			return &RateLimitError{Limit: "recipients per minute", RetryAfter: wait}
+		}
+		if wait > d {
+			d = wait
+		}
+	}
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Return the reserved tokens so they may be used by other sends.
+		if l.messages != nil {
+			l.messages.cancel(1)
+		}
+		if l.recipients != nil {
+			l.recipients.cancel(float64(recipients))
+		}
+		return ctx.Err()
+	}
+}
+
+// acquireConn blocks until a connection to addr may be opened, or ctx is done.
+// The returned func must be called once the connection is closed.
+func (l *limiter) acquireConn(ctx context.Context, addr string) (func(), error) {
+	if l.maxConnsPerHost <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	sem, ok := l.hosts[addr]
+	if !ok {
+		sem = make(chan struct{}, l.maxConnsPerHost)
+		l.hosts[addr] = sem
+	}
+	l.mu.Unlock()
+
+	release := func() { <-sem }
+
+	if l.noWait {
+		select {
+		case sem <- struct{}{}:
+			return release, nil
+		default:
+			return nil, &RateLimitError{Limit: "connections per host"}
+		}
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tokenBucket is a token bucket rate limiter, refilling at rate tokens per
+// second up to burst tokens.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens (capped at the burst size), returning the duration
+// until they are available.
+//
+// If wait is false and the tokens are not available immediately, no tokens are
+// taken and false is returned.
+func (b *tokenBucket) reserve(n float64, wait bool) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+
+	d := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if !wait {
+		return d, false
+	}
+
+	// The bucket goes into debt, delaying subsequent reservations until the
+	// tokens taken here have been refilled.
+	b.tokens -= n
+	return d, true
+}
+
+// cancel returns n tokens taken by reserve.
+func (b *tokenBucket) cancel(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > b.burst {
+		n = b.burst
+	}
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// limitedConn releases its connection limit slot once closed.
+type limitedConn struct {
+	net.Conn
+
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// poolSize returns the effective pool size, bounded by the connection limit so
+// that idle pooled sessions cannot prevent new sessions being opened.
+func poolSize(n int, l *limiter) int {
+	if l != nil && l.maxConnsPerHost > 0 && n > l.maxConnsPerHost {
+		return l.maxConnsPerHost
+	}
+	return n
+}
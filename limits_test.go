@@ -0,0 +1,291 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestLimitsNoWait ensures sends exceeding the message and recipient rates
+// fail with a *RateLimitError when NoWait is set.
+func TestLimitsNoWait(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		limits Limits
+		sends  [][]string // recipients per send
+
+		wantSent  int
+		wantLimit string
+	}{
+		{
+			name:      "messages per second",
+			limits:    Limits{MessagesPerSecond: 2, NoWait: true},
+			sends:     [][]string{{"a@example.org"}, {"b@example.org"}, {"c@example.org"}},
+			wantSent:  2,
+			wantLimit: "messages per second",
+		},
+		{
+			name:      "recipients per minute",
+			limits:    Limits{RecipientsPerMinute: 3, NoWait: true},
+			sends:     [][]string{{"a@example.org", "b@example.org"}, {"c@example.org", "d@example.org"}},
+			wantSent:  1,
+			wantLimit: "recipients per minute",
+		},
+		{
+			name:     "within limits",
+			limits:   Limits{MessagesPerSecond: 5, RecipientsPerMinute: 10, NoWait: true},
+			sends:    [][]string{{"a@example.org", "b@example.org"}, {"c@example.org"}},
+			wantSent: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ft := &fakeTransport{}
+			m := NewWithTransport(ft)
+			m.Limits(tt.limits)
+
+			var err error
+			for _, to := range tt.sends {
+				mail := m.NewMail()
+				mail.From("from@example.org")
+				mail.To(to...)
+
+				if err = m.Send(mail); err != nil {
+					break
+				}
+			}
+
+			if got := len(ft.envelopes); got != tt.wantSent {
+				t.Errorf("got %d sent, want %d", got, tt.wantSent)
+			}
+
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want nil", err)
+				}
+				return
+			}
+
+			var limitErr *RateLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got error %v, want *RateLimitError", err)
+			}
+			if limitErr.Limit != tt.wantLimit {
+				t.Errorf("got limit %q, want %q", limitErr.Limit, tt.wantLimit)
+			}
+			if limitErr.RetryAfter <= 0 {
+				t.Errorf("got retry after %v, want > 0", limitErr.RetryAfter)
+			}
+		})
+	}
+}
+
+// TestLimitsWait ensures sends exceeding the message rate wait until they are
+// permitted.
+func TestLimitsWait(t *testing.T) {
+	t.Parallel()
+
+	ft := &fakeTransport{}
+	m := NewWithTransport(ft)
+	m.Limits(Limits{MessagesPerSecond: 10})
+
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+
+		if err := m.Send(mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first 10 are sent immediately, and the remaining 2 are sent at
+	// 100ms intervals.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("sent 12 emails in %v, want >= 150ms", elapsed)
+	}
+}
+
+// TestLimitsContext ensures a send waiting for the rate limit is aborted when
+// the context is cancelled, without consuming the allowance.
+func TestLimitsContext(t *testing.T) {
+	t.Parallel()
+
+	ft := &fakeTransport{}
+	m := NewWithTransport(ft)
+	m.Limits(Limits{MessagesPerSecond: 1})
+
+	send := func(ctx context.Context) error {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+		return m.SendContext(ctx, mail)
+	}
+
+	if err := send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := send(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if got := len(ft.envelopes); got != 1 {
+		t.Errorf("got %d sent, want 1", got)
+	}
+
+	// The cancelled send returned its reservation, so the next send waits for
+	// the remainder of the second rather than two seconds.
+	start := time.Now()
+	if err := send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("waited %v, want < 1.5s", elapsed)
+	}
+}
+
+// countingDialer records the maximum number of concurrently open connections.
+type countingDialer struct {
+	mu      sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (d *countingDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.open++
+	if d.open > d.maxOpen {
+		d.maxOpen = d.open
+	}
+
+	return &countedConn{Conn: conn, d: d}, nil
+}
+
+type countedConn struct {
+	net.Conn
+	d    *countingDialer
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.d.mu.Lock()
+		c.d.open--
+		c.d.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// TestLimitsMaxConnsPerHost ensures no more than MaxConnsPerHost connections
+// are open concurrently, with and without session pooling.
+func TestLimitsMaxConnsPerHost(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		poolSize int
+	}{
+		{name: "unpooled"},
+		{name: "pool larger than limit", poolSize: 5},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+			srv.AddRule(smtptest.Rule{Command: "MESSAGE", Delay: 20 * time.Millisecond})
+
+			d := &countingDialer{}
+			m := New(srv.Addr, nil)
+			defer m.Close()
+			m.DialFunc(d.dial)
+			m.PoolSize(tt.poolSize)
+			m.Limits(Limits{MaxConnsPerHost: 2})
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 6)
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					mail := m.NewMail()
+					mail.From("from@example.org")
+					mail.To("to@example.org")
+					errs <- m.Send(mail)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if d.maxOpen > 2 {
+				t.Errorf("got %d concurrent connections, want <= 2", d.maxOpen)
+			}
+			if got := len(srv.Transactions()); got != 6 {
+				t.Errorf("got %d transactions, want 6", got)
+			}
+		})
+	}
+}
+
+// TestLimitsMaxConnsPerHostNoWait ensures a *RateLimitError is returned when
+// the connection limit is reached and NoWait is set.
+func TestLimitsMaxConnsPerHostNoWait(t *testing.T) {
+	t.Parallel()
+
+	l := newLimiter(Limits{MaxConnsPerHost: 1, NoWait: true})
+
+	release, err := l.acquireConn(context.Background(), "a:25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other hosts are limited independently.
+	releaseB, err := l.acquireConn(context.Background(), "b:25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseB()
+
+	var limitErr *RateLimitError
+	if _, err := l.acquireConn(context.Background(), "a:25"); !errors.As(err, &limitErr) {
+		t.Fatalf("got error %v, want *RateLimitError", err)
+	}
+
+	release()
+	if _, err := l.acquireConn(context.Background(), "a:25"); err != nil {
+		t.Fatalf("got error %v after release, want nil", err)
+	}
+}
@@ -48,9 +48,20 @@ type Mail struct {
 	subject        string
 	fromAddr       string
 	fromName       string
+	envelopeFrom   string
+	hasEnvelope    bool // envelopeFrom is set, possibly to the null sender
+	verp           string
+	dsn            *DSN
 	replyTo        string
 	date           string
 	writeBccHeader bool
+	routingTag     string
+
+	// attachmentsRead is set once the attachments have been read by
+	// writeAttachments, and attachmentOffsets records the position of any
+	// seekable attachment readers at that time so they can be rewound.
+	attachmentsRead   bool
+	attachmentOffsets []int64
 }
 
 // Reset clean Mail struct for reuse
@@ -68,9 +79,16 @@ func (m *Mail) Reset() {
 	m.subject = ""
 	m.fromAddr = ""
 	m.fromName = ""
+	m.envelopeFrom = ""
+	m.hasEnvelope = false
+	m.verp = ""
+	m.dsn = nil
 	m.replyTo = ""
 	m.date = ""
 	m.writeBccHeader = false
+	m.routingTag = ""
+	m.attachmentsRead = false
+	m.attachmentOffsets = nil
 }
 
 // String returns a redacted description of the email state, typically for
@@ -83,6 +101,11 @@ func (m *Mail) String() string {
 		att = append(att, "{filename: "+a.filename+"}")
 	}
 
+	var verp string
+	if m.verp != "" {
+		verp = fmt.Sprintf("verp: %q, ", m.verp)
+	}
+
 	var custom string
 	if len(m.headers) > 0 {
 		var hdrs []string
@@ -93,11 +116,13 @@ func (m *Mail) String() string {
 	}
 
 	return fmt.Sprintf(
-		"&Mail{date: %q, from: %q, fromName: %q, html: %v bytes, plain: %v bytes, toAddrs: %v, "+
-			"bccAddrs: %v, subject: %q, %vattachments (%v): %v, auth set: %v}",
+		"&Mail{date: %q, from: %q, fromName: %q, envelopeFrom: %q, %vhtml: %v bytes, plain: %v bytes, "+
+			"toAddrs: %v, bccAddrs: %v, subject: %q, %vattachments (%v): %v, auth set: %v}",
 		m.date,
 		m.fromAddr,
 		m.fromName,
+		"<"+m.getFromAddr()+">",
+		verp,
 		len(m.HTML().String()),
 		len(m.Plain().String()),
 		m.toAddrs,
@@ -118,7 +143,7 @@ func (m *Mail) MimeBuf() (*bytes.Buffer, error) {
 	m.date = time.Now().Format(mailDateFormat)
 
 	buf := &bytes.Buffer{}
-	if err := m.buildMime(buf); err != nil {
+	if err := m.buildMime(buf, encoding7Bit); err != nil {
 		return nil, err
 	}
 
@@ -138,9 +163,52 @@ func (m *Mail) Plain() *bytebufferpool.ByteBuffer {
 // getFromAddr should return the address to be used in the MAIL FROM
 // command.
 func (m *Mail) getFromAddr() string {
+	if m.hasEnvelope {
+		return m.envelopeFrom
+	}
+	return m.fromAddr
+}
+
+// getHeaderFrom should return the address of the From header.
+func (m *Mail) getHeaderFrom() string {
 	return m.fromAddr
 }
 
+// getVERP should return the VERP template if configured, or an empty string if
+// not.
+func (m *Mail) getVERP() string {
+	return m.verp
+}
+
+// getDSN should return the DSN options if configured, nil if not.
+func (m *Mail) getDSN() *DSN {
+	return m.dsn
+}
+
+// getRoutingTag should return the tag used to select a Transport by a
+// Router.
+func (m *Mail) getRoutingTag() string {
+	return m.routingTag
+}
+
+// requiresSMTPUTF8 should return true if the headers contain an address with
+// a non-ASCII local part, which can only be sent with SMTPUTF8.
+func (m *Mail) requiresSMTPUTF8() bool {
+	addrs := []string{m.fromAddr, m.replyTo}
+	addrs = append(addrs, m.toAddrs...)
+	addrs = append(addrs, m.ccAddrs...)
+	if m.writeBccHeader {
+		addrs = append(addrs, m.bccAddrs...)
+	}
+
+	for _, addr := range addrs {
+		if headerRequiresSMTPUTF8(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // getAuth should return the smtp.Auth if configured, nil if not.
 func (m *Mail) getAuth() smtp.Auth {
 	return m.auth
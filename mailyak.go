@@ -1,8 +1,10 @@
 package mailyak
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/smtp"
 	"regexp"
 	"time"
@@ -10,9 +12,13 @@ import (
 
 // MailYak is an easy-to-use email builder.
 type MailYak struct {
-	sender   emailSender
+	sender   Transport
+	pool     *sessionPool
+	config   *smtpConfig
+	retry    RetryPolicy
 	auth     smtp.Auth
 	needAuth bool
+	poolSize int
 }
 
 // New returns an instance of MailYak using host as the SMTP server, and
@@ -28,11 +34,16 @@ type MailYak struct {
 //      ))
 //
 // MailYak instances created with New will switch to using TLS after connecting
-// if the remote host supports the STARTTLS command. For an explicit TLS
-// connection, or to provide a custom tls.Config, use NewWithTLS() instead.
+// if the remote host supports the STARTTLS command - see StartTLS and
+// StartTLSConfig to require TLS, or to provide a custom tls.Config. For an
+// explicit TLS connection, use NewWithTLS() instead.
 func New(host string, auth smtp.Auth) *MailYak {
+	config := &smtpConfig{}
+	sender := newSenderWithStartTLS(host, auth, config)
 	m := &MailYak{
-		sender: newSenderWithStartTLS(host),
+		sender: sender,
+		pool:   newSessionPool(sender),
+		config: config,
 	}
 	m.auth = auth
 	return m
@@ -60,11 +71,12 @@ func NewWithTLS(host string, auth smtp.Auth, tlsConfig *tls.Config) (*MailYak, e
 
 	// Initialise the TLS sender with the (potentially nil) TLS config, swapping
 	// it with the default STARTTLS sender.
-	var err error
-	m.sender, err = newSenderWithExplicitTLS(host, tlsConfig)
+	sender, err := newSenderWithExplicitTLS(host, auth, tlsConfig, m.config)
 	if err != nil {
 		return nil, err
 	}
+	m.sender = sender
+	m.pool = newSessionPool(sender)
 
 	return m, nil
 }
@@ -83,10 +95,254 @@ func (m *MailYak) NewMail() *Mail {
 //
 // Attachments are read and the email timestamp is created when Send() is
 // called, and any connection/authentication errors will be returned by Send().
+//
+// Send is equivalent to SendContext with a background context.
 func (m *MailYak) Send(mail *Mail) error {
+	return m.SendContext(context.Background(), mail)
+}
+
+// SendContext attempts to send the built email via the configured SMTP server,
+// aborting the attempt if ctx is cancelled or its deadline is exceeded.
+//
+// Cancellation is honoured while connecting, during the TLS handshake,
+// authentication, and every SMTP command including the transfer of the message
+// content. If ctx is cancelled, ctx.Err() is returned.
+//
+// Timeouts for individual phases of the SMTP conversation can be configured
+// with DialTimeout, CommandTimeout and DataTimeout.
+func (m *MailYak) SendContext(ctx context.Context, mail *Mail) error {
+	_, err := m.Deliver(ctx, mail)
+	return err
+}
+
+// Deliver sends the built email in the same way as SendContext, additionally
+// returning a SendResult describing which recipients were accepted or rejected
+// by the SMTP server.
+//
+// SMTP error replies are returned as a *SMTPError, allowing temporary (4xx)
+// and permanent (5xx) failures to be distinguished using errors.As.
+//
+// By default the first recipient rejected by the server aborts the send. If
+// AllowPartialDelivery is enabled, the email is sent to the accepted
+// recipients and a *PartialDeliveryError listing the rejected recipients is
+// returned alongside the SendResult.
+//
+// If a RetryPolicy has been configured with Retry, transient failures are
+// retried before Deliver returns.
+func (m *MailYak) Deliver(ctx context.Context, mail *Mail) (*SendResult, error) {
 	defer putMail(mail)
 	mail.date = time.Now().Format(mailDateFormat)
-	return m.sender.Send(mail)
+	return m.sendWithRetry(ctx, mail)
+}
+
+// send makes a single attempt to send msg.
+func (m *MailYak) send(ctx context.Context, msg Message) (*SendResult, error) {
+	if v, ok := msg.(verpMessage); ok && v.getVERP() != "" {
+		return m.sendVERP(ctx, msg, v.getVERP())
+	}
+	return m.sendTransaction(ctx, msg)
+}
+
+// sendTransaction sends msg in a single mail transaction, applying any
+// configured limits.
+func (m *MailYak) sendTransaction(ctx context.Context, msg Message) (*SendResult, error) {
+	if l := m.config.limiter; l != nil {
+		if err := l.wait(ctx, len(msg.Envelope().To)); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.pool != nil && m.pool.enabled() {
+		return m.pool.Send(ctx, msg)
+	}
+	return m.sender.Send(ctx, msg)
+}
+
+// AllowPartialDelivery controls the behaviour when the SMTP server rejects
+// some, but not all of the recipients. Defaults to false.
+//
+// When false, the first rejected recipient aborts the send and the email is
+// not sent to anyone. When true, the rejected recipients are skipped and the
+// email is sent to the remaining recipients, with the rejections reported by
+// a *PartialDeliveryError.
+func (m *MailYak) AllowPartialDelivery(allow bool) {
+	m.config.allowPartial = allow
+}
+
+// StartTLS sets the policy for upgrading the connection to the SMTP server
+// using STARTTLS. Defaults to StartTLSOpportunistic.
+//
+// Use StartTLSMandatory to ensure credentials and email content are never
+// sent in plain-text - sends to a server that does not support STARTTLS fail
+// with ErrStartTLSUnavailable.
+//
+// The policy has no effect on MailYak instances created with NewWithTLS,
+// which always use TLS.
+func (m *MailYak) StartTLS(policy StartTLSPolicy) {
+	m.config.startTLSPolicy = policy
+}
+
+// StartTLSConfig sets the tls.Config used when upgrading the connection with
+// STARTTLS, allowing the trusted CAs, minimum TLS version and client
+// certificates to be configured. If the ServerName is empty, it is set to the
+// SMTP server hostname.
+//
+// The configuration is copied, and further changes to tlsConfig have no
+// effect. If nil (the default), the server certificate is verified against the
+// system CAs.
+func (m *MailYak) StartTLSConfig(tlsConfig *tls.Config) {
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+	m.config.tlsConfig = tlsConfig
+}
+
+// LocalName sets the hostname MailYak identifies itself with in the EHLO/HELO
+// command. Defaults to "localhost".
+//
+// Many receiving servers penalise clients that do not send a fully qualified
+// domain name resolving to the connecting IP address.
+func (m *MailYak) LocalName(name string) {
+	m.config.localName = name
+}
+
+// Dialer sets the net.Dialer used to connect to the SMTP server, allowing the
+// local address, keep-alive and other connection settings to be configured.
+//
+// The dialer is copied, and further changes to d have no effect. The timeout
+// set by DialTimeout and the context passed to SendContext are applied in
+// addition to any Timeout or Deadline set on d. Dialer has no effect if a
+// DialFunc is set - to connect through a proxy with d, pass d.DialContext as
+// the forward DialFunc of SOCKS5 or ProxyFromEnvironment.
+func (m *MailYak) Dialer(d *net.Dialer) {
+	if d != nil {
+		copied := *d
+		d = &copied
+	}
+	m.config.dialer = d
+}
+
+// DialFunc sets a function used to open connections to the SMTP server in
+// place of a net.Dialer, such as to connect through a SOCKS5 proxy:
+//
+//	dial, err := mailyak.ProxyFromEnvironment(nil)
+//	if err != nil {
+//		return err
+//	}
+//	my.DialFunc(dial)
+//
+// For NewWithTLS instances, the TLS handshake is performed over the returned
+// connection. A nil DialFunc (the default) uses the configured Dialer.
+func (m *MailYak) DialFunc(fn DialFunc) {
+	m.config.dialFunc = fn
+}
+
+// KeepAlive sets the TCP keep-alive period for connections to the SMTP server,
+// overriding any value set on the Dialer. A negative duration disables
+// keep-alives, and 0 (the default) uses the Dialer (or operating system)
+// default.
+//
+// KeepAlive also applies to connections opened by a DialFunc returning a
+// *net.TCPConn, such as the connection to the proxy opened by SOCKS5.
+//
+// Keep-alives are useful to detect dead pooled connections.
+func (m *MailYak) KeepAlive(d time.Duration) {
+	m.config.keepAlive = d
+}
+
+// DialTimeout sets the maximum amount of time to wait for a connection to the
+// SMTP server to be established, including the TLS handshake when using
+// NewWithTLS. A timeout of 0 (the default) applies no limit.
+func (m *MailYak) DialTimeout(d time.Duration) {
+	m.config.dialTimeout = d
+}
+
+// CommandTimeout sets the maximum amount of time to wait for each SMTP command
+// to be sent and responded to by the server, including the initial greeting,
+// STARTTLS upgrade and authentication exchange. A timeout of 0 (the default)
+// applies no limit.
+func (m *MailYak) CommandTimeout(d time.Duration) {
+	m.config.commandTimeout = d
+}
+
+// DataTimeout sets the maximum amount of time allowed to transfer the message
+// content and receive the server's acknowledgement. A timeout of 0 (the
+// default) applies no limit.
+//
+// Emails with large attachments may need a longer timeout than
+// CommandTimeout.
+func (m *MailYak) DataTimeout(d time.Duration) {
+	m.config.dataTimeout = d
+}
+
+// PoolSize enables reuse of SMTP connections across calls to Send, keeping at
+// most n authenticated sessions open to the SMTP server at any one time.
+//
+// Once an email has been sent the session is reset with RSET and held open
+// for the next email, skipping the connection, TLS handshake and
+// authentication steps. Idle sessions are checked with a NOOP before reuse,
+// and dead connections are transparently replaced. If all n sessions are in
+// use, Send blocks until one becomes available.
+//
+// A size of 0 (the default) disables pooling, and each call to Send uses a new
+// connection. PoolSize should be called before the first call to Send, and
+// Close should be called once the MailYak instance is no longer needed to
+// release any idle connections.
+//
+// If a connection limit is set with Limits, the pool holds at most
+// Limits.MaxConnsPerHost sessions.
+func (m *MailYak) PoolSize(n int) {
+	m.poolSize = n
+	m.resizePool()
+}
+
+// resizePool applies the configured pool size, bounded by the connection
+// limit.
+func (m *MailYak) resizePool() {
+	if m.pool == nil {
+		return
+	}
+	m.pool.setSize(poolSize(m.poolSize, m.config.limiter))
+}
+
+// PoolIdleTimeout sets the maximum duration a pooled connection may remain
+// unused before it is closed rather than reused. A duration of 0 (the default)
+// keeps idle connections open until the remote server closes them.
+//
+// Most SMTP servers close idle connections after a few minutes - setting the
+// idle timeout lower than the server's avoids a failed NOOP on reuse.
+func (m *MailYak) PoolIdleTimeout(d time.Duration) {
+	if m.pool == nil {
+		return
+	}
+	m.pool.setIdleTimeout(d)
+}
+
+// MaxMessagesPerConn sets the maximum number of emails sent over a single
+// pooled connection before it is closed and replaced with a new one. A limit
+// of 0 (the default) allows an unlimited number of emails per connection.
+//
+// Some SMTP servers limit the number of messages accepted per connection.
+//
+// Each mail transaction counts towards the limit, so an email split across
+// several transactions (see MaxRecipients) counts once for each. As the
+// transactions of a split email are sent over the same connection, it is
+// closed once the email has been sent if the limit was reached.
+func (m *MailYak) MaxMessagesPerConn(n int) {
+	if m.pool == nil {
+		return
+	}
+	m.pool.setMaxMessages(n)
+}
+
+// Close releases any pooled SMTP connections, sending QUIT to the server.
+//
+// Calling Send after Close returns ErrPoolClosed if pooling is enabled.
+func (m *MailYak) Close() error {
+	if m.pool == nil {
+		return nil
+	}
+	return m.pool.Close()
 }
 
 // String returns a redacted description of the email state, typically for
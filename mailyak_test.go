@@ -29,7 +29,7 @@ func TestMailStringer(t *testing.T) {
 
 	mail.date = "a date"
 
-	want := "&Mail{date: \"a date\", from: \"from@example.org\", fromName: \"From Example\", html: 31 bytes, plain: 42 bytes, toAddrs: [to@example.org], bccAddrs: [bcc1@example.org bcc2@example.org], subject: \"Test subject\", Precedence: \"bulk\", attachments (2): [{filename: test.html} {filename: test2.html}], auth set: true}"
+	want := "&Mail{date: \"a date\", from: \"from@example.org\", fromName: \"From Example\", envelopeFrom: \"<from@example.org>\", html: 31 bytes, plain: 42 bytes, toAddrs: [to@example.org], bccAddrs: [bcc1@example.org bcc2@example.org], subject: \"Test subject\", Precedence: \"bulk\", attachments (2): [{filename: test.html} {filename: test2.html}], auth set: true}"
 	got := fmt.Sprintf("%+v", mail)
 	if got != want {
 		t.Errorf("Mail.String() = %v, want %v", got, want)
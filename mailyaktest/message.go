@@ -0,0 +1,299 @@
+package mailyaktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3"
+)
+
+// Message is a parsed copy of a sent email.
+type Message struct {
+	// Envelope holds the SMTP envelope sender and recipients.
+	Envelope mailyak.Envelope
+
+	// Header contains the raw message headers.
+	Header mail.Header
+
+	// Subject is the decoded Subject header.
+	Subject string
+
+	// Text is the decoded text/plain body, if any.
+	Text string
+
+	// HTML is the decoded text/html body, if any.
+	HTML string
+
+	// Attachments lists the decoded attachments, including inline
+	// attachments, in the order they appear in the message.
+	Attachments []Attachment
+
+	// Raw is the complete MIME content as sent.
+	Raw []byte
+}
+
+// Attachment is a decoded attachment of a sent email.
+type Attachment struct {
+	// Filename is the name given to the attachment.
+	Filename string
+
+	// ContentType is the MIME type of the attachment, excluding parameters.
+	ContentType string
+
+	// Inline is true for inline attachments.
+	Inline bool
+
+	// Data is the decoded attachment content.
+	Data []byte
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// Parse parses the raw MIME content of an email sent with envelope.
+func Parse(envelope mailyak.Envelope, raw []byte) (*Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Envelope: mailyak.Envelope{
+			From: envelope.From,
+			To:   append([]string(nil), envelope.To...),
+		},
+		Header:  msg.Header,
+		Subject: subject,
+		Raw:     raw,
+	}
+
+	if err := m.parsePart(msg.Header.Get("Content-Type"), "", msg.Body); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// parsePart decodes a MIME part with the given content type and disposition,
+// recursing into multipart content.
+func (m *Message) parsePart(contentType, disposition string, body io.Reader) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			// MailYak writes an empty multipart/alternative part for emails
+			// without a body.
+			return nil
+		}
+
+		mr := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+		for {
+			// NextPart transparently decodes quoted-printable parts.
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+				err = m.parsePart(
+					part.Header.Get("Content-Type"),
+					part.Header.Get("Content-Disposition"),
+					base64.NewDecoder(base64.StdEncoding, part),
+				)
+			} else {
+				err = m.parsePart(
+					part.Header.Get("Content-Type"),
+					part.Header.Get("Content-Disposition"),
+					part,
+				)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	if disposition == "" {
+		switch mediaType {
+		case "text/plain":
+			m.Text += string(data)
+			return nil
+		case "text/html":
+			m.HTML += string(data)
+			return nil
+		}
+	}
+
+	a := Attachment{
+		Filename:    params["filename"],
+		ContentType: mediaType,
+		Data:        data,
+	}
+	if disposition != "" {
+		dispType, dispParams, err := mime.ParseMediaType(disposition)
+		if err != nil {
+			return err
+		}
+		a.Inline = dispType == "inline"
+		if name := dispParams["filename"]; name != "" {
+			a.Filename = name
+		}
+	}
+	m.Attachments = append(m.Attachments, a)
+
+	return nil
+}
+
+// hasRecipient returns true if addr is an envelope recipient of m.
+func (m *Message) hasRecipient(addr string) bool {
+	for _, to := range m.Envelope.To {
+		if strings.EqualFold(to, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attachment returns the attachment with the given filename, or nil if no
+// such attachment exists.
+func (m *Message) Attachment(filename string) *Attachment {
+	for i := range m.Attachments {
+		if m.Attachments[i].Filename == filename {
+			return &m.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// AttachmentNames returns the filenames of all the attachments.
+func (m *Message) AttachmentNames() []string {
+	names := make([]string, 0, len(m.Attachments))
+	for _, a := range m.Attachments {
+		names = append(names, a.Filename)
+	}
+	return names
+}
+
+// AssertFrom fails the test if the envelope sender is not addr.
+func (m *Message) AssertFrom(t testing.TB, addr string) {
+	t.Helper()
+
+	if m.Envelope.From != addr {
+		t.Errorf("mailyaktest: got sender %q, want %q", m.Envelope.From, addr)
+	}
+}
+
+// AssertRecipients fails the test if the envelope recipients (including CC
+// and BCC recipients) are not exactly addrs, in any order.
+func (m *Message) AssertRecipients(t testing.TB, addrs ...string) {
+	t.Helper()
+
+	got := append([]string(nil), m.Envelope.To...)
+	want := append([]string(nil), addrs...)
+	sort.Strings(got)
+	sort.Strings(want)
+
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mailyaktest: got recipients %q, want %q", got, want)
+	}
+}
+
+// AssertSubject fails the test if the decoded subject is not subject.
+func (m *Message) AssertSubject(t testing.TB, subject string) {
+	t.Helper()
+
+	if m.Subject != subject {
+		t.Errorf("mailyaktest: got subject %q, want %q", m.Subject, subject)
+	}
+}
+
+// AssertHeader fails the test if the header with the given name does not have
+// the value want.
+func (m *Message) AssertHeader(t testing.TB, name, want string) {
+	t.Helper()
+
+	if got := m.Header.Get(name); got != want {
+		t.Errorf("mailyaktest: got %s header %q, want %q", name, got, want)
+	}
+}
+
+// AssertTextContains fails the test if the plain-text body does not contain
+// substr.
+func (m *Message) AssertTextContains(t testing.TB, substr string) {
+	t.Helper()
+
+	if !strings.Contains(m.Text, substr) {
+		t.Errorf("mailyaktest: plain-text body does not contain %q:\n%s", substr, m.Text)
+	}
+}
+
+// AssertHTMLContains fails the test if the HTML body does not contain substr.
+func (m *Message) AssertHTMLContains(t testing.TB, substr string) {
+	t.Helper()
+
+	if !strings.Contains(m.HTML, substr) {
+		t.Errorf("mailyaktest: HTML body does not contain %q:\n%s", substr, m.HTML)
+	}
+}
+
+// AssertAttachments fails the test if the attachment filenames are not
+// exactly names, in the order given.
+func (m *Message) AssertAttachments(t testing.TB, names ...string) {
+	t.Helper()
+
+	got := m.AttachmentNames()
+	if len(got) == 0 && len(names) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf("mailyaktest: got attachments %q, want %q", got, names)
+	}
+}
+
+// String returns a short description of the message, typically for test
+// failure output.
+func (m *Message) String() string {
+	return fmt.Sprintf(
+		"&Message{from: %q, to: %v, subject: %q, attachments: %v}",
+		m.Envelope.From,
+		m.Envelope.To,
+		m.Subject,
+		m.AttachmentNames(),
+	)
+}
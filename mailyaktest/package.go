@@ -0,0 +1,24 @@
+// Package mailyaktest provides utilities for testing code that sends emails
+// with MailYak, without connecting to a SMTP server.
+//
+// A Recorder is a mailyak.Transport that captures every email sent, parsing
+// the MIME content so tests can make assertions against the recipients,
+// headers, decoded body text and attachments:
+//
+//	func TestWelcomeEmail(t *testing.T) {
+//		my, rec := mailyaktest.New()
+//
+//		if err := sendWelcomeEmail(my, "dom@itsallbroken.com"); err != nil {
+//			t.Fatal(err)
+//		}
+//
+//		msg := rec.Last(t)
+//		msg.AssertRecipients(t, "dom@itsallbroken.com")
+//		msg.AssertSubject(t, "Welcome!")
+//		msg.AssertTextContains(t, "Thanks for signing up")
+//		msg.AssertAttachments(t, "getting-started.pdf")
+//	}
+//
+// Recorders are safe for concurrent use, and can be shared between parallel
+// tests.
+package mailyaktest
@@ -0,0 +1,160 @@
+package mailyaktest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/xenking/mailyak/v3"
+)
+
+// Recorder is a mailyak.Transport that records each message sent instead of
+// delivering it.
+//
+// The zero value is ready to use.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+}
+
+// New returns a MailYak instance that records all sent emails in the returned
+// Recorder.
+func New() (*mailyak.MailYak, *Recorder) {
+	rec := &Recorder{}
+	return mailyak.NewWithTransport(rec), rec
+}
+
+// Send records msg, parsing the MIME content.
+//
+// If an error has been configured with SetError, the message is recorded and
+// the error returned.
+func (r *Recorder) Send(ctx context.Context, msg mailyak.Message) (*mailyak.SendResult, error) {
+	buf := &bytes.Buffer{}
+	if err := msg.WriteMIME(buf); err != nil {
+		return nil, err
+	}
+
+	parsed, err := Parse(msg.Envelope(), buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("mailyaktest: failed to parse sent message: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, parsed)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	result := &mailyak.SendResult{}
+	for _, to := range parsed.Envelope.To {
+		result.Accepted = append(result.Accepted, mailyak.RecipientStatus{
+			Address: to,
+			Code:    250,
+			Message: "OK",
+		})
+	}
+
+	return result, nil
+}
+
+// SetError causes all subsequent calls to Send to return err, allowing error
+// handling to be tested. Pass nil to clear the error.
+func (r *Recorder) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+}
+
+// Messages returns a copy of the list of recorded messages, in the order they
+// were sent.
+func (r *Recorder) Messages() []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]*Message, len(r.messages))
+	copy(out, r.messages)
+	return out
+}
+
+// Len returns the number of recorded messages.
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.messages)
+}
+
+// Reset discards all recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
+
+// Last returns the most recently recorded message, failing the test if no
+// messages have been sent.
+func (r *Recorder) Last(t testing.TB) *Message {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.messages) == 0 {
+		t.Fatal("mailyaktest: no messages sent")
+		return nil
+	}
+	return r.messages[len(r.messages)-1]
+}
+
+// SentTo returns the recorded messages with addr as an envelope recipient
+// (including CC and BCC recipients).
+func (r *Recorder) SentTo(addr string) []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []*Message
+	for _, m := range r.messages {
+		if m.hasRecipient(addr) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// AssertCount fails the test if the number of recorded messages is not n.
+func (r *Recorder) AssertCount(t testing.TB, n int) {
+	t.Helper()
+
+	if got := r.Len(); got != n {
+		t.Errorf("mailyaktest: got %d messages sent, want %d", got, n)
+	}
+}
+
+// AssertSentTo fails the test if no message was sent to each of addrs.
+func (r *Recorder) AssertSentTo(t testing.TB, addrs ...string) {
+	t.Helper()
+
+	for _, addr := range addrs {
+		if len(r.SentTo(addr)) == 0 {
+			t.Errorf("mailyaktest: no message sent to %q", addr)
+		}
+	}
+}
+
+// AssertNotSentTo fails the test if any message was sent to any of addrs.
+func (r *Recorder) AssertNotSentTo(t testing.TB, addrs ...string) {
+	t.Helper()
+
+	for _, addr := range addrs {
+		if n := len(r.SentTo(addr)); n > 0 {
+			t.Errorf("mailyaktest: %d message(s) sent to %q, want none", n, addr)
+		}
+	}
+}
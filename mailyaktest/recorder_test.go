@@ -0,0 +1,167 @@
+package mailyaktest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeT records test failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatal(args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, fmt.Sprint(args...))
+}
+
+// TestRecorder ensures a sent email is recorded and decoded, and the
+// assertions pass.
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	my, rec := New()
+
+	mail := my.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Cc("cc@example.org")
+	mail.Bcc("bcc@example.org")
+	mail.Subject("Bienvenue à bord")
+	mail.AddHeader("X-Campaign", "welcome")
+	mail.Plain().SetString("Thanks for signing up = good choice")
+	mail.HTML().SetString("<p>Thanks for signing up</p>")
+	mail.Attach("advice.txt", strings.NewReader("Don't Panic"))
+	mail.AttachInline("logo.png", strings.NewReader("\x89PNG\r\n\x1a\n"))
+
+	if err := my.Send(mail); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	rec.AssertCount(t, 1)
+	rec.AssertSentTo(t, "to@example.org", "cc@example.org", "bcc@example.org")
+	rec.AssertNotSentTo(t, "nobody@example.org")
+
+	msg := rec.Last(t)
+	msg.AssertFrom(t, "from@example.org")
+	msg.AssertRecipients(t, "bcc@example.org", "cc@example.org", "to@example.org")
+	msg.AssertSubject(t, "Bienvenue à bord")
+	msg.AssertHeader(t, "X-Campaign", "welcome")
+	msg.AssertTextContains(t, "Thanks for signing up = good choice")
+	msg.AssertHTMLContains(t, "<p>Thanks for signing up</p>")
+	msg.AssertAttachments(t, "advice.txt", "logo.png")
+
+	advice := msg.Attachment("advice.txt")
+	if advice == nil || string(advice.Data) != "Don't Panic" || advice.Inline {
+		t.Errorf("unexpected attachment %+v", advice)
+	}
+	if logo := msg.Attachment("logo.png"); logo == nil || !logo.Inline || logo.ContentType != "image/png" {
+		t.Errorf("unexpected inline attachment %+v", logo)
+	}
+}
+
+// TestRecorderAssertionFailures ensures the assertions fail when the recorded
+// message does not match.
+func TestRecorderAssertionFailures(t *testing.T) {
+	t.Parallel()
+
+	my, rec := New()
+
+	ft := &fakeT{}
+	rec.Last(ft)
+	if len(ft.errors) != 1 {
+		t.Errorf("Last() with no messages: got %d failures, want 1", len(ft.errors))
+	}
+
+	mail := my.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Subject("Hello")
+	mail.Plain().SetString("Hi")
+	if err := my.Send(mail); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	ft = &fakeT{}
+	msg := rec.Last(ft)
+	msg.AssertFrom(ft, "other@example.org")
+	msg.AssertRecipients(ft, "other@example.org")
+	msg.AssertSubject(ft, "Goodbye")
+	msg.AssertTextContains(ft, "Bye")
+	msg.AssertHTMLContains(ft, "Bye")
+	msg.AssertAttachments(ft, "missing.txt")
+	rec.AssertCount(ft, 2)
+	rec.AssertSentTo(ft, "other@example.org")
+	rec.AssertNotSentTo(ft, "to@example.org")
+
+	if got, want := len(ft.errors), 9; got != want {
+		t.Errorf("got %d failures, want %d: %q", got, want, ft.errors)
+	}
+}
+
+// TestRecorderConcurrent ensures the Recorder can be used from many goroutines
+// concurrently.
+func TestRecorderConcurrent(t *testing.T) {
+	t.Parallel()
+
+	const n = 50
+
+	my, rec := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			mail := my.NewMail()
+			mail.From("from@example.org")
+			mail.To(fmt.Sprintf("user%d@example.org", i))
+			mail.Subject("Concurrent")
+			if err := my.Send(mail); err != nil {
+				t.Errorf("Send() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rec.AssertCount(t, n)
+	for i := 0; i < n; i++ {
+		rec.AssertSentTo(t, fmt.Sprintf("user%d@example.org", i))
+	}
+
+	rec.Reset()
+	rec.AssertCount(t, 0)
+}
+
+// TestRecorderSetError ensures a configured error is returned by Send.
+func TestRecorderSetError(t *testing.T) {
+	t.Parallel()
+
+	my, rec := New()
+
+	wantErr := errors.New("bananas")
+	rec.SetError(wantErr)
+
+	mail := my.NewMail()
+	mail.To("to@example.org")
+	if err := my.Send(mail); err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+	rec.AssertCount(t, 1)
+}
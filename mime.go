@@ -13,7 +13,7 @@ import (
 	"strings"
 )
 
-func (m *Mail) buildMime(w io.Writer) error {
+func (m *Mail) buildMime(w io.Writer, enc transferEncoding) error {
 	mb, err := randomBoundary()
 	if err != nil {
 		return err
@@ -24,7 +24,7 @@ func (m *Mail) buildMime(w io.Writer) error {
 		return err
 	}
 
-	return m.buildMimeWithBoundaries(w, mb, ab)
+	return m.buildMimeWithBoundaries(w, mb, ab, enc)
 }
 
 // randomBoundary returns a random hexadecimal string used for separating MIME
@@ -42,8 +42,9 @@ func randomBoundary() (string, error) {
 }
 
 // buildMimeWithBoundaries creates the MIME message using mb and ab as MIME
-// boundaries, and returns the generated MIME data as a buffer.
-func (m *Mail) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
+// boundaries and the transfer encodings allowed by enc, and returns the
+// generated MIME data as a buffer.
+func (m *Mail) buildMimeWithBoundaries(w io.Writer, mb, ab string, enc transferEncoding) error {
 	if err := m.writeHeaders(w); err != nil {
 		return err
 	}
@@ -65,7 +66,7 @@ func (m *Mail) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
 		bytebufferpool.Put(buf)
 
 		var ctype strings.Builder
-		ctype.WriteString("multipart/alternative;\n\tboundary=\"")
+		ctype.WriteString("multipart/alternative;\r\n\tboundary=\"")
 		ctype.WriteString(ab)
 		ctype.WriteByte('"')
 
@@ -74,11 +75,11 @@ func (m *Mail) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
 			return err
 		}
 
-		if err := m.writeBody(altPart, ab); err != nil {
+		if err := m.writeBody(altPart, ab, enc); err != nil {
 			return err
 		}
 
-		return m.writeAttachments(mixed, lineSplitterBuilder{})
+		return m.writeAttachments(mixed, lineSplitterBuilder{}, enc)
 	}
 
 	if err := tryWrite(); err != nil {
@@ -106,7 +107,7 @@ func (m *Mail) writeHeaders(w io.Writer) error {
 
 	if m.replyTo != "" {
 		buf.WriteString("Reply-To: ")
-		buf.WriteString(m.replyTo)
+		buf.WriteString(headerAddress(m.replyTo))
 		buf.WriteString("\r\n")
 	}
 
@@ -116,20 +117,20 @@ func (m *Mail) writeHeaders(w io.Writer) error {
 
 	for _, to := range m.toAddrs {
 		buf.WriteString("To: ")
-		buf.WriteString(to)
+		buf.WriteString(headerAddress(to))
 		buf.WriteString("\r\n")
 	}
 
 	for _, cc := range m.ccAddrs {
 		buf.WriteString("CC: ")
-		buf.WriteString(cc)
+		buf.WriteString(headerAddress(cc))
 		buf.WriteString("\r\n")
 	}
 
 	if m.writeBccHeader {
 		for _, bcc := range m.bccAddrs {
 			buf.WriteString("BCC: ")
-			buf.WriteString(bcc)
+			buf.WriteString(headerAddress(bcc))
 			buf.WriteString("\r\n")
 		}
 	}
@@ -149,14 +150,15 @@ func (m *Mail) writeHeaders(w io.Writer) error {
 // component.
 func (m *Mail) fromHeader() string {
 	if m.fromName == "" {
-		return fmt.Sprintf("From: %s\r\n", m.fromAddr)
+		return fmt.Sprintf("From: %s\r\n", headerAddress(m.fromAddr))
 	}
 
-	return fmt.Sprintf("From: %s <%s>\r\n", m.fromName, m.fromAddr)
+	return fmt.Sprintf("From: %s <%s>\r\n", m.fromName, headerAddress(m.fromAddr))
 }
 
-// writeBody writes the text/plain and text/html mime parts.
-func (m *Mail) writeBody(w io.Writer, boundary string) error {
+// writeBody writes the text/plain and text/html mime parts, quoted-printable
+// encoded unless enc allows them to be sent as 8bit or binary.
+func (m *Mail) writeBody(w io.Writer, boundary string, enc transferEncoding) error {
 	if m.plain.Len() == 0 && m.html.Len() == 0 {
 		// No body to write - just skip it
 		return nil
@@ -176,12 +178,23 @@ func (m *Mail) writeBody(w io.Writer, boundary string) error {
 
 		c := fmt.Sprintf("%s; charset=UTF-8", ctype)
 
+		cte := bodyTransferEncoding(data, enc)
+
 		var part io.Writer
-		part, err = alt.CreatePart(textproto.MIMEHeader{"Content-Type": {c}, "Content-Transfer-Encoding": {"quoted-printable"}})
+		part, err = alt.CreatePart(textproto.MIMEHeader{"Content-Type": {c}, "Content-Transfer-Encoding": {cte}})
 		if err != nil {
 			return
 		}
 
+		switch cte {
+		case "8bit":
+			_, err = part.Write(toCRLF(data))
+			return
+		case "binary":
+			_, err = part.Write(data)
+			return
+		}
+
 		var buf bytes.Buffer
 		qpw := quotedprintable.NewWriter(&buf)
 		_, _ = qpw.Write(data)
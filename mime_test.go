@@ -266,7 +266,7 @@ func TestMailWriteBody(t *testing.T) {
 			_, _ = m.Plain().WriteString(tt.rPlain)
 
 			w := &bytes.Buffer{}
-			if err := m.writeBody(w, tt.boundary); (err != nil) != tt.wantErr {
+			if err := m.writeBody(w, tt.boundary, encoding7Bit); (err != nil) != tt.wantErr {
 				t.Fatalf("%q. Mail.writeBody() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
 
@@ -424,12 +424,12 @@ func TestMailBuildMime(t *testing.T) {
 			_, _ = m.Plain().Write(tt.rPlain)
 
 			buf := &bytes.Buffer{}
-			err := m.buildMimeWithBoundaries(buf, "mixed", "alt")
+			err := m.buildMimeWithBoundaries(buf, "mixed", "alt", encoding7Bit)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("%q. Mail.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
 
-			if bytes.Equal(buf.Bytes(), []byte(tt.want)) {
+			if !bytes.Equal(buf.Bytes(), []byte(tt.want)) {
 				t.Errorf("%q. Mail.buildMime() = %v, want %v", tt.name, buf.Bytes(), []byte(tt.want))
 			}
 		})
@@ -565,7 +565,7 @@ func TestMailBuildMime_withAttachments(t *testing.T) {
 			_, _ = m.Plain().Write(tt.rPlain)
 
 			buf := &bytes.Buffer{}
-			err := m.buildMimeWithBoundaries(buf, "mixed", "alt")
+			err := m.buildMimeWithBoundaries(buf, "mixed", "alt", encoding7Bit)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("%q. Mail.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
@@ -0,0 +1,345 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolver looks up the MX records of a domain, as used for direct-to-MX
+// delivery, and the addresses of domains without MX records.
+//
+// *net.Resolver implements Resolver.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DomainResult describes the outcome of delivering an email to the recipients
+// of a single domain.
+type DomainResult struct {
+	// Domain is the recipient domain.
+	Domain string
+
+	// Host is the MX host the email was delivered to or, if delivery failed,
+	// the last host attempted. Host is empty if no host could be found.
+	Host string
+
+	// Result describes the recipients accepted and rejected by Host, and may
+	// be nil if the MAIL FROM command was not accepted.
+	Result *SendResult
+
+	// Err is the error delivering to the domain, or nil if successful.
+	Err error
+}
+
+// MXDeliveryError is returned by direct-to-MX delivery when the email was
+// delivered to the recipients of some domains, but not others.
+//
+// If the email could not be delivered to any domain, the error for the first
+// domain is returned instead.
+type MXDeliveryError struct {
+	// Failed lists the domains the email could not be delivered to.
+	Failed []DomainResult
+}
+
+func (e *MXDeliveryError) partialDelivery() {}
+
+// Error returns a description of the failed domains.
+func (e *MXDeliveryError) Error() string {
+	var b strings.Builder
+	b.WriteString("mailyak: delivery failed for ")
+	b.WriteString(strconv.Itoa(len(e.Failed)))
+	b.WriteString(" domain(s): ")
+
+	for i, d := range e.Failed {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(d.Domain)
+		b.WriteString(" (")
+		b.WriteString(d.Err.Error())
+		b.WriteString(")")
+	}
+
+	return b.String()
+}
+
+// errNoSuchDomain is returned for domains that have neither MX nor address
+// records, such as domains that do not exist.
+var errNoSuchDomain = &SMTPError{
+	Code:         550,
+	EnhancedCode: "5.1.2",
+	Message:      "Recipient domain does not exist",
+}
+
+// errNullMX is returned for domains publishing a null MX record (RFC 7505),
+// indicating they do not accept email.
+var errNullMX = &SMTPError{
+	Code:         556,
+	EnhancedCode: "5.1.10",
+	Message:      "Recipient address has null MX",
+}
+
+// NewMX returns an instance of MailYak that delivers emails directly to the
+// MX hosts of each recipient domain, without a smarthost.
+//
+//	my := mailyak.NewMX(net.DefaultResolver)
+//	my.LocalName("alerts.example.org")
+//
+// Recipients are grouped by domain, and each domain's MX hosts are tried in
+// order of preference, falling back to the domain's A record if it has no MX
+// records. Domains that do not exist fail permanently with a *SMTPError.
+// Hosts are connected to on port 25, using STARTTLS according to the
+// configured StartTLSPolicy. Many MX hosts present certificates that fail
+// verification, so a StartTLSConfig may be required to deliver with
+// opportunistic TLS.
+//
+// The outcome for each domain is reported in SendResult.Domains by Deliver. If
+// some domains fail, a *MXDeliveryError is returned.
+//
+// If resolver is nil, net.DefaultResolver is used.
+func NewMX(resolver Resolver) *MailYak {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	config := &smtpConfig{}
+	return &MailYak{
+		sender: &mxTransport{
+			resolver: resolver,
+			config:   config,
+			port:     "25",
+		},
+		config: config,
+	}
+}
+
+// mxTransport delivers emails directly to the MX hosts of the recipient
+// domains.
+type mxTransport struct {
+	resolver Resolver
+	config   *smtpConfig
+	port     string
+}
+
+// Send delivers msg to the recipients of each domain in turn.
+func (t *mxTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	envelope := msg.Envelope()
+
+	// The MIME content is sent to each domain, so is built once up front.
+	var mime bytes.Buffer
+	if err := msg.WriteMIME(&mime); err != nil {
+		return nil, err
+	}
+
+	result := &SendResult{}
+	var failed []DomainResult
+	delivered := false
+	for _, group := range groupByDomain(envelope.To) {
+		dr := t.sendDomain(ctx, group.domain, &mxMessage{
+			envelope: Envelope{From: envelope.From, To: group.addrs},
+			mime:     mime.Bytes(),
+			source:   msg,
+		})
+
+		result.Domains = append(result.Domains, dr)
+		if dr.Result != nil {
+			result.Accepted = append(result.Accepted, dr.Result.Accepted...)
+			result.Rejected = append(result.Rejected, dr.Result.Rejected...)
+		}
+
+		if dr.Err == nil || isPartialDelivery(dr.Err) {
+			delivered = true
+		}
+		if dr.Err != nil {
+			failed = append(failed, dr)
+		}
+	}
+
+	switch {
+	case len(failed) == 0:
+		return result, nil
+	case !delivered:
+		return result, failed[0].Err
+	default:
+		return result, &MXDeliveryError{Failed: failed}
+	}
+}
+
+// sendDomain delivers msg to the MX hosts of domain in order of preference,
+// stopping at the first host to accept it or return a permanent failure.
+func (t *mxTransport) sendDomain(ctx context.Context, domain string, msg Message) DomainResult {
+	dr := DomainResult{Domain: domain}
+
+	hosts, err := t.lookupHosts(ctx, domain)
+	if err != nil {
+		dr.Err = err
+		return dr
+	}
+
+	for _, host := range hosts {
+		dr.Host = host
+		dr.Result, dr.Err = t.sendHost(ctx, host, msg)
+		if !tryNextHost(ctx, dr.Err) {
+			break
+		}
+	}
+
+	return dr
+}
+
+// sendHost connects to host and sends msg.
+func (t *mxTransport) sendHost(ctx context.Context, host string, msg Message) (*SendResult, error) {
+	dialCtx, cancel := t.config.dialContext(ctx)
+	conn, err := t.config.dial(dialCtx, net.JoinHostPort(host, t.port))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return smtpExchange(ctx, msg, conn, host, true, nil, t.config)
+}
+
+// lookupHosts returns the MX hosts for domain in order of preference, or the
+// domain itself if it has no MX records but has address records (RFC 5321,
+// section 5.1).
+//
+// errNoSuchDomain is returned if the domain has neither, as the resolver
+// reports a domain without MX records and a domain that does not exist alike.
+func (t *mxTransport) lookupHosts(ctx context.Context, domain string) ([]string, error) {
+	if domain == "" {
+		return nil, errors.New("mailyak: invalid recipient address")
+	}
+
+	// Internationalized domains are looked up by their ASCII form.
+	domain, err := domainToASCII(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := t.resolver.LookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		if _, err := t.resolver.LookupHost(ctx, domain); err != nil {
+			if isNotFound(err) {
+				return nil, errNoSuchDomain
+			}
+			return nil, err
+		}
+		return []string{domain}, nil
+	}
+
+	if len(records) == 1 && (records[0].Host == "." || records[0].Host == "") {
+		return nil, errNullMX
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Pref < records[j].Pref
+	})
+
+	hosts := make([]string, 0, len(records))
+	for _, r := range records {
+		hosts = append(hosts, strings.TrimSuffix(r.Host, "."))
+	}
+	return hosts, nil
+}
+
+// isNotFound returns true if err is a DNS error reporting the name has no
+// records of the requested type, or does not exist.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// tryNextHost returns true if a failure to deliver to one host (such as a MX
+// host, or failover endpoint) should be followed by an attempt to deliver to
+// the next.
+//
+// Connection failures and temporary errors are retried on the next host, but
+// permanent failures and partial deliveries are not.
+func tryNextHost(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	if isPartialDelivery(err) {
+		return false
+	}
+
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+
+	return true
+}
+
+// domainGroup is a set of recipient addresses at the same domain.
+type domainGroup struct {
+	domain string
+	addrs  []string
+}
+
+// groupByDomain groups addrs by their (case insensitive) domain, in the order
+// each domain first appears.
+//
+// Addresses without a domain are grouped under the empty domain.
+func groupByDomain(addrs []string) []domainGroup {
+	var groups []domainGroup
+	index := map[string]int{}
+
+	for _, addr := range addrs {
+		var domain string
+		if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+			domain = strings.ToLower(addr[i+1:])
+		}
+
+		i, ok := index[domain]
+		if !ok {
+			i = len(groups)
+			index[domain] = i
+			groups = append(groups, domainGroup{domain: domain})
+		}
+		groups[i].addrs = append(groups[i].addrs, addr)
+	}
+
+	return groups
+}
+
+// mxMessage is a Message with pre-built MIME content, sent to a subset of the
+// recipients of source.
+type mxMessage struct {
+	envelope Envelope
+	mime     []byte
+	source   Message
+}
+
+func (m *mxMessage) Envelope() Envelope {
+	return m.envelope
+}
+
+func (m *mxMessage) WriteMIME(w io.Writer) error {
+	_, err := w.Write(m.mime)
+	return err
+}
+
+func (m *mxMessage) requiresSMTPUTF8() bool {
+	return requiresSMTPUTF8(m.source)
+}
+
+func (m *mxMessage) getDSN() *DSN {
+	if d, ok := m.source.(dsnMessage); ok {
+		return d.getDSN()
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// stubResolver returns MX records from a map, or a not found error for
+// domains without records. Domains in the map have an address record, while
+// unknown domains do not exist.
+type stubResolver map[string][]*net.MX
+
+func (r stubResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	records := r[name]
+	if len(records) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if _, ok := r[host]; !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []string{"192.0.2.1"}, nil
+}
+
+// TestMXDelivery ensures recipients are grouped by domain and delivered to the
+// MX hosts of each domain in order of preference.
+func TestMXDelivery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		resolver stubResolver
+		to       []string
+
+		// hosts maps the reachable MX hosts to the rules scripting their test
+		// server.
+		hosts map[string][]smtptest.Rule
+
+		wantDomains  map[string]string // domain -> delivering host
+		wantReceived map[string]int    // host -> transactions
+		wantErr      interface{}
+	}{
+		{
+			name: "preference order",
+			resolver: stubResolver{
+				"example.org": {
+					{Host: "mx3.example.org.", Pref: 30},
+					{Host: "mx1.example.org.", Pref: 10},
+					{Host: "mx2.example.org.", Pref: 20},
+				},
+			},
+			to: []string{"a@example.org", "b@EXAMPLE.org"},
+			hosts: map[string][]smtptest.Rule{
+				// mx1 is unreachable, and mx2 is temporarily unavailable.
+				"mx2.example.org": {{Command: "CONNECT", Code: 421, Message: "4.3.2 Busy"}},
+				"mx3.example.org": nil,
+			},
+			wantDomains:  map[string]string{"example.org": "mx3.example.org"},
+			wantReceived: map[string]int{"mx3.example.org": 1},
+		},
+		{
+			name: "a record fallback",
+			resolver: stubResolver{
+				"example.org": nil,
+			},
+			to: []string{"a@example.org"},
+			hosts: map[string][]smtptest.Rule{
+				"example.org": nil,
+			},
+			wantDomains:  map[string]string{"example.org": "example.org"},
+			wantReceived: map[string]int{"example.org": 1},
+		},
+		{
+			name: "no such domain",
+			to:   []string{"a@example.org"},
+			hosts: map[string][]smtptest.Rule{
+				"example.org": nil,
+			},
+			wantDomains:  map[string]string{"example.org": ""},
+			wantReceived: map[string]int{},
+			wantErr:      errNoSuchDomain,
+		},
+		{
+			name: "permanent failure",
+			resolver: stubResolver{
+				"example.org": {
+					{Host: "mx1.example.org.", Pref: 10},
+					{Host: "mx2.example.org.", Pref: 20},
+				},
+			},
+			to: []string{"a@example.org"},
+			hosts: map[string][]smtptest.Rule{
+				"mx1.example.org": {{Command: "RCPT", Code: 550, Message: "5.1.1 No such user"}},
+				"mx2.example.org": nil,
+			},
+			wantDomains:  map[string]string{"example.org": "mx1.example.org"},
+			wantReceived: map[string]int{},
+			wantErr:      &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+		},
+		{
+			name: "null mx",
+			resolver: stubResolver{
+				"example.org": {{Host: ".", Pref: 0}},
+			},
+			to:           []string{"a@example.org"},
+			wantDomains:  map[string]string{"example.org": ""},
+			wantReceived: map[string]int{},
+			wantErr:      errNullMX,
+		},
+		{
+			name: "multiple domains",
+			resolver: stubResolver{
+				"example.org": {{Host: "mx.example.org.", Pref: 10}},
+				"example.net": {{Host: ".", Pref: 0}},
+				"example.com": {{Host: "mx.example.com.", Pref: 10}},
+			},
+			to: []string{"a@example.org", "b@example.net", "c@example.com", "d@example.org"},
+			hosts: map[string][]smtptest.Rule{
+				"mx.example.org": nil,
+				"mx.example.com": nil,
+			},
+			wantDomains: map[string]string{
+				"example.org": "mx.example.org",
+				"example.net": "",
+				"example.com": "mx.example.com",
+			},
+			wantReceived: map[string]int{"mx.example.org": 1, "mx.example.com": 1},
+			wantErr: &MXDeliveryError{
+				Failed: []DomainResult{{Domain: "example.net", Err: errNullMX}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			servers := map[string]*smtptest.Server{}
+			for host, rules := range tt.hosts {
+				srv := smtptest.NewServer(smtptest.ModePlain)
+				defer srv.Close()
+				for _, r := range rules {
+					srv.AddRule(r)
+				}
+				servers[host+":25"] = srv
+			}
+
+			m := NewMX(tt.resolver)
+			m.DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				srv, ok := servers[addr]
+				if !ok {
+					return nil, fmt.Errorf("dial %s: connection refused", addr)
+				}
+				d := &net.Dialer{}
+				return d.DialContext(ctx, network, srv.Addr)
+			})
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To(tt.to...)
+			mail.Subject("MX")
+			mail.Plain().SetString("bananas")
+
+			result, err := m.Deliver(context.Background(), mail)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("got error %v, want nil", err)
+				}
+			case *MXDeliveryError:
+				var got *MXDeliveryError
+				if !errors.As(err, &got) {
+					t.Fatalf("got error %v, want %v", err, want)
+				}
+				if len(got.Failed) != len(want.Failed) || got.Failed[0].Domain != want.Failed[0].Domain || got.Failed[0].Err != want.Failed[0].Err {
+					t.Errorf("got %+v, want %+v", got.Failed, want.Failed)
+				}
+			default:
+				if !reflect.DeepEqual(err, want) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			}
+
+			gotDomains := map[string]string{}
+			for _, d := range result.Domains {
+				gotDomains[d.Domain] = d.Host
+			}
+			if !reflect.DeepEqual(gotDomains, tt.wantDomains) {
+				t.Errorf("got domains %v, want %v", gotDomains, tt.wantDomains)
+			}
+
+			gotReceived := map[string]int{}
+			for addr, srv := range servers {
+				if n := len(srv.Transactions()); n > 0 {
+					host, _, _ := net.SplitHostPort(addr)
+					gotReceived[host] = n
+				}
+			}
+			if !reflect.DeepEqual(gotReceived, tt.wantReceived) {
+				t.Errorf("got received %v, want %v", gotReceived, tt.wantReceived)
+			}
+		})
+	}
+}
+
+// TestGroupByDomain ensures recipients are grouped by case insensitive domain,
+// preserving the order domains first appear.
+func TestGroupByDomain(t *testing.T) {
+	t.Parallel()
+
+	got := groupByDomain([]string{"a@example.org", "b@Example.NET", "nodomain", "c@EXAMPLE.org", "d@example.net"})
+	want := []domainGroup{
+		{domain: "example.org", addrs: []string{"a@example.org", "c@EXAMPLE.org"}},
+		{domain: "example.net", addrs: []string{"b@Example.NET", "d@example.net"}},
+		{domain: "", addrs: []string{"nodomain"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -11,6 +11,8 @@
 //
 // The raw MIME content can be retrieved using MimeBuf(), typically used with an
 // API service such as Amazon SES that does not require using an SMTP interface.
+// Alternatively, emails can be delivered by any implementation of the Transport
+// interface using NewWithTransport().
 //
 // MailYak supports both plain-text SMTP (which is automatically upgraded to a
 // secure connection with STARTTLS if supported by the SMTP server) and explicit
@@ -0,0 +1,119 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/xenking/mailyak/v3/internal/smtpclient"
+)
+
+// pipeline sends the MAIL FROM command and a RCPT TO command for each
+// recipient of envelope in a single write (RFC 2920), reading the replies once
+// they have all been sent, and returning the result as session.send does.
+//
+// If data is true, the DATA command is included in the batch, and a writer for
+// the message content is returned if the server accepts it.
+//
+// Every reply is read, even once a command has failed, keeping the session
+// usable for the next transaction. If a reply cannot be read the session is
+// marked as broken.
+func (s *session) pipeline(ctx context.Context, envelope Envelope, mailParams []string, rcptParams func(addr string) []string, data bool) (*SendResult, io.WriteCloser, error) {
+	c := s.client
+	timeout := s.config.commandTimeout
+
+	cmds := make([]string, 0, len(envelope.To)+2)
+	cmd, err := smtpclient.CommandLine("MAIL FROM:", envelope.From, mailParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmds = append(cmds, cmd)
+	for _, to := range envelope.To {
+		if cmd, err = smtpclient.CommandLine("RCPT TO:", to, rcptParams(to)); err != nil {
+			return nil, nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	if data {
+		cmds = append(cmds, "DATA")
+	}
+
+	err = s.do(ctx, timeout, func() error {
+		return c.Send(cmds...)
+	})
+	if err != nil {
+		s.broken = true
+		return nil, nil, err
+	}
+
+	// readReply reads the next reply, marking the session as broken if it
+	// cannot be read.
+	readReply := func(expectCode int) (smtpclient.Reply, error) {
+		var r smtpclient.Reply
+		err := s.do(ctx, timeout, func() error {
+			var err error
+			r, err = c.ReadReply(expectCode)
+			return err
+		})
+
+		var smtpErr *SMTPError
+		if err != nil && !errors.As(err, &smtpErr) {
+			s.broken = true
+		}
+		return r, err
+	}
+
+	_, mailErr := readReply(250)
+	if s.broken {
+		return nil, nil, mailErr
+	}
+
+	result := &SendResult{}
+	for _, to := range envelope.To {
+		r, err := readReply(25)
+		if s.broken {
+			return result, nil, err
+		}
+
+		status := newRecipientStatus(to, r.Code, r.Text())
+		if err != nil {
+			result.Rejected = append(result.Rejected, status)
+			continue
+		}
+		result.Accepted = append(result.Accepted, status)
+	}
+
+	var dataErr error
+	if data {
+		if _, dataErr = readReply(354); s.broken {
+			return result, nil, dataErr
+		}
+	}
+
+	// The server is waiting for the message content if DATA was accepted,
+	// but it must not be sent if the transaction failed.
+	abort := func(err error) (*SendResult, io.WriteCloser, error) {
+		if data && dataErr == nil {
+			s.broken = true
+		}
+		return result, nil, err
+	}
+
+	switch {
+	case mailErr != nil:
+		result = nil
+		return abort(mailErr)
+	case len(result.Accepted) == 0 && len(result.Rejected) > 0:
+		// Every recipient was rejected - there is nobody to send the email
+		// to.
+		return abort(result.Rejected[0].Err())
+	case len(result.Rejected) > 0 && !s.config.allowPartial:
+		return abort(result.Rejected[0].Err())
+	case dataErr != nil:
+		return result, nil, dataErr
+	case !data:
+		return result, nil, nil
+	}
+
+	return result, c.DotWriter(), nil
+}
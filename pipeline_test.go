@@ -0,0 +1,238 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// recordingDialer records each write made to the connections it opens.
+type recordingDialer struct {
+	mu     sync.Mutex
+	dials  int
+	writes []string
+}
+
+func (d *recordingDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+
+	return &recordingConn{Conn: conn, d: d}, nil
+}
+
+// batches returns the writes containing a RCPT TO command.
+func (d *recordingDialer) batches() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []string
+	for _, w := range d.writes {
+		if strings.Contains(w, "RCPT TO:") {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+type recordingConn struct {
+	net.Conn
+	d *recordingDialer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.d.mu.Lock()
+	c.d.writes = append(c.d.writes, string(p))
+	c.d.mu.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+// TestPipelining ensures the MAIL FROM and RCPT TO commands (and DATA, when
+// partial delivery is allowed) are sent in a single batch when the server
+// supports PIPELINING, reporting the reply to each recipient and leaving the
+// session usable after a failure.
+func TestPipelining(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		extensions   []string
+		allowPartial bool
+		rules        []smtptest.Rule
+
+		wantBatches  int
+		wantData     bool
+		wantCode     int
+		wantPartial  bool
+		wantAccepted []string
+		wantSent     bool
+	}{
+		{
+			name:         "not supported",
+			wantBatches:  3,
+			wantAccepted: []string{"a@example.org", "b@example.org", "c@example.org"},
+			wantSent:     true,
+		},
+		{
+			name:         "accepted",
+			extensions:   []string{"PIPELINING"},
+			wantBatches:  1,
+			wantAccepted: []string{"a@example.org", "b@example.org", "c@example.org"},
+			wantSent:     true,
+		},
+		{
+			name:         "accepted with data",
+			extensions:   []string{"PIPELINING"},
+			allowPartial: true,
+			wantBatches:  1,
+			wantData:     true,
+			wantAccepted: []string{"a@example.org", "b@example.org", "c@example.org"},
+			wantSent:     true,
+		},
+		{
+			name:       "recipient rejected",
+			extensions: []string{"PIPELINING"},
+			rules: []smtptest.Rule{
+				{Command: "RCPT", Arg: "b@example.org", Code: 550, Message: "5.1.1 No such user"},
+			},
+			wantBatches:  1,
+			wantCode:     550,
+			wantAccepted: []string{"a@example.org", "c@example.org"},
+		},
+		{
+			name:         "recipient rejected with partial delivery",
+			extensions:   []string{"PIPELINING"},
+			allowPartial: true,
+			rules: []smtptest.Rule{
+				{Command: "RCPT", Arg: "b@example.org", Code: 550, Message: "5.1.1 No such user"},
+			},
+			wantBatches:  1,
+			wantData:     true,
+			wantPartial:  true,
+			wantAccepted: []string{"a@example.org", "c@example.org"},
+			wantSent:     true,
+		},
+		{
+			name:         "all recipients rejected",
+			extensions:   []string{"PIPELINING"},
+			allowPartial: true,
+			rules: []smtptest.Rule{
+				{Command: "RCPT", Code: 450, Message: "4.2.1 Mailbox busy", Times: 3},
+			},
+			wantBatches: 1,
+			wantData:    true,
+			wantCode:    450,
+		},
+		{
+			name:         "sender rejected",
+			extensions:   []string{"PIPELINING"},
+			allowPartial: true,
+			rules: []smtptest.Rule{
+				{Command: "MAIL", Code: 553, Message: "5.1.8 Bad sender", Times: 1},
+			},
+			wantBatches: 1,
+			wantData:    true,
+			wantCode:    553,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+			for _, r := range tt.rules {
+				srv.AddRule(r)
+			}
+
+			d := &recordingDialer{}
+			m := New(srv.Addr, nil)
+			defer m.Close()
+			m.DialFunc(d.dial)
+			m.PoolSize(1)
+			m.AllowPartialDelivery(tt.allowPartial)
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("a@example.org", "b@example.org", "c@example.org")
+			mail.Plain().SetString("bananas")
+
+			result, err := m.Deliver(context.Background(), mail)
+
+			var smtpErr *SMTPError
+			var partialErr *PartialDeliveryError
+			switch {
+			case tt.wantCode != 0:
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("got error %v, want code %d", err, tt.wantCode)
+				}
+			case tt.wantPartial:
+				if !errors.As(err, &partialErr) || len(partialErr.Rejected) != 1 || partialErr.Rejected[0].Address != "b@example.org" {
+					t.Fatalf("got error %v, want b@example.org rejected", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			batches := d.batches()
+			if len(batches) != tt.wantBatches {
+				t.Errorf("got RCPT TO commands in %d writes, want %d: %q", len(batches), tt.wantBatches, batches)
+			}
+			if len(batches) > 0 {
+				if got := strings.HasSuffix(batches[0], "DATA\r\n"); got != tt.wantData {
+					t.Errorf("got DATA in batch %v, want %v: %q", got, tt.wantData, batches[0])
+				}
+			}
+
+			var accepted []string
+			if result != nil {
+				for _, r := range result.Accepted {
+					accepted = append(accepted, r.Address)
+				}
+			}
+			if !reflect.DeepEqual(accepted, tt.wantAccepted) {
+				t.Errorf("got accepted %q, want %q", accepted, tt.wantAccepted)
+			}
+
+			txs := srv.Transactions()
+			if !tt.wantSent {
+				if len(txs) != 0 {
+					t.Fatalf("got %d transactions, want 0", len(txs))
+				}
+			} else if len(txs) != 1 || !reflect.DeepEqual(txs[0].To, tt.wantAccepted) {
+				t.Fatalf("got transactions %+v, want 1 to %q", txs, tt.wantAccepted)
+			}
+
+			// The pooled session remains usable for the next email.
+			next := m.NewMail()
+			next.From("from@example.org")
+			next.To("d@example.org")
+			next.Plain().SetString("apples")
+			if err := m.Send(next); err != nil {
+				t.Fatalf("sending next email: %v", err)
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.dials != 1 {
+				t.Errorf("got %d connections, want 1", d.dials)
+			}
+		})
+	}
+}
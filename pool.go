@@ -0,0 +1,257 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned when sending through a MailYak instance with
+// session pooling enabled after Close has been called.
+var ErrPoolClosed = errors.New("mailyak: session pool is closed")
+
+// sessionDialer is implemented by senders that can establish new SMTP
+// sessions, allowing them to be pooled and reused across sends.
+type sessionDialer interface {
+	// sessionAuth should return the smtp.Auth to authenticate with when
+	// sending msg.
+	sessionAuth(msg Message) smtp.Auth
+
+	// dialSession should connect to the SMTP server, returning a session
+	// authenticated with auth if non-nil.
+	dialSession(ctx context.Context, auth smtp.Auth) (*session, error)
+}
+
+// sessionPool maintains a bounded set of established SMTP sessions, reusing
+// them for subsequent sends instead of performing a new connection, TLS
+// handshake and authentication for each email.
+//
+// Sessions are reset with RSET after each mail transaction, and checked with a
+// NOOP before being reused.
+type sessionPool struct {
+	dialer sessionDialer
+
+	mu sync.Mutex
+
+	// idle holds the sessions available for reuse, with the most recently
+	// used at the end.
+	idle []*session
+
+	// slots bounds the number of concurrently open sessions - nil when
+	// pooling is disabled.
+	slots chan struct{}
+
+	// idleTimeout is the maximum duration a session may sit unused in the
+	// pool before being closed, or 0 for no limit.
+	idleTimeout time.Duration
+
+	// maxMessages is the maximum number of mail transactions performed over a
+	// single session before it is closed, or 0 for no limit.
+	maxMessages int
+
+	closed bool
+}
+
+func newSessionPool(dialer sessionDialer) *sessionPool {
+	return &sessionPool{
+		dialer: dialer,
+	}
+}
+
+// enabled returns true if the pool has been configured with a non-zero size.
+func (p *sessionPool) enabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.slots != nil
+}
+
+// setSize bounds the pool to n open sessions, disabling pooling when n <= 0.
+func (p *sessionPool) setSize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		p.slots = nil
+		return
+	}
+	p.slots = make(chan struct{}, n)
+}
+
+// setIdleTimeout sets the maximum idle duration of a pooled session.
+func (p *sessionPool) setIdleTimeout(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.idleTimeout = d
+}
+
+// setMaxMessages sets the maximum number of mail transactions per session.
+func (p *sessionPool) setMaxMessages(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxMessages = n
+}
+
+// Send sends msg using a pooled session, dialing a new session if none are
+// available.
+//
+// If the pool is at capacity, Send blocks until a session is released or ctx
+// is cancelled.
+func (p *sessionPool) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	p.mu.Lock()
+	slots, closed := p.slots, p.closed
+	p.mu.Unlock()
+
+	if closed {
+		return nil, ErrPoolClosed
+	}
+
+	if slots == nil {
+		// Pooling is disabled, use a single-use session.
+		s, err := p.dialer.dialSession(ctx, p.dialer.sessionAuth(msg))
+		if err != nil {
+			return nil, err
+		}
+		defer s.quit()
+
+		return s.send(ctx, msg)
+	}
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-slots }()
+
+	s, err := p.get(ctx, p.dialer.sessionAuth(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.send(ctx, msg)
+	p.put(s)
+
+	return result, err
+}
+
+// get returns a healthy idle session authenticated with auth, or dials a new
+// session if none are available.
+//
+// Idle sessions that have expired, were authenticated with different
+// credentials, or do not respond to a NOOP are closed.
+func (p *sessionPool) get(ctx context.Context, auth smtp.Auth) (*session, error) {
+	for {
+		s := p.popIdle()
+		if s == nil {
+			return p.dialer.dialSession(ctx, auth)
+		}
+
+		if !sameAuth(s.auth, auth) {
+			s.quit()
+			continue
+		}
+
+		if err := s.noop(ctx); err != nil {
+			_ = s.conn.Close()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			continue
+		}
+
+		return s, nil
+	}
+}
+
+// popIdle removes and returns the most recently used idle session, closing any
+// that have exceeded the idle timeout. It returns nil if no sessions are idle.
+func (p *sessionPool) popIdle() *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		s := p.idle[len(p.idle)-1]
+		p.idle[len(p.idle)-1] = nil
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if p.idleTimeout > 0 && time.Since(s.lastUsed) > p.idleTimeout {
+			// The remote server has likely closed the connection already,
+			// so don't wait for a QUIT response.
+			_ = s.conn.Close()
+			continue
+		}
+
+		return s
+	}
+
+	return nil
+}
+
+// put returns s to the pool, resetting the session state with RSET so it may
+// be used for another transaction.
+//
+// Sessions that have reached the configured message limit, were broken by a
+// context cancellation or network error, or fail to reset, are closed instead.
+func (p *sessionPool) put(s *session) {
+	p.mu.Lock()
+	maxMessages, closed := p.maxMessages, p.closed
+	p.mu.Unlock()
+
+	if closed || s.broken || (maxMessages > 0 && s.messages >= maxMessages) {
+		s.quit()
+		return
+	}
+
+	if err := s.reset(context.Background()); err != nil {
+		_ = s.conn.Close()
+		return
+	}
+
+	s.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		s.quit()
+		return
+	}
+	p.idle = append(p.idle, s)
+	p.mu.Unlock()
+}
+
+// Close closes all idle sessions, and causes any in-use sessions to be closed
+// once they are released. Sends after Close return ErrPoolClosed.
+func (p *sessionPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, s := range idle {
+		s.quit()
+	}
+
+	return nil
+}
+
+// sameAuth returns true if a and b are the same smtp.Auth instance, and
+// therefore a session authenticated with a can be used to send with b.
+func sameAuth(a, b smtp.Auth) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
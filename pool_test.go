@@ -0,0 +1,257 @@
+package mailyak
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestSessionPool ensures pooled sessions are reused with RSET/NOOP between
+// mail transactions, and replaced once the configured limits are hit.
+func TestSessionPool(t *testing.T) {
+	t.Parallel()
+
+	const testTimeout = 15 * time.Second
+
+	mail := &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+	}
+
+	greet := func(c *connAsserts) {
+		c.Respond("220 localhost ESMTP bananas\r\n")
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250 localhost Hola\r\n")
+	}
+
+	transaction := func(c *connAsserts) {
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+	}
+
+	tests := []struct {
+		name        string
+		maxMessages int
+		sends       int
+
+		// Each func handles a single accepted connection, in order.
+		conns []func(c *connAsserts)
+	}{
+		{
+			name:  "reuse",
+			sends: 3,
+			conns: []func(c *connAsserts){
+				func(c *connAsserts) {
+					greet(c)
+					for i := 0; i < 3; i++ {
+						if i > 0 {
+							c.Expect("NOOP\r\n")
+							c.Respond("250 OK\r\n")
+						}
+						transaction(c)
+						c.Expect("RSET\r\n")
+						c.Respond("250 OK\r\n")
+					}
+					c.Expect("QUIT\r\n")
+					c.Respond("221 Adios\r\n")
+				},
+			},
+		},
+		{
+			name:        "max messages",
+			maxMessages: 2,
+			sends:       3,
+			conns: []func(c *connAsserts){
+				func(c *connAsserts) {
+					greet(c)
+					transaction(c)
+					c.Expect("RSET\r\n")
+					c.Respond("250 OK\r\n")
+					c.Expect("NOOP\r\n")
+					c.Respond("250 OK\r\n")
+					transaction(c)
+					c.Expect("QUIT\r\n")
+					c.Respond("221 Adios\r\n")
+				},
+				func(c *connAsserts) {
+					greet(c)
+					transaction(c)
+					c.Expect("RSET\r\n")
+					c.Respond("250 OK\r\n")
+					c.Expect("QUIT\r\n")
+					c.Respond("221 Adios\r\n")
+				},
+			},
+		},
+		{
+			name:  "dead connection",
+			sends: 2,
+			conns: []func(c *connAsserts){
+				func(c *connAsserts) {
+					greet(c)
+					transaction(c)
+					c.Expect("RSET\r\n")
+					c.Respond("250 OK\r\n")
+					c.Expect("NOOP\r\n")
+					c.Respond("421 Going away\r\n")
+				},
+				func(c *connAsserts) {
+					greet(c)
+					transaction(c)
+					c.Expect("RSET\r\n")
+					c.Respond("250 OK\r\n")
+					c.Expect("QUIT\r\n")
+					c.Respond("221 Adios\r\n")
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			socket, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to bind to localhost: %v", err)
+			}
+			defer socket.Close()
+
+			handlerDone := make(chan struct{})
+			go func() {
+				defer close(handlerDone)
+				for _, fn := range tt.conns {
+					conn, err := socket.Accept()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					fn(newConnAsserts(conn, t))
+					conn.Close()
+				}
+			}()
+
+			m := New(socket.Addr().String(), nil)
+			m.PoolSize(1)
+			m.MaxMessagesPerConn(tt.maxMessages)
+
+			sendErr := make(chan error)
+			go func() {
+				for i := 0; i < tt.sends; i++ {
+					if _, err := m.pool.Send(ctx, mail); err != nil {
+						sendErr <- err
+						return
+					}
+				}
+				sendErr <- m.Close()
+			}()
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for Send() to return")
+			case err := <-sendErr:
+				if err != nil {
+					t.Errorf("got %v, want nil", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for SMTP conversation to complete")
+			case <-handlerDone:
+			}
+		})
+	}
+}
+
+// TestSessionPoolClosed ensures sending after Close returns ErrPoolClosed.
+func TestSessionPoolClosed(t *testing.T) {
+	t.Parallel()
+
+	m := New("127.0.0.1:1", nil)
+	m.PoolSize(2)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.pool.Send(context.Background(), &mockMail{}); err != ErrPoolClosed {
+		t.Errorf("got %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+// TestSessionPoolBroken ensures a session interrupted part way through the
+// message content is closed rather than reset and reused.
+func TestSessionPoolBroken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		rule smtptest.Rule
+	}{
+		{
+			name: "stalled",
+			rule: smtptest.Rule{Command: "MESSAGE", Delay: time.Second, Times: 1},
+		},
+		{
+			name: "dropped",
+			rule: smtptest.Rule{Command: "MESSAGE", Disconnect: true, Times: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+			srv.AddRule(tt.rule)
+
+			m := New(srv.Addr, nil)
+			defer m.Close()
+			m.PoolSize(1)
+			m.DataTimeout(100 * time.Millisecond)
+
+			send := func() error {
+				mail := m.NewMail()
+				mail.From("from@example.org")
+				mail.To("to@example.org")
+				mail.Plain().SetString("bananas")
+				return m.Send(mail)
+			}
+
+			if err := send(); err == nil {
+				t.Fatal("got nil error, want error")
+			}
+			if err := send(); err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+
+			// Only the session used for the second email is reset.
+			resets := 0
+			for _, cmd := range srv.Commands() {
+				if strings.HasPrefix(cmd, "RSET") {
+					resets++
+				}
+			}
+			if resets != 1 {
+				t.Errorf("got %d RSET commands, want 1", resets)
+			}
+		})
+	}
+}
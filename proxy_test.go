@@ -0,0 +1,338 @@
+package mailyak
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// socks5Server is a minimal SOCKS5 proxy for tests, supporting the CONNECT
+// command with optional username/password authentication.
+type socks5Server struct {
+	t        *testing.T
+	listener net.Listener
+	username string
+	password string
+
+	// targets receives the address requested by each client.
+	targets chan string
+}
+
+func newSOCKS5Server(t *testing.T, username, password string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+
+	s := &socks5Server{
+		t:        t,
+		listener: l,
+		username: username,
+		password: password,
+		targets:  make(chan string, 10),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *socks5Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *socks5Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			s.t.Errorf("proxy read: %v", err)
+			return nil
+		}
+		return b
+	}
+
+	hdr := read(2)
+	if hdr == nil {
+		return
+	}
+	_ = read(int(hdr[1]))
+
+	if s.username == "" {
+		_, _ = conn.Write([]byte{0x05, 0x00})
+	} else {
+		_, _ = conn.Write([]byte{0x05, 0x02})
+		ver := read(2)
+		user := string(read(int(ver[1])))
+		pass := string(read(int(read(1)[0])))
+		if user != s.username || pass != s.password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	req := read(4)
+	var host string
+	switch req[3] {
+	case 0x01:
+		host = net.IP(read(4)).String()
+	case 0x03:
+		host = string(read(int(read(1)[0])))
+	case 0x04:
+		host = net.IP(read(16)).String()
+	}
+	port := read(2)
+	target := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	s.targets <- target
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+// TestSOCKS5 ensures emails are sent through a SOCKS5 proxy, with and without
+// authentication.
+func TestSOCKS5(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{
+			name: "no auth",
+		},
+		{
+			name:     "auth",
+			username: "user",
+			password: "pass",
+		},
+		{
+			name:     "wrong password",
+			username: "user",
+			password: "bananas",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+
+			proxy := newSOCKS5Server(t, tt.username, "pass")
+			defer proxy.listener.Close()
+
+			m := New(srv.Addr, nil)
+			m.DialFunc(SOCKS5(proxy.listener.Addr().String(), tt.username, tt.password, nil))
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			err := m.SendContext(context.Background(), mail)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := <-proxy.targets; got != srv.Addr {
+				t.Errorf("proxy connected to %q, want %q", got, srv.Addr)
+			}
+			if got := len(srv.Transactions()); got != 1 {
+				t.Errorf("got %d transactions, want 1", got)
+			}
+		})
+	}
+}
+
+// clearProxyEnv unsets the proxy environment variables, restoring them once
+// the test completes.
+func clearProxyEnv(t *testing.T) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		name := name
+		old, ok := os.LookupEnv(name)
+		_ = os.Unsetenv(name)
+		t.Cleanup(func() {
+			if ok {
+				_ = os.Setenv(name, old)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+// recordDials returns a DialFunc recording the addresses it dials to
+// dialled, connecting with a zero net.Dialer.
+func recordDials(dialled chan<- string) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialled <- addr
+		d := &net.Dialer{}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// TestProxyFromEnvironment ensures the proxy is configured from ALL_PROXY,
+// hosts matching NO_PROXY are connected to directly, and both connect with
+// the forward DialFunc.
+//
+// This test modifies the environment, so does not run in parallel.
+func TestProxyFromEnvironment(t *testing.T) {
+	clearProxyEnv(t)
+
+	dial, err := ProxyFromEnvironment(nil)
+	if dial != nil || err != nil {
+		t.Fatalf("got (%v, %v) with no proxy configured, want nil", dial, err)
+	}
+
+	os.Setenv("ALL_PROXY", "http://proxy.example.org:3128")
+	if _, err := ProxyFromEnvironment(nil); err == nil {
+		t.Error("expected error for unsupported proxy scheme")
+	}
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+
+	proxy := newSOCKS5Server(t, "user", "pass")
+	defer proxy.listener.Close()
+
+	os.Setenv("ALL_PROXY", "socks5://user:pass@"+proxy.listener.Addr().String())
+	os.Setenv("NO_PROXY", "localhost,.example.org")
+
+	dialled := make(chan string, 10)
+	dial, err = ProxyFromEnvironment(recordDials(dialled))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections to 127.0.0.1 go through the proxy.
+	conn, err := dial(context.Background(), "tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := <-proxy.targets; got != srv.Addr {
+		t.Errorf("proxy connected to %q, want %q", got, srv.Addr)
+	}
+	if got := <-dialled; got != proxy.listener.Addr().String() {
+		t.Errorf("dialled %q, want proxy %q", got, proxy.listener.Addr())
+	}
+
+	// Connections to localhost do not.
+	_, port, _ := net.SplitHostPort(srv.Addr)
+	direct := net.JoinHostPort("localhost", port)
+	conn, err = dial(context.Background(), "tcp", direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case got := <-proxy.targets:
+		t.Errorf("proxy connected to %q for NO_PROXY host", got)
+	default:
+	}
+	if got := <-dialled; got != direct {
+		t.Errorf("dialled %q, want %q", got, direct)
+	}
+}
+
+// TestProxyFromEnvironmentResolve ensures hostnames are resolved locally for
+// the socks5 scheme, and by the proxy for the socks5h scheme.
+//
+// This test modifies the environment, so does not run in parallel.
+func TestProxyFromEnvironmentResolve(t *testing.T) {
+	clearProxyEnv(t)
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Addr)
+	target := net.JoinHostPort("localhost", port)
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		proxy := newSOCKS5Server(t, "", "")
+		defer proxy.listener.Close()
+
+		os.Setenv("ALL_PROXY", scheme+"://"+proxy.listener.Addr().String())
+		dial, err := ProxyFromEnvironment(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The connection is refused by the proxy if localhost resolves to
+		// an address the server is not listening on, which is not under
+		// test.
+		if conn, err := dial(context.Background(), "tcp", target); err == nil {
+			conn.Close()
+		}
+
+		got := <-proxy.targets
+		host, _, _ := net.SplitHostPort(got)
+		if scheme == "socks5h" {
+			if got != target {
+				t.Errorf("%s: proxy connected to %q, want %q", scheme, got, target)
+			}
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			t.Errorf("%s: proxy connected to %q, want a loopback IP address", scheme, got)
+		}
+	}
+}
+
+// TestMatchNoProxy ensures hosts are matched against NO_PROXY entries.
+func TestMatchNoProxy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		noProxy string
+		host    string
+		want    bool
+	}{
+		{"", "example.org", false},
+		{"*", "example.org", true},
+		{"example.org", "example.org", true},
+		{"example.org", "smtp.example.org", true},
+		{".example.org", "smtp.example.org", true},
+		{".example.org", "example.org", true},
+		{"example.org", "badexample.org", false},
+		{"localhost, 10.0.0.1", "10.0.0.1", true},
+		{"localhost, 10.0.0.1", "10.0.0.2", false},
+		{"EXAMPLE.org", "smtp.Example.ORG", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.noProxy+"/"+tt.host, func(t *testing.T) {
+			t.Parallel()
+
+			if got := matchNoProxy(tt.noProxy, tt.host); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,804 @@
+package mailyak
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultQueueExpiry is the time a queued email is retried for before it
+	// is bounced, unless set in QueueOptions.
+	defaultQueueExpiry = 5 * 24 * time.Hour
+
+	// defaultQueueInitialBackoff and defaultQueueMaxBackoff bound the delay
+	// between attempts, unless set in QueueOptions.Retry.
+	defaultQueueInitialBackoff = time.Minute
+	defaultQueueMaxBackoff     = time.Hour
+)
+
+// ErrQueueItemNotFound is returned when a queued email does not exist, or has
+// already been delivered.
+var ErrQueueItemNotFound = errors.New("mailyak: queue item not found")
+
+// ErrQueueClosed is returned when using a Queue after Close has been called.
+var ErrQueueClosed = errors.New("mailyak: queue is closed")
+
+// ErrQueueItemCorrupt is passed to the ErrorFunc when the metadata of a queued
+// email cannot be parsed. The email is set aside, with its metadata file
+// renamed to "<id>.bad", and is not delivered.
+var ErrQueueItemCorrupt = errors.New("mailyak: corrupt queue item")
+
+// ErrQueueExpired is passed to the BounceFunc when an email could not be
+// delivered before its expiry, along with the last delivery error.
+var ErrQueueExpired = errors.New("mailyak: queued email expired")
+
+// BounceFunc is called by a Queue when an email is permanently undeliverable,
+// with the error that caused it to be given up on.
+//
+// The queued email is removed once the BounceFunc returns.
+type BounceFunc func(item QueueItem, err error)
+
+// ErrorFunc is called by a Queue when the queued email with the given ID
+// cannot be processed, such as when its metadata is corrupt.
+type ErrorFunc func(id string, err error)
+
+// QueueOptions configures a Queue.
+type QueueOptions struct {
+	// Retry configures the delay between delivery attempts, and which errors
+	// are retried. MaxAttempts limits the number of attempts before the email
+	// is bounced, or 0 to retry until the email expires.
+	//
+	// If InitialBackoff is 0 it defaults to 1 minute, and if MaxBackoff is 0
+	// it defaults to 1 hour.
+	Retry RetryPolicy
+
+	// Expiry is the time after an email is enqueued (or its send at time)
+	// that it is retried for before being bounced. Defaults to 5 days.
+	Expiry time.Duration
+
+	// Bounce is called when an email is permanently undeliverable, either
+	// due to a permanent error, a partial delivery, or expiry. Optional.
+	Bounce BounceFunc
+
+	// Error is called when a queued email is set aside because it cannot be
+	// read, allowing the other emails to be delivered. Optional.
+	Error ErrorFunc
+}
+
+// QueueItem describes an email held in a Queue.
+type QueueItem struct {
+	// ID uniquely identifies the queued email.
+	ID string `json:"id"`
+
+	// Envelope is the sender and recipients the email is delivered to.
+	Envelope Envelope `json:"envelope"`
+
+	// Created is the time the email was enqueued.
+	Created time.Time `json:"created"`
+
+	// SendAt is the earliest time the email is delivered.
+	SendAt time.Time `json:"send_at"`
+
+	// NextAttempt is the time of the next delivery attempt.
+	NextAttempt time.Time `json:"next_attempt"`
+
+	// Attempts is the number of failed delivery attempts.
+	Attempts int `json:"attempts"`
+
+	// LastError describes the error from the last failed attempt, or is
+	// empty if no attempt has been made.
+	LastError string `json:"last_error,omitempty"`
+
+	// VERP is the VERP template the email is sent with (see Mail.VERP), or
+	// empty if not set.
+	VERP string `json:"verp,omitempty"`
+
+	// DSN is the delivery status notifications requested for the email (see
+	// Mail.DSN), or nil if not set.
+	DSN *DSN `json:"dsn,omitempty"`
+
+	// RoutingTag is the routing tag of the email (see Mail.RoutingTag), or
+	// empty if not set.
+	RoutingTag string `json:"routing_tag,omitempty"`
+
+	// From is the From header address of the email, used to route it by
+	// domain (see Router.Domain), or empty if not known.
+	From string `json:"from,omitempty"`
+
+	// SMTPUTF8 is true if the email headers contain an address that can only
+	// be sent to a server supporting SMTPUTF8.
+	SMTPUTF8 bool `json:"smtputf8,omitempty"`
+}
+
+// Queue is a durable outbound queue, holding emails in a spool directory
+// until they are delivered by a Transport.
+//
+// Each email is stored as its built MIME content, envelope and sending options
+// (such as VERP and DSN), so it survives process restarts, and is delivered in
+// the background with retries. Emails that cannot be delivered are bounced to
+// the BounceFunc.
+//
+// The SMTP credentials carried by an email (those of the MailYak instance it
+// was created by) are held in memory only, and are not written to the spool
+// directory. Emails remaining in the queue when it is reopened are sent with
+// the credentials of the Transport.
+//
+// A Queue is safe for concurrent use, but the spool directory must only be
+// used by one Queue at a time.
+type Queue struct {
+	dir       string
+	transport Transport
+	retry     RetryPolicy
+	expiry    time.Duration
+	bounce    BounceFunc
+	onError   ErrorFunc
+
+	// mu serialises access to the spool directory.
+	mu       sync.Mutex
+	inflight string // ID of the item being delivered
+	closed   bool
+
+	// errs holds the errors to report to onError once q.mu is released.
+	errs []queueError
+
+	// auths holds the credentials of queued emails that carry their own, by
+	// ID.
+	auths map[string]smtp.Auth
+
+	// wake signals the delivery loop to list the spool again.
+	wake chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// OpenQueue opens (creating if necessary) the spool directory dir, and starts
+// delivering any emails queued in it using t.
+//
+//	q, err := mailyak.OpenQueue("/var/spool/myapp", my.Transport(), mailyak.QueueOptions{
+//		Bounce: func(item mailyak.QueueItem, err error) {
+//			log.Printf("bounced %s: %v", item.ID, err)
+//		},
+//	})
+//	...
+//	defer q.Close()
+//
+//	mail := my.NewMail()
+//	...
+//	id, err := q.Enqueue(mail)
+//
+// Retries are scheduled by the Queue, so t should not retry sends itself (such
+// as a MailYak instance with a RetryPolicy set).
+func OpenQueue(dir string, t Transport, opts QueueOptions) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	if opts.Retry.InitialBackoff == 0 {
+		opts.Retry.InitialBackoff = defaultQueueInitialBackoff
+	}
+	if opts.Retry.MaxBackoff == 0 {
+		opts.Retry.MaxBackoff = defaultQueueMaxBackoff
+	}
+	if opts.Expiry == 0 {
+		opts.Expiry = defaultQueueExpiry
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		dir:       dir,
+		transport: t,
+		retry:     opts.Retry,
+		expiry:    opts.Expiry,
+		bounce:    opts.Bounce,
+		onError:   opts.Error,
+		auths:     map[string]smtp.Auth{},
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	if err := q.cleanup(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go q.run()
+
+	return q, nil
+}
+
+// Enqueue adds msg to the queue for immediate delivery, returning its ID.
+//
+// The MIME content of msg is built and written to the spool directory before
+// Enqueue returns, so msg may be reused.
+func (q *Queue) Enqueue(msg Message) (string, error) {
+	return q.EnqueueAt(msg, time.Time{})
+}
+
+// EnqueueAt adds msg to the queue to be delivered at (or shortly after) the
+// given time, returning its ID. A zero time delivers the email immediately.
+func (q *Queue) EnqueueAt(msg Message, at time.Time) (string, error) {
+	id, err := newQueueID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if at.Before(now) {
+		at = now
+	}
+
+	item := QueueItem{
+		ID:          id,
+		Envelope:    msg.Envelope(),
+		Created:     now,
+		SendAt:      at,
+		NextAttempt: at,
+		RoutingTag:  RoutingTag(msg),
+		From:        headerFrom(msg),
+		SMTPUTF8:    requiresSMTPUTF8(msg),
+	}
+	if v, ok := msg.(verpMessage); ok {
+		item.VERP = v.getVERP()
+	}
+	if d, ok := msg.(dsnMessage); ok && d.getDSN() != nil {
+		dsn := *d.getDSN()
+		item.DSN = &dsn
+	}
+
+	// The MIME content is written first, and the item only becomes visible
+	// to the delivery loop once its metadata has been written.
+	if err := q.writeFile(id+".eml", msg.WriteMIME); err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		_ = os.Remove(q.path(id + ".eml"))
+		return "", ErrQueueClosed
+	}
+
+	if err := q.writeItem(item); err != nil {
+		_ = os.Remove(q.path(id + ".eml"))
+		return "", err
+	}
+	if auth := messageAuth(msg, nil); auth != nil {
+		q.auths[id] = auth
+	}
+
+	q.notify()
+	return id, nil
+}
+
+// List returns the emails in the queue, ordered by their next delivery
+// attempt.
+func (q *Queue) List() ([]QueueItem, error) {
+	q.mu.Lock()
+	items, err := q.list()
+	q.mu.Unlock()
+
+	q.reportErrors()
+	return items, err
+}
+
+// Get returns the queued email with the given id, or ErrQueueItemNotFound.
+func (q *Queue) Get(id string) (QueueItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.readItem(id)
+}
+
+// Retry schedules the queued email with the given id to be delivered
+// immediately, regardless of its send at time or retry backoff.
+func (q *Queue) Retry(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, err := q.readItem(id)
+	if err != nil {
+		return err
+	}
+
+	if q.inflight == id {
+		// The email is already being delivered.
+		return nil
+	}
+
+	item.NextAttempt = time.Now()
+	if err := q.writeItem(item); err != nil {
+		return err
+	}
+
+	q.notify()
+	return nil
+}
+
+// Delete removes the queued email with the given id without delivering it.
+//
+// If the email is being delivered when Delete is called, the delivery is not
+// interrupted, but the email is not retried if it fails.
+func (q *Queue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.readItem(id); err != nil {
+		return err
+	}
+
+	return q.remove(id)
+}
+
+// Close stops delivering emails, aborting any delivery in progress and waiting
+// for it to return. Queued emails remain in the spool directory, and are
+// delivered when it is next opened.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	q.cancel()
+	<-q.done
+
+	return nil
+}
+
+// notify wakes the delivery loop without blocking.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers due emails until the queue is closed, sleeping until the next
+// email is due or the queue is changed.
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for {
+		next, ok := q.deliverDue()
+		if !ok {
+			return
+		}
+
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-q.ctx.Done():
+		case <-q.wake:
+		case <-due:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliverDue attempts delivery of each due email, returning the time the next
+// email is due (or zero if the queue is empty), and false if the queue has
+// been closed.
+//
+// The spool directory is listed once, and the due emails delivered in order.
+// It is only listed again once they have all been attempted, or the delivery
+// loop is woken by a change to the queue.
+func (q *Queue) deliverDue() (time.Time, bool) {
+	defer q.reportErrors()
+
+	var batch []QueueItem
+	for {
+		q.reportErrors()
+		if q.ctx.Err() != nil {
+			return time.Time{}, false
+		}
+
+		select {
+		case <-q.wake:
+			batch = nil
+		default:
+		}
+
+		q.mu.Lock()
+		if len(batch) == 0 {
+			items, err := q.list()
+			if err != nil {
+				// The spool directory may be temporarily unreadable, so
+				// try again later.
+				q.mu.Unlock()
+				return time.Now().Add(q.retry.InitialBackoff), true
+			}
+			if len(items) == 0 {
+				q.mu.Unlock()
+				return time.Time{}, true
+			}
+			batch = items
+		}
+
+		item := batch[0]
+		if item.NextAttempt.After(time.Now()) {
+			q.mu.Unlock()
+			return item.NextAttempt, true
+		}
+		batch = batch[1:]
+
+		// The email may have been deleted since the batch was listed.
+		item, err := q.readItem(item.ID)
+		if err != nil {
+			q.mu.Unlock()
+			continue
+		}
+		q.inflight = item.ID
+		q.mu.Unlock()
+
+		q.deliver(item)
+
+		q.mu.Lock()
+		q.inflight = ""
+		q.mu.Unlock()
+	}
+}
+
+// deliver makes a single delivery attempt of item, removing it from the queue
+// if delivered or bounced, and scheduling the next attempt otherwise.
+func (q *Queue) deliver(item QueueItem) {
+	q.mu.Lock()
+	auth := q.auths[item.ID]
+	q.mu.Unlock()
+
+	_, err := q.transport.Send(q.ctx, &spooledMessage{
+		item: item,
+		path: q.path(item.ID + ".eml"),
+		auth: auth,
+	})
+
+	if q.ctx.Err() != nil {
+		// The queue was closed during delivery, so the attempt is not
+		// counted.
+		return
+	}
+
+	switch {
+	case err == nil:
+		q.finish(item, nil)
+		return
+
+	case isPartialDelivery(err), !q.retry.retryable(err):
+		// The email was delivered to some recipients, or will never be
+		// delivered, so retrying would not help.
+		q.finish(item, err)
+		return
+	}
+
+	now := time.Now()
+	item.Attempts++
+	item.LastError = err.Error()
+	item.NextAttempt = now.Add(q.retry.backoff(item.Attempts))
+
+	if q.retry.MaxAttempts > 0 && item.Attempts >= q.retry.MaxAttempts {
+		q.finish(item, err)
+		return
+	}
+	if expires := item.SendAt.Add(q.expiry); !item.NextAttempt.Before(expires) {
+		q.finish(item, &queueExpiredError{err: err})
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Don't resurrect the item if it was deleted during delivery.
+	if _, readErr := q.readItem(item.ID); readErr != nil {
+		return
+	}
+	_ = q.writeItem(item)
+}
+
+// finish removes item from the queue, calling the BounceFunc if err is
+// non-nil.
+func (q *Queue) finish(item QueueItem, err error) {
+	if err != nil && q.bounce != nil {
+		q.bounce(item, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_ = q.remove(item.ID)
+}
+
+// queueExpiredError wraps the last delivery error of an expired email.
+type queueExpiredError struct {
+	err error
+}
+
+func (e *queueExpiredError) Error() string {
+	return ErrQueueExpired.Error() + ": " + e.err.Error()
+}
+
+// Is allows errors.Is to match ErrQueueExpired.
+func (e *queueExpiredError) Is(target error) bool {
+	return target == ErrQueueExpired
+}
+
+func (e *queueExpiredError) Unwrap() error {
+	return e.err
+}
+
+// list reads the metadata of every queued email, ordered by their next
+// delivery attempt. Corrupt items are set aside with quarantine. q.mu must be
+// held.
+func (q *Queue) list() ([]QueueItem, error) {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]QueueItem, 0, len(names))
+	for _, name := range names {
+		item, err := q.readItem(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			if errors.Is(err, ErrQueueItemNotFound) {
+				continue
+			}
+			if errors.Is(err, ErrQueueItemCorrupt) {
+				q.quarantine(item.ID, err)
+				continue
+			}
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].NextAttempt.Before(items[j].NextAttempt)
+	})
+
+	return items, nil
+}
+
+// readItem reads the metadata of the queued email with the given id. q.mu must
+// be held.
+func (q *Queue) readItem(id string) (QueueItem, error) {
+	var item QueueItem
+	if !validQueueID(id) {
+		return item, ErrQueueItemNotFound
+	}
+
+	b, err := ioutil.ReadFile(q.path(id + ".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return item, ErrQueueItemNotFound
+		}
+		return item, err
+	}
+
+	if err := json.Unmarshal(b, &item); err != nil {
+		return QueueItem{ID: id}, fmt.Errorf("%w: %s: %v", ErrQueueItemCorrupt, id, err)
+	}
+	return item, nil
+}
+
+// quarantine sets aside the corrupt item with the given id by renaming its
+// metadata file, so it is no longer seen by the delivery loop, and records err
+// to be reported. q.mu must be held.
+func (q *Queue) quarantine(id string, err error) {
+	if renameErr := os.Rename(q.path(id+".json"), q.path(id+".bad")); renameErr != nil {
+		err = renameErr
+	}
+	q.errs = append(q.errs, queueError{id: id, err: err})
+}
+
+// queueError is an error processing the queued email with the given id.
+type queueError struct {
+	id  string
+	err error
+}
+
+// reportErrors passes the recorded errors to the ErrorFunc. q.mu must not be
+// held.
+func (q *Queue) reportErrors() {
+	q.mu.Lock()
+	errs := q.errs
+	q.errs = nil
+	q.mu.Unlock()
+
+	if q.onError == nil {
+		return
+	}
+	for _, e := range errs {
+		q.onError(e.id, e.err)
+	}
+}
+
+// writeItem atomically writes the metadata of item. q.mu must be held.
+func (q *Queue) writeItem(item QueueItem) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return q.writeFile(item.ID+".json", func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// remove deletes the files of the queued email with the given id. q.mu must be
+// held.
+func (q *Queue) remove(id string) error {
+	delete(q.auths, id)
+
+	// The metadata is removed first, so a partially removed item is not
+	// seen by the delivery loop, and the MIME content is cleaned up when the
+	// queue is next opened.
+	if err := os.Remove(q.path(id + ".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(q.path(id + ".eml")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeFile atomically creates the named file in the spool directory with the
+// content written by fn, syncing it to disk.
+func (q *Queue) writeFile(name string, fn func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(q.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	err = fn(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.path(name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// cleanup removes temporary files and MIME content without metadata, left
+// behind if the process exited while writing or removing an item. The MIME
+// content of corrupt items set aside by quarantine is kept.
+func (q *Queue) cleanup() error {
+	entries, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, ".tmp-"):
+			_ = os.Remove(q.path(name))
+
+		case strings.HasSuffix(name, ".eml"):
+			id := strings.TrimSuffix(name, ".eml")
+			if !q.exists(id+".json") && !q.exists(id+".bad") {
+				_ = os.Remove(q.path(name))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (q *Queue) path(name string) string {
+	return filepath.Join(q.dir, name)
+}
+
+// exists returns false if the named file in the spool directory does not
+// exist.
+func (q *Queue) exists(name string) bool {
+	_, err := os.Stat(q.path(name))
+	return !os.IsNotExist(err)
+}
+
+// newQueueID returns a unique ID for a queued email, ordered by creation time.
+func newQueueID() (string, error) {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(b[:]), nil
+}
+
+// validQueueID returns true if id could have been returned by newQueueID,
+// preventing ids from escaping the spool directory.
+func validQueueID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// spooledMessage is a Message with MIME content read from a spool file, sent
+// with the options recorded in item.
+type spooledMessage struct {
+	item QueueItem
+	path string
+	auth smtp.Auth
+}
+
+func (m *spooledMessage) Envelope() Envelope {
+	return m.item.Envelope
+}
+
+func (m *spooledMessage) WriteMIME(w io.Writer) error {
+	f, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (m *spooledMessage) getVERP() string {
+	return m.item.VERP
+}
+
+func (m *spooledMessage) getDSN() *DSN {
+	return m.item.DSN
+}
+
+func (m *spooledMessage) getRoutingTag() string {
+	return m.item.RoutingTag
+}
+
+func (m *spooledMessage) getHeaderFrom() string {
+	return m.item.From
+}
+
+func (m *spooledMessage) requiresSMTPUTF8() bool {
+	return m.item.SMTPUTF8
+}
+
+func (m *spooledMessage) getAuth() smtp.Auth {
+	return m.auth
+}
@@ -0,0 +1,625 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedTransport returns each error in errs for successive sends, then
+// succeeds, signalling sent after every attempt.
+type scriptedTransport struct {
+	mu        sync.Mutex
+	errs      []error
+	attempted []string
+	mimes     []string
+
+	sent chan struct{}
+}
+
+func newScriptedTransport(errs ...error) *scriptedTransport {
+	return &scriptedTransport{
+		errs: errs,
+		sent: make(chan struct{}, 100),
+	}
+}
+
+func (s *scriptedTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	var buf bytes.Buffer
+	if err := msg.WriteMIME(&buf); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer func() {
+		s.mu.Unlock()
+		s.sent <- struct{}{}
+	}()
+
+	i := len(s.attempted)
+	s.attempted = append(s.attempted, buf.String())
+	if i < len(s.errs) && s.errs[i] != nil {
+		return nil, s.errs[i]
+	}
+
+	s.mimes = append(s.mimes, buf.String())
+	return &SendResult{}, nil
+}
+
+func (s *scriptedTransport) attemptCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.attempted)
+}
+
+func (s *scriptedTransport) delivered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.mimes...)
+}
+
+// waitEmpty waits for q to contain no items.
+func waitEmpty(t *testing.T, q *Queue) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		items, err := q.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for queue to empty")
+}
+
+func queueTestMail(t *testing.T) *Mail {
+	t.Helper()
+
+	m := NewWithTransport(&fakeTransport{})
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Subject("Queued")
+	mail.Plain().SetString("bananas")
+	return mail
+}
+
+// TestQueueDelivery ensures queued emails are delivered in the background,
+// retrying temporary failures and bouncing permanently undeliverable emails.
+func TestQueueDelivery(t *testing.T) {
+	t.Parallel()
+
+	temporary := &SMTPError{Code: 451, EnhancedCode: "4.7.1", Message: "Greylisted"}
+	permanent := &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"}
+	partial := &PartialDeliveryError{}
+
+	tests := []struct {
+		name string
+		errs []error
+		opts QueueOptions
+
+		wantAttempts  int
+		wantDelivered bool
+		wantBounce    error
+	}{
+		{
+			name:          "delivered",
+			wantAttempts:  1,
+			wantDelivered: true,
+		},
+		{
+			name:          "temporary failure",
+			errs:          []error{temporary, temporary},
+			wantAttempts:  3,
+			wantDelivered: true,
+		},
+		{
+			name:         "permanent failure",
+			errs:         []error{permanent},
+			wantAttempts: 1,
+			wantBounce:   permanent,
+		},
+		{
+			name:         "partial delivery",
+			errs:         []error{partial},
+			wantAttempts: 1,
+			wantBounce:   partial,
+		},
+		{
+			name:         "max attempts",
+			errs:         []error{temporary, temporary, temporary},
+			opts:         QueueOptions{Retry: RetryPolicy{MaxAttempts: 2}},
+			wantAttempts: 2,
+			wantBounce:   temporary,
+		},
+		{
+			name: "expired",
+			errs: []error{temporary, temporary, temporary},
+			opts: QueueOptions{
+				Retry:  RetryPolicy{InitialBackoff: 200 * time.Millisecond},
+				Expiry: 300 * time.Millisecond,
+			},
+			wantAttempts: 2,
+			wantBounce:   ErrQueueExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu      sync.Mutex
+				bounced []error
+			)
+			opts := tt.opts
+			if opts.Retry.InitialBackoff == 0 {
+				opts.Retry.InitialBackoff = 10 * time.Millisecond
+			}
+			opts.Retry.Multiplier = 1
+			opts.Bounce = func(item QueueItem, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				bounced = append(bounced, err)
+			}
+
+			transport := newScriptedTransport(tt.errs...)
+			q, err := OpenQueue(t.TempDir(), transport, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+
+			if _, err := q.Enqueue(queueTestMail(t)); err != nil {
+				t.Fatal(err)
+			}
+			waitEmpty(t, q)
+
+			if got := transport.attemptCount(); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+			if got := len(transport.delivered()) == 1; got != tt.wantDelivered {
+				t.Errorf("got delivered %v, want %v", got, tt.wantDelivered)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case tt.wantBounce == nil && len(bounced) > 0:
+				t.Errorf("got bounce %v, want none", bounced)
+			case tt.wantBounce != nil && (len(bounced) != 1 || !errors.Is(bounced[0], tt.wantBounce)):
+				t.Errorf("got bounce %v, want %v", bounced, tt.wantBounce)
+			}
+		})
+	}
+}
+
+// TestQueueRestart ensures queued emails survive the queue being closed and
+// reopened, and are delivered with their original MIME content.
+func TestQueueRestart(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	mail := queueTestMail(t)
+
+	// The relay is down, so the email remains queued.
+	busy := &SMTPError{Code: 421, EnhancedCode: "4.3.2", Message: "Busy"}
+	down := newScriptedTransport(busy)
+	q, err := OpenQueue(dir, down, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := q.Enqueue(mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var item QueueItem
+	for deadline := time.Now().Add(5 * time.Second); item.Attempts == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for delivery attempt")
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		if item, err = q.Get(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Attempts != 1 || item.LastError != busy.Error() {
+		t.Errorf("got %+v, want 1 failed attempt", item)
+	}
+	if want := (Envelope{From: "from@example.org", To: []string{"to@example.org"}}); !reflect.DeepEqual(item.Envelope, want) {
+		t.Errorf("got envelope %+v, want %+v", item.Envelope, want)
+	}
+
+	// Reopening the queue delivers the email once retried.
+	up := newScriptedTransport()
+	q, err = OpenQueue(dir, up, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err := q.Retry(id); err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, q)
+
+	// The MIME content is delivered as it was first built.
+	want := down.attempted[0]
+	if got := up.delivered(); len(got) != 1 || got[0] != want {
+		t.Errorf("got delivered %q, want %q", got, want)
+	}
+}
+
+// TestQueueSchedule ensures emails are not delivered before their send at
+// time, and can be retried or deleted while queued.
+func TestQueueSchedule(t *testing.T) {
+	t.Parallel()
+
+	transport := newScriptedTransport()
+	q, err := OpenQueue(t.TempDir(), transport, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	sendAt := time.Now().Add(time.Hour)
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i], err = q.EnqueueAt(queueTestMail(t), sendAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+	for _, item := range items {
+		if !item.SendAt.Equal(sendAt) {
+			t.Errorf("got send at %v, want %v", item.SendAt, sendAt)
+		}
+	}
+
+	// Deleting an item prevents it being sent.
+	if err := q.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get(ids[0]); err != ErrQueueItemNotFound {
+		t.Errorf("got error %v after delete, want %v", err, ErrQueueItemNotFound)
+	}
+	if err := q.Delete(ids[0]); err != ErrQueueItemNotFound {
+		t.Errorf("got error %v deleting twice, want %v", err, ErrQueueItemNotFound)
+	}
+
+	// Retrying an item sends it immediately.
+	if err := q.Retry(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	<-transport.sent
+
+	items, err = q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != ids[2] {
+		t.Errorf("got %+v, want only %s queued", items, ids[2])
+	}
+
+	// Scheduled items are delivered once due.
+	soon := newScriptedTransport()
+	q2, err := OpenQueue(t.TempDir(), soon, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q2.Close()
+
+	start := time.Now()
+	if _, err := q2.EnqueueAt(queueTestMail(t), start.Add(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	<-soon.sent
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("delivered after %v, want >= 50ms", elapsed)
+	}
+}
+
+// gatedTransport signals the first recipient of each email on started, then
+// waits for release before succeeding.
+type gatedTransport struct {
+	started chan string
+	release chan struct{}
+}
+
+func (g *gatedTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	g.started <- msg.Envelope().To[0]
+
+	select {
+	case <-g.release:
+		return &SendResult{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TestQueueDeleteDuringBatch ensures an email listed for delivery is not sent
+// if it is deleted while an earlier email is being delivered.
+func TestQueueDeleteDuringBatch(t *testing.T) {
+	t.Parallel()
+
+	transport := &gatedTransport{
+		started: make(chan string, 10),
+		release: make(chan struct{}),
+	}
+	q, err := OpenQueue(t.TempDir(), transport, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enqueue := func(to string) string {
+		mail := queueTestMail(t)
+		mail.To(to)
+		id, err := q.Enqueue(mail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// Hold the delivery loop while two more emails are queued, so both are
+	// listed in the same batch once it is released.
+	enqueue("a@example.org")
+	if got := <-transport.started; got != "a@example.org" {
+		t.Fatalf("got first delivery to %s, want a@example.org", got)
+	}
+	ids := map[string]string{
+		"b@example.org": enqueue("b@example.org"),
+		"c@example.org": enqueue("c@example.org"),
+	}
+	transport.release <- struct{}{}
+
+	// Delete whichever email is not being delivered.
+	first := <-transport.started
+	var other string
+	for to, id := range ids {
+		if to != first {
+			other = to
+			if err := q.Delete(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	transport.release <- struct{}{}
+
+	waitEmpty(t, q)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-transport.started:
+		t.Errorf("got delivery to %s, want %s deleted", got, other)
+	default:
+	}
+}
+
+// TestQueueInvalidID ensures IDs cannot refer to files outside the spool
+// directory.
+func TestQueueInvalidID(t *testing.T) {
+	t.Parallel()
+
+	q, err := OpenQueue(t.TempDir(), newScriptedTransport(), QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for _, id := range []string{"", "../queue", "a/b", "ABC"} {
+		if _, err := q.Get(id); err != ErrQueueItemNotFound {
+			t.Errorf("Get(%q) got error %v, want %v", id, err, ErrQueueItemNotFound)
+		}
+	}
+}
+
+// TestQueueCorruptItem ensures a queued email with corrupt metadata is set
+// aside and reported, without preventing the other emails being delivered.
+func TestQueueCorruptItem(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	const badID = "0-bad"
+	if err := ioutil.WriteFile(filepath.Join(dir, badID+".json"), []byte("{bananas"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, badID+".eml"), []byte("apples"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type report struct {
+		id  string
+		err error
+	}
+	reports := make(chan report, 10)
+
+	transport := newScriptedTransport()
+	q, err := OpenQueue(dir, transport, QueueOptions{
+		Error: func(id string, err error) {
+			reports <- report{id: id, err: err}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if _, err := q.Enqueue(queueTestMail(t)); err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, q)
+
+	if got := len(transport.delivered()); got != 1 {
+		t.Errorf("got %d delivered, want 1", got)
+	}
+
+	select {
+	case r := <-reports:
+		if r.id != badID || !errors.Is(r.err, ErrQueueItemCorrupt) {
+			t.Errorf("got report %q %v, want %q %v", r.id, r.err, badID, ErrQueueItemCorrupt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for corrupt item to be reported")
+	}
+	select {
+	case r := <-reports:
+		t.Errorf("got second report %q %v", r.id, r.err)
+	default:
+	}
+
+	// The corrupt item is kept for inspection, including once reopened.
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q2, err := OpenQueue(dir, transport, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q2.Close()
+
+	for _, name := range []string{badID + ".bad", badID + ".eml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("corrupt item file %s: %v", name, err)
+		}
+	}
+}
+
+// sentOptions are the sending options of a Message received by a Transport.
+type sentOptions struct {
+	VERP       string
+	DSN        *DSN
+	RoutingTag string
+	From       string
+	SMTPUTF8   bool
+	Auth       smtp.Auth
+}
+
+// optionsTransport reports the sending options of each Message sent.
+type optionsTransport struct {
+	sent chan sentOptions
+}
+
+func (o *optionsTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	opts := sentOptions{
+		RoutingTag: RoutingTag(msg),
+		From:       headerFrom(msg),
+		SMTPUTF8:   requiresSMTPUTF8(msg),
+		Auth:       messageAuth(msg, nil),
+	}
+	if v, ok := msg.(verpMessage); ok {
+		opts.VERP = v.getVERP()
+	}
+	if d, ok := msg.(dsnMessage); ok {
+		opts.DSN = d.getDSN()
+	}
+
+	o.sent <- opts
+	return &SendResult{}, nil
+}
+
+// TestQueueOptions ensures the sending options of a queued email are sent with
+// it, including once the queue has been reopened, except for its credentials.
+func TestQueueOptions(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	auth := smtp.PlainAuth("", "user", "pass", "localhost")
+	dsn := DSN{Return: DSNReturnHeaders, EnvelopeID: "id-1", Notify: DSNNotifyFailure, Required: true}
+
+	newMail := func() *Mail {
+		mail := queueTestMail(t)
+		mail.To("用户@例子.广告")
+		mail.VERP("bounces+{local}={domain}@example.org")
+		mail.DSN(dsn)
+		mail.RoutingTag("newsletter")
+		mail.auth = auth
+		return mail
+	}
+
+	want := sentOptions{
+		VERP:       "bounces+{local}={domain}@example.org",
+		DSN:        &dsn,
+		RoutingTag: "newsletter",
+		From:       "from@example.org",
+		SMTPUTF8:   true,
+		Auth:       auth,
+	}
+
+	first := &optionsTransport{sent: make(chan sentOptions, 1)}
+	q, err := OpenQueue(dir, first, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(newMail()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-first.sent; !reflect.DeepEqual(got, want) {
+		t.Errorf("got options %+v, want %+v", got, want)
+	}
+
+	id, err := q.EnqueueAt(newMail(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The options are persisted, but the credentials are not.
+	second := &optionsTransport{sent: make(chan sentOptions, 1)}
+	q2, err := OpenQueue(dir, second, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q2.Close()
+
+	item, err := q2.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.VERP != want.VERP || !reflect.DeepEqual(item.DSN, want.DSN) || item.RoutingTag != want.RoutingTag || item.From != want.From || !item.SMTPUTF8 {
+		t.Errorf("got item %+v, want options %+v", item, want)
+	}
+
+	if err := q2.Retry(id); err != nil {
+		t.Fatal(err)
+	}
+	want.Auth = nil
+	if got := <-second.sent; !reflect.DeepEqual(got, want) {
+		t.Errorf("got options after reopening %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy configures how MailYak retries sends that fail with a transient
+// error, such as greylisting (451), "too many connections" (421) or a dropped
+// connection.
+//
+// Each retry performs the full SMTP conversation again, re-reading any
+// attachments from the start.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts to send an email,
+	// including the first. A value of 1 or less disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts, or 0 for no limit.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the delay grows by after each retry. Defaults
+	// to 2 if 0.
+	Multiplier float64
+
+	// Jitter randomises each delay by up to +/- the given fraction of the
+	// delay (between 0 and 1) to avoid many senders retrying in lockstep.
+	Jitter float64
+
+	// Retryable returns true if err is transient and the send should be
+	// attempted again. If nil, IsRetryable is used.
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay to wait before making the given retry, where 1 is
+// the first retry.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		//nolint:gosec // Jitter does not need a secure random source
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// retryable returns true if err should be retried according to the policy.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// IsRetryable returns true if err is a transient failure that may succeed if
+// the send is attempted again later.
+//
+// Temporary (4xx) SMTP replies and network errors, including connections
+// dropped by the server, are retryable. Permanent (5xx) SMTP replies, context
+// cancellation and partial deliveries (where retrying would send a duplicate
+// email to the accepted recipients) are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if isPartialDelivery(err) {
+		return false
+	}
+
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Retry configures the policy used to retry sends that fail with a transient
+// error. By default sends are not retried.
+//
+//	my.Retry(mailyak.RetryPolicy{
+//		MaxAttempts:    5,
+//		InitialBackoff: time.Second,
+//		MaxBackoff:     time.Minute,
+//		Jitter:         0.2,
+//	})
+//
+// Between attempts any attachments are rewound to where they started (if the
+// io.Reader implements io.Seeker) or re-opened (if added with AttachOpener).
+// If an attachment has been read and cannot be rewound, the send is not
+// retried.
+//
+// Retries respect the context passed to SendContext, and stop once it is
+// cancelled.
+func (m *MailYak) Retry(policy RetryPolicy) {
+	m.retry = policy
+}
+
+// sendWithRetry sends msg, retrying transient failures according to the
+// configured RetryPolicy.
+func (m *MailYak) sendWithRetry(ctx context.Context, msg Message) (*SendResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := m.send(ctx, msg)
+		if err == nil || attempt >= m.retry.MaxAttempts || !m.retry.retryable(err) {
+			return result, err
+		}
+
+		// Prepare the attachments to be read again, giving up if they
+		// cannot be.
+		if r, ok := msg.(rewinder); ok {
+			if rewindErr := r.rewind(); rewindErr != nil {
+				return result, err
+			}
+		}
+
+		timer := time.NewTimer(m.retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRetryPolicyBackoff ensures the delay between attempts grows
+// exponentially, is capped, and is jittered within bounds.
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "first retry",
+			policy:  RetryPolicy{InitialBackoff: time.Second},
+			retry:   1,
+			wantMin: time.Second,
+			wantMax: time.Second,
+		},
+		{
+			name:    "default multiplier",
+			policy:  RetryPolicy{InitialBackoff: time.Second},
+			retry:   3,
+			wantMin: 4 * time.Second,
+			wantMax: 4 * time.Second,
+		},
+		{
+			name:    "custom multiplier",
+			policy:  RetryPolicy{InitialBackoff: time.Second, Multiplier: 3},
+			retry:   3,
+			wantMin: 9 * time.Second,
+			wantMax: 9 * time.Second,
+		},
+		{
+			name:    "capped",
+			policy:  RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second},
+			retry:   10,
+			wantMin: 5 * time.Second,
+			wantMax: 5 * time.Second,
+		},
+		{
+			name:    "jitter",
+			policy:  RetryPolicy{InitialBackoff: 10 * time.Second, Jitter: 0.5},
+			retry:   1,
+			wantMin: 5 * time.Second,
+			wantMax: 15 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for i := 0; i < 100; i++ {
+				got := tt.policy.backoff(tt.retry)
+				if got < tt.wantMin || got > tt.wantMax {
+					t.Fatalf("got %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+// TestIsRetryable ensures transient errors are classified as retryable, and
+// permanent errors are not.
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"greylisted", &SMTPError{Code: 451, EnhancedCode: "4.7.1"}, true},
+		{"too many connections", &SMTPError{Code: 421}, true},
+		{"wrapped temporary", fmt.Errorf("sending: %w", &SMTPError{Code: 450}), true},
+		{"permanent", &SMTPError{Code: 550, EnhancedCode: "5.1.1"}, false},
+		{"partial delivery", &PartialDeliveryError{Rejected: []RecipientStatus{{Code: 451}}}, false},
+		{"split delivery", &SplitDeliveryError{Failed: []RecipientError{{Err: io.EOF}}}, false},
+		{"verp delivery", &VERPDeliveryError{Failed: []RecipientError{{Err: io.EOF}}}, false},
+		{"mx delivery", &MXDeliveryError{Failed: []DomainResult{{Err: io.EOF}}}, false},
+		{"connection dropped", io.EOF, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"cancelled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"other", errors.New("bananas"), false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// unsplit removes the line breaks inserted into base64 encoded attachments.
+func unsplit(data string) string {
+	return strings.ReplaceAll(data, "\r\n", "")
+}
+
+// TestMailYakRetry ensures transient failures are retried, with attachments
+// rewound and sent in full on the next attempt.
+func TestMailYakRetry(t *testing.T) {
+	t.Parallel()
+
+	const testTimeout = 15 * time.Second
+
+	attachment := []byte("We're in the stickiest situation since Sticky the Stick Insect got stuck on a sticky bun.")
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+
+	greet := func(c *connAsserts) {
+		c.Respond("220 localhost ESMTP bananas\r\n")
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250 localhost Hola\r\n")
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+	}
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	conns := []func(c *connAsserts){
+		// Greylisted
+		func(c *connAsserts) {
+			greet(c)
+			c.Respond("451 4.7.1 Greylisted\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		// Rejected after reading the attachment
+		func(c *connAsserts) {
+			greet(c)
+			c.Respond("250 OK\r\n")
+			c.Expect("DATA\r\n")
+			c.Respond("354 OK\r\n")
+			if data := c.ReadData(); !strings.Contains(unsplit(data), encoded) {
+				t.Errorf("attachment missing from first DATA: %q", data)
+			}
+			c.Respond("452 4.3.1 Insufficient system storage\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		// Success
+		func(c *connAsserts) {
+			greet(c)
+			c.Respond("250 OK\r\n")
+			c.Expect("DATA\r\n")
+			c.Respond("354 OK\r\n")
+			if data := c.ReadData(); !strings.Contains(unsplit(data), encoded) {
+				t.Errorf("attachment missing from retried DATA: %q", data)
+			}
+			c.Respond("250 Will do friend\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+	}
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+		for _, fn := range conns {
+			conn, err := socket.Accept()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fn(newConnAsserts(conn, t))
+			conn.Close()
+		}
+	}()
+
+	my := New(socket.Addr().String(), nil)
+	my.Retry(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+
+	mail := my.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Subject("Retry")
+	mail.Attach("sticky.txt", bytes.NewReader(attachment))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	if err := my.SendContext(ctx, mail); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	case <-handlerDone:
+	}
+}
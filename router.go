@@ -0,0 +1,210 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrNoRoute is returned by a Router when no Transport matches a message and
+// no fallback Transport is configured.
+var ErrNoRoute = errors.New("mailyak: no transport for message")
+
+// RouteFunc selects the Transport used to send msg, for use with Router.Func.
+//
+// Returning a nil Transport and nil error defers to the fallback Transport.
+// A non-nil error aborts the send and is returned to the caller.
+type RouteFunc func(ctx context.Context, msg Message) (Transport, error)
+
+// Router is a Transport that sends each message using one of several
+// Transports, allowing a single MailYak instance to send on behalf of many
+// sending identities.
+//
+//	ours := mailyak.New("smtp.example.org:587", ourAuth)
+//	tenantA, err := mailyak.NewWithTLS("relay.tenant-a.com:465", tenantAAuth, nil)
+//	...
+//	router := mailyak.NewRouter(ours.Transport())
+//	router.Domain("tenant-a.com", tenantA.Transport())
+//
+//	my := mailyak.NewWithTransport(router)
+//
+// The Transport for a message is selected by, in order of precedence:
+//
+//   - the routing tag set with Mail.RoutingTag, if registered with Tag
+//   - the domain of the From address, if registered with Domain
+//   - the RouteFunc registered with Func, if it returns a Transport
+//   - the fallback Transport passed to NewRouter
+//
+// Credentials are provided by the selected Transport, so emails routed through
+// a Router should be created by a MailYak instance without its own auth (such
+// as one created with NewWithTransport).
+//
+// A Router is safe for concurrent use, and routes may be added while it is in
+// use.
+type Router struct {
+	mu       sync.RWMutex
+	fallback Transport
+	domains  map[string]Transport
+	tags     map[string]Transport
+	fn       RouteFunc
+}
+
+// NewRouter returns a Router sending messages that match no route with
+// fallback.
+//
+// If fallback is nil, messages that match no route fail with ErrNoRoute.
+func NewRouter(fallback Transport) *Router {
+	return &Router{
+		fallback: fallback,
+		domains:  make(map[string]Transport),
+		tags:     make(map[string]Transport),
+	}
+}
+
+// Domain sends messages with a From address at domain (i.e. "example.org")
+// using t. Domains are matched case insensitively, and do not match
+// subdomains.
+//
+// The From header address is matched rather than the envelope sender, so
+// emails with a bounce address set with EnvelopeFrom or VERP, or with a null
+// sender, are routed by the identity they are sent on behalf of. The envelope
+// sender is only matched for messages without a From header address, such as
+// custom Message implementations.
+//
+// A nil t removes the route.
+func (r *Router) Domain(domain string, t Transport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	domain = strings.ToLower(domain)
+	if t == nil {
+		delete(r.domains, domain)
+		return
+	}
+	r.domains[domain] = t
+}
+
+// Tag sends messages with the routing tag set with Mail.RoutingTag using t.
+//
+// A nil t removes the route.
+func (r *Router) Tag(tag string, t Transport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t == nil {
+		delete(r.tags, tag)
+		return
+	}
+	r.tags[tag] = t
+}
+
+// Func sets fn to be called for messages that match no tag or domain route,
+// replacing any RouteFunc previously set.
+func (r *Router) Func(fn RouteFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fn = fn
+}
+
+// Send sends msg using the Transport selected for it.
+func (r *Router) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	t, err := r.route(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return t.Send(ctx, msg)
+}
+
+// route returns the Transport selected for msg.
+func (r *Router) route(ctx context.Context, msg Message) (Transport, error) {
+	r.mu.RLock()
+	fallback, fn := r.fallback, r.fn
+
+	if tag := RoutingTag(msg); tag != "" {
+		if t, ok := r.tags[tag]; ok {
+			r.mu.RUnlock()
+			return t, nil
+		}
+	}
+
+	from := headerFrom(msg)
+	if from == "" {
+		from = msg.Envelope().From
+	}
+	if i := strings.LastIndexByte(from, '@'); i >= 0 {
+		if t, ok := r.domains[strings.ToLower(from[i+1:])]; ok {
+			r.mu.RUnlock()
+			return t, nil
+		}
+	}
+	r.mu.RUnlock()
+
+	// The RouteFunc is called without holding the lock, as it may be slow or
+	// modify the routes.
+	if fn != nil {
+		t, err := fn(ctx, msg)
+		if err != nil || t != nil {
+			return t, err
+		}
+	}
+
+	if fallback == nil {
+		return nil, ErrNoRoute
+	}
+	return fallback, nil
+}
+
+// taggedMessage is implemented by messages that carry a routing tag, such as
+// Mail.
+type taggedMessage interface {
+	// getRoutingTag should return the routing tag, or an empty string if not
+	// set.
+	getRoutingTag() string
+}
+
+// RoutingTag returns the routing tag of msg set with Mail.RoutingTag, or an
+// empty string if msg has no routing tag.
+func RoutingTag(msg Message) string {
+	if m, ok := msg.(taggedMessage); ok {
+		return m.getRoutingTag()
+	}
+	return ""
+}
+
+// fromMessage is implemented by messages with a From header address, such as
+// Mail.
+type fromMessage interface {
+	// getHeaderFrom should return the address of the From header, or an
+	// empty string if not set.
+	getHeaderFrom() string
+}
+
+// headerFrom returns the From header address of msg, or an empty string if
+// msg has none.
+func headerFrom(msg Message) string {
+	if m, ok := msg.(fromMessage); ok {
+		return m.getHeaderFrom()
+	}
+	return ""
+}
+
+// Transport returns a Transport that sends messages using the SMTP server and
+// settings of m, for use with a Router.
+//
+// Messages are sent using the connection pool and RetryPolicy of m, and are
+// authenticated with the auth passed to New (unless the message carries its
+// own credentials).
+func (m *MailYak) Transport() Transport {
+	return mailyakTransport{m: m}
+}
+
+// mailyakTransport adapts a MailYak instance to the Transport interface.
+type mailyakTransport struct {
+	m *MailYak
+}
+
+func (t mailyakTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	return t.m.sendWithRetry(ctx, msg)
+}
@@ -0,0 +1,270 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestRouter ensures messages are sent using the Transport selected by tag,
+// From domain, RouteFunc or fallback, in that order of precedence.
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	errRoute := errors.New("route error")
+
+	tests := []struct {
+		name     string
+		from     string
+		setup    func(m *Mail)
+		tag      string
+		fn       RouteFunc
+		fallback bool
+
+		want    string
+		wantErr error
+	}{
+		{
+			name:     "domain",
+			from:     "a@tenant-a.com",
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name:     "domain case insensitive",
+			from:     "a@Tenant-A.COM",
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name: "bounce address at another domain",
+			from: "a@tenant-a.com",
+			setup: func(m *Mail) {
+				m.EnvelopeFrom("bounces@bounce.example.net")
+			},
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name: "envelope sender not matched",
+			from: "a@example.org",
+			setup: func(m *Mail) {
+				m.EnvelopeFrom("bounces@tenant-a.com")
+			},
+			fallback: true,
+			want:     "fallback",
+		},
+		{
+			name: "verp",
+			from: "a@tenant-a.com",
+			setup: func(m *Mail) {
+				m.VERP("bounces+{local}={domain}@bounce.example.net")
+			},
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name: "null sender",
+			from: "a@tenant-a.com",
+			setup: func(m *Mail) {
+				m.EnvelopeFrom("<>")
+			},
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name:     "subdomain not matched",
+			from:     "a@mail.tenant-a.com",
+			fallback: true,
+			want:     "fallback",
+		},
+		{
+			name:     "tag",
+			from:     "a@tenant-a.com",
+			tag:      "system",
+			fallback: true,
+			want:     "system",
+		},
+		{
+			name:     "unknown tag",
+			from:     "a@tenant-a.com",
+			tag:      "unknown",
+			fallback: true,
+			want:     "tenant-a",
+		},
+		{
+			name: "func",
+			from: "a@example.org",
+			fn: func(ctx context.Context, msg Message) (Transport, error) {
+				return nil, nil
+			},
+			fallback: true,
+			want:     "fallback",
+		},
+		{
+			name: "func error",
+			from: "a@example.org",
+			fn: func(ctx context.Context, msg Message) (Transport, error) {
+				return nil, errRoute
+			},
+			fallback: true,
+			wantErr:  errRoute,
+		},
+		{
+			name:    "no route",
+			from:    "a@example.org",
+			wantErr: ErrNoRoute,
+		},
+		{
+			name: "no from address",
+			setup: func(m *Mail) {
+				m.EnvelopeFrom("<>")
+			},
+			wantErr: ErrNoRoute,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transports := map[string]*fakeTransport{
+				"fallback": {},
+				"tenant-a": {},
+				"system":   {},
+				"func":     {},
+			}
+
+			var fallback Transport
+			if tt.fallback {
+				fallback = transports["fallback"]
+			}
+
+			r := NewRouter(fallback)
+			r.Domain("tenant-a.com", transports["tenant-a"])
+			r.Tag("system", transports["system"])
+			if tt.fn != nil {
+				r.Func(tt.fn)
+			}
+
+			m := NewWithTransport(r)
+			mail := m.NewMail()
+			mail.From(tt.from)
+			mail.To("to@example.org")
+			mail.RoutingTag(tt.tag)
+			if tt.setup != nil {
+				tt.setup(mail)
+			}
+
+			err := m.Send(mail)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			for name, ft := range transports {
+				want := 0
+				if name == tt.want {
+					want = 1
+				}
+				if got := len(ft.envelopes); got != want {
+					t.Errorf("%s got %d messages, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestRouterFunc ensures the Transport returned by a RouteFunc is used for
+// messages matching no other route.
+func TestRouterFunc(t *testing.T) {
+	t.Parallel()
+
+	fallback := &fakeTransport{}
+	routed := &fakeTransport{}
+
+	r := NewRouter(fallback)
+	r.Func(func(ctx context.Context, msg Message) (Transport, error) {
+		if RoutingTag(msg) == "bulk" {
+			return routed, nil
+		}
+		return nil, nil
+	})
+
+	m := NewWithTransport(r)
+	for _, tag := range []string{"bulk", "", "bulk"} {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+		mail.RoutingTag(tag)
+
+		if err := m.Send(mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := len(routed.envelopes); got != 2 {
+		t.Errorf("routed got %d messages, want 2", got)
+	}
+	if got := len(fallback.envelopes); got != 1 {
+		t.Errorf("fallback got %d messages, want 1", got)
+	}
+}
+
+// TestRouterCredentials ensures messages routed to a MailYak Transport are
+// sent to its SMTP server using its credentials.
+func TestRouterCredentials(t *testing.T) {
+	t.Parallel()
+
+	ours := smtptest.NewServer(smtptest.ModePlain)
+	defer ours.Close()
+	ours.Credentials = map[string]string{"ours": "pass"}
+
+	tenant := smtptest.NewServer(smtptest.ModePlain)
+	defer tenant.Close()
+	tenant.Credentials = map[string]string{"tenant": "pass"}
+
+	oursMY := New(ours.Addr, smtp.PlainAuth("", "ours", "pass", "127.0.0.1"))
+	defer oursMY.Close()
+	tenantMY := New(tenant.Addr, smtp.PlainAuth("", "tenant", "pass", "127.0.0.1"))
+	defer tenantMY.Close()
+
+	r := NewRouter(oursMY.Transport())
+	r.Domain("tenant-a.com", tenantMY.Transport())
+	m := NewWithTransport(r)
+
+	for _, from := range []string{"a@tenant-a.com", "system@example.org"} {
+		mail := m.NewMail()
+		mail.From(from)
+		mail.To("to@example.org")
+		mail.Plain().SetString("bananas")
+
+		if err := m.Send(mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		srv      *smtptest.Server
+		wantFrom string
+		wantUser string
+	}{
+		{srv: tenant, wantFrom: "a@tenant-a.com", wantUser: "tenant"},
+		{srv: ours, wantFrom: "system@example.org", wantUser: "ours"},
+	}
+	for _, tt := range tests {
+		txs := tt.srv.Transactions()
+		if len(txs) != 1 {
+			t.Fatalf("got %d transactions, want 1", len(txs))
+		}
+		if txs[0].From != tt.wantFrom {
+			t.Errorf("got from %q, want %q", txs[0].From, tt.wantFrom)
+		}
+		if txs[0].Username != tt.wantUser {
+			t.Errorf("got username %q, want %q", txs[0].Username, tt.wantUser)
+		}
+	}
+}
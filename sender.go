@@ -2,97 +2,441 @@ package mailyak
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/smtp"
+	"time"
+
+	"github.com/xenking/mailyak/v3/internal/smtpclient"
 )
 
-// emailSender abstracts the connection and protocol conversation required to
-// send an email with a remote SMTP server.
-type emailSender interface {
-	Send(m sendableMail) error
+// aLongTimeAgo is a non-zero time in the past, used to immediately unblock
+// any pending IO on a connection by setting it as the deadline.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// smtpConfig holds the connection settings shared between a MailYak instance
+// and its SMTP senders.
+//
+// A zero value is valid, and applies no timeouts.
+type smtpConfig struct {
+	// dialTimeout bounds the time taken to establish a connection, including
+	// the TLS handshake for explicit TLS connections.
+	dialTimeout time.Duration
+
+	// commandTimeout bounds each SMTP command/response round trip, including
+	// the greeting, STARTTLS and the AUTH exchange.
+	commandTimeout time.Duration
+
+	// dataTimeout bounds writing the message content after the DATA command,
+	// and reading the server's response to it.
+	dataTimeout time.Duration
+
+	// allowPartial continues the mail transaction when the server rejects
+	// some of the recipients, sending the email to those accepted.
+	allowPartial bool
+
+	// startTLSPolicy controls the STARTTLS upgrade of plain-text connections.
+	startTLSPolicy StartTLSPolicy
+
+	// tlsConfig is used for STARTTLS upgrades if non-nil.
+	tlsConfig *tls.Config
+
+	// localName is the hostname sent in the EHLO/HELO command, or empty to
+	// use "localhost".
+	localName string
+
+	// dialFunc opens connections to the SMTP server if non-nil, otherwise
+	// dialer (or a zero net.Dialer if nil) is used.
+	dialFunc DialFunc
+	dialer   *net.Dialer
+
+	// keepAlive sets the TCP keep-alive period of connections if positive, or
+	// disables keep-alives if negative.
+	keepAlive time.Duration
+
+	// limiter enforces the configured Limits if non-nil.
+	limiter *limiter
+
+	// maxMessageSize is the maximum encoded size of emails sent in bytes, or
+	// 0 for no limit.
+	maxMessageSize int64
+
+	// maxRecipients is the maximum number of recipients per mail
+	// transaction, or 0 for no limit.
+	maxRecipients int
 }
 
-// sendableMail provides a set of methods to describe an email to a SMTP server.
-type sendableMail interface {
-	// getToAddrs should return a slice of email addresses to be added to the
-	// RCPT TO command.
-	getToAddrs() []string
+// session is an established SMTP connection that has completed the greeting,
+// any STARTTLS upgrade and authentication, and is ready to perform one or more
+// mail transactions.
+type session struct {
+	conn   net.Conn
+	client *smtpclient.Client
+	config *smtpConfig
 
-	// getFromAddr should return the address to be used in the MAIL FROM
-	// command.
-	getFromAddr() string
+	// auth is the smtp.Auth the session authenticated with, if any.
+	auth smtp.Auth
 
-	// getAuth should return the smtp.Auth if configured, nil if not.
-	getAuth() smtp.Auth
+	// messages is the number of mail transactions attempted over this session.
+	messages int
 
-	// buildMime should write the generated MIME to w.
-	//
-	// The emailSender implementation is responsible for providing appropriate
-	// buffering of writes.
-	buildMime(w io.Writer) error
+	// lastUsed records when the session was last returned to a pool.
+	lastUsed time.Time
+
+	// broken is set when an operation failed with an error other than a
+	// SMTP error reply, such as a network error, timeout or context
+	// cancellation (or a pipelined batch of commands was not completed),
+	// leaving the connection in an unknown state.
+	broken bool
 }
 
-// smtpExchange performs the SMTP protocol conversation necessary to send m over
-// conn.
+// newSession performs the SMTP greeting over conn, upgrading the connection
+// with STARTTLS according to the configured StartTLSPolicy if tryTLSUpgrade is
+// true, and authenticating with auth if non-nil.
 //
 // serverName must be the hostname (or IP address) of the remote endpoint.
-func smtpExchange(m sendableMail, conn net.Conn, serverName string, tryTLSUpgrade bool) error {
+func newSession(ctx context.Context, conn net.Conn, serverName string, tryTLSUpgrade bool, auth smtp.Auth, config *smtpConfig) (*session, error) {
+	s := &session{
+		conn:   conn,
+		config: config,
+		auth:   auth,
+	}
+
 	// Connect to the SMTP server
-	c, err := smtp.NewClient(conn, serverName)
-	if err != nil {
+	err := s.do(ctx, config.commandTimeout, func() error {
+		var err error
+		s.client, err = smtpclient.NewClient(conn, serverName)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	defer func() { _ = c.Quit() }()
 
-	if tryTLSUpgrade {
-		if ok, _ := c.Extension("STARTTLS"); ok {
-			//nolint:gosec
-			config := &tls.Config{
-				ServerName: serverName,
-			}
-			if err = c.StartTLS(config); err != nil {
-				return err
+	if config.localName != "" {
+		err := s.do(ctx, config.commandTimeout, func() error {
+			return s.client.Hello(config.localName)
+		})
+		if err != nil {
+			_ = s.client.Quit()
+			return nil, err
+		}
+	}
+
+	if tryTLSUpgrade && config.startTLSPolicy != StartTLSDisabled {
+		ok, _ := s.client.Extension("STARTTLS")
+		if !ok && config.startTLSPolicy == StartTLSMandatory {
+			_ = s.client.Quit()
+			return nil, ErrStartTLSUnavailable
+		}
+
+		if ok {
+			tlsConfig := config.startTLSConfig(serverName)
+			err := s.do(ctx, config.commandTimeout, func() error {
+				return s.client.StartTLS(tlsConfig)
+			})
+			if err != nil {
+				_ = s.client.Quit()
+				return nil, err
 			}
 		}
 	}
 
 	// Attempt to authenticate if credentials were provided
 	var nilAuth smtp.Auth
-	if auth := m.getAuth(); auth != nilAuth {
-		if err = c.Auth(auth); err != nil {
+	if auth != nilAuth {
+		err := s.do(ctx, config.commandTimeout, func() error {
+			return s.client.Auth(conversation(auth))
+		})
+		if err != nil {
+			_ = s.client.Quit()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// do calls fn with the connection deadline set to timeout from now (or no
+// deadline if timeout is 0), aborting any blocked IO if ctx is cancelled
+// before fn returns.
+//
+// If ctx is cancelled, the context error is returned and the session is marked
+// as broken. SMTP error replies are returned as a *SMTPError, and any other
+// error (such as a network error or timeout, possibly part way through the
+// message content) also marks the session as broken.
+func (s *session) do(ctx context.Context, timeout time.Duration, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	_ = s.conn.SetDeadline(deadline)
+
+	if ctx.Done() == nil {
+		// The context is never cancelled, there is no need to watch it.
+		return s.check(toSMTPError(fn()))
+	}
+
+	// Watch for the context being cancelled while fn is running, setting a
+	// deadline in the past to unblock any IO fn is waiting on.
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = s.conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	close(done)
+	<-exited
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		s.broken = true
+		if err != nil {
+			return ctxErr
+		}
+	}
+
+	return s.check(toSMTPError(err))
+}
+
+// check marks the session as broken if err is not a SMTP error reply, as the
+// server may still be expecting the remainder of a command or the message
+// content, or the connection may have been lost.
+func (s *session) check(err error) error {
+	var smtpErr *SMTPError
+	if err != nil && !errors.As(err, &smtpErr) {
+		s.broken = true
+	}
+	return err
+}
+
+// send sends msg in a single mail transaction, or in several if it has more
+// recipients than the configured maximum per transaction.
+//
+// The returned SendResult describes the recipients accepted and rejected by
+// the server, and is non-nil even if an error is returned once the MAIL FROM
+// command has been accepted.
+func (s *session) send(ctx context.Context, msg Message) (*SendResult, error) {
+	if max := s.config.maxRecipients; max > 0 && len(msg.Envelope().To) > max {
+		return s.sendSplit(ctx, msg, max)
+	}
+	return s.transaction(ctx, msg)
+}
+
+// transaction performs a single mail transaction for msg, returning the result
+// as send does.
+func (s *session) transaction(ctx context.Context, msg Message) (*SendResult, error) {
+	c := s.client
+	timeout := s.config.commandTimeout
+
+	// Extension sends the EHLO greeting if it has not already been sent.
+	smtputf8, _ := c.Extension("SMTPUTF8")
+	envelope, international, err := envelopeAddresses(msg, smtputf8)
+	if err != nil {
+		return nil, err
+	}
+
+	mailParams, dsnRcpt, err := dsnParams(c, msg)
+	if err != nil {
+		return nil, err
+	}
+	if international {
+		mailParams = append(mailParams, "SMTPUTF8")
+	}
+
+	enc, body, chunking := transferMode(c, msg)
+	if body != "" {
+		mailParams = append([]string{body}, mailParams...)
+	}
+
+	// Check the email is not too large before sending any of it, or if its
+	// size is unknown, while it is sent.
+	size, err := sizeParam(c, msg, enc, chunking, s.config.maxMessageSize)
+	var limit int64
+	switch {
+	case err == errSizeUnknown:
+		limit = s.config.maxMessageSize
+	case err != nil:
+		return nil, err
+	}
+	if size != "" {
+		mailParams = append([]string{size}, mailParams...)
+	}
+
+	// Each transaction counts towards the configured messages per
+	// connection, including each of those an email is split across.
+	s.messages++
+
+	// Set the from address and add all the recipients, in a single batch of
+	// commands if supported by the server.
+	var result *SendResult
+	var dataSession io.WriteCloser
+	if ok, _ := c.Extension("PIPELINING"); ok {
+		// DATA is only included in the batch when partial delivery is
+		// allowed, as otherwise the replies to every RCPT TO command must be
+		// checked before deciding to send the email.
+		result, dataSession, err = s.pipeline(ctx, envelope, mailParams, dsnRcpt, !chunking && s.config.allowPartial)
+	} else {
+		result, err = s.envelope(ctx, envelope, mailParams, dsnRcpt)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Start the data session and write the email body, in BDAT chunks if
+	// supported by the server.
+	switch {
+	case dataSession != nil:
+		// DATA was accepted as part of the pipelined batch.
+	case chunking:
+		dataSession = c.BDAT(bdatChunkSize)
+	default:
+		err = s.do(ctx, timeout, func() error {
+			var err error
+			dataSession, err = c.Data()
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	err = s.do(ctx, s.config.dataTimeout, func() error {
+		// Wrap the socket in a small buffer (~4k) to avoid making lots of
+		// small syscalls and therefore reducing CPU usage.
+		buf := bufio.NewWriter(dataSession)
+
+		var w io.Writer = buf
+		if limit > 0 {
+			w = &limitWriter{w: buf, limit: limit, n: sizeCounter{crlf: !chunking}}
+		}
+		if err := writeMIMEEncoded(w, msg, enc); err != nil {
 			return err
 		}
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+
+		return dataSession.Close()
+	})
+	if err != nil {
+		return result, err
 	}
 
+	if len(result.Rejected) > 0 {
+		return result, &PartialDeliveryError{Rejected: result.Rejected}
+	}
+
+	return result, nil
+}
+
+// envelope issues the MAIL FROM command and a RCPT TO command for each
+// recipient of envelope, waiting for the reply to each in turn.
+func (s *session) envelope(ctx context.Context, envelope Envelope, mailParams []string, rcptParams func(addr string) []string) (*SendResult, error) {
+	c := s.client
+	timeout := s.config.commandTimeout
+
 	// Set the from address
-	if err = c.Mail(m.getFromAddr()); err != nil {
+	err := s.do(ctx, timeout, func() error {
+		_, err := c.Mail(envelope.From, mailParams)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Add all the recipients
-	for _, to := range m.getToAddrs() {
-		if err = c.Rcpt(to); err != nil {
+	result := &SendResult{}
+	for _, to := range envelope.To {
+		var status RecipientStatus
+		err := s.do(ctx, timeout, func() error {
+			var err error
+			status, err = rcpt(c, to, rcptParams(to))
 			return err
+		})
+		if err != nil {
+			var smtpErr *SMTPError
+			if !errors.As(err, &smtpErr) {
+				return result, err
+			}
+
+			result.Rejected = append(result.Rejected, status)
+			if !s.config.allowPartial {
+				return result, err
+			}
+			continue
 		}
+
+		result.Accepted = append(result.Accepted, status)
 	}
 
-	// Start the data session and write the email body
-	dataSession, err := c.Data()
-	if err != nil {
-		return err
+	if len(result.Accepted) == 0 && len(result.Rejected) > 0 {
+		// Every recipient was rejected - there is nobody to send the email
+		// to.
+		return result, result.Rejected[0].Err()
 	}
 
-	// Wrap the socket in a small buffer (~4k) to avoid making lots of small
-	// syscalls and therefore reducing CPU usage.
-	buf := bufio.NewWriter(dataSession)
-	if err := m.buildMime(buf); err != nil {
-		return err
+	return result, nil
+}
+
+// rcpt issues a RCPT TO command for addr with the given ESMTP parameters,
+// returning the server's reply.
+//
+// A rejection is returned as both a RecipientStatus and a *smtpclient.Error.
+func rcpt(c *smtpclient.Client, addr string, params []string) (RecipientStatus, error) {
+	r, err := c.Rcpt(addr, params)
+	if r.Code == 0 {
+		// The response could not be read
+		return RecipientStatus{}, err
 	}
-	if err := buf.Flush(); err != nil {
-		return err
+
+	return newRecipientStatus(addr, r.Code, r.Text()), err
+}
+
+// noop sends a NOOP command, typically used to check the connection is alive.
+func (s *session) noop(ctx context.Context) error {
+	return s.do(ctx, s.config.commandTimeout, s.client.Noop)
+}
+
+// reset aborts any in-progress mail transaction with a RSET command.
+func (s *session) reset(ctx context.Context) error {
+	return s.do(ctx, s.config.commandTimeout, s.client.Reset)
+}
+
+// quit politely ends the session with a QUIT command and closes the underlying
+// connection.
+func (s *session) quit() {
+	if !s.broken {
+		_ = s.do(context.Background(), s.config.commandTimeout, s.client.Quit)
 	}
+	_ = s.conn.Close()
+}
+
+// smtpExchange performs the SMTP protocol conversation necessary to send msg
+// over conn, authenticating with auth if non-nil.
+//
+// serverName must be the hostname (or IP address) of the remote endpoint.
+func smtpExchange(ctx context.Context, msg Message, conn net.Conn, serverName string, tryTLSUpgrade bool, auth smtp.Auth, config *smtpConfig) (*SendResult, error) {
+	s, err := newSession(ctx, conn, serverName, tryTLSUpgrade, auth, config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !s.broken {
+			_ = s.do(context.Background(), config.commandTimeout, s.client.Quit)
+		}
+	}()
 
-	return dataSession.Close()
+	return s.send(ctx, msg)
 }
@@ -1,8 +1,10 @@
 package mailyak
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
+	"net/smtp"
 )
 
 // senderExplicitTLS connects to a SMTP server over a TLS connection, performs a
@@ -11,29 +13,61 @@ import (
 type senderExplicitTLS struct {
 	hostAndPort string
 	hostname    string
+	config      *smtpConfig
+
+	// auth is used to authenticate sessions for messages without their own
+	// credentials.
+	auth smtp.Auth
 
 	// tlsConfig is always non-nil
 	tlsConfig *tls.Config
 }
 
-// Connect to the SMTP host configured in m, and send the email.
-func (s *senderExplicitTLS) Send(m sendableMail) error {
-	conn, err := tls.Dial("tcp", s.hostAndPort, s.tlsConfig)
+// Connect to the SMTP host configured in s, and send the email.
+func (s *senderExplicitTLS) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	conn, err := s.dial(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = conn.Close() }()
 
 	// Perform the SMTP protocol conversation, using the provided TLS ServerName
 	// as the SMTP server name.
-	return smtpExchange(m, conn, s.hostname, false)
+	return smtpExchange(ctx, msg, conn, s.hostname, false, s.sessionAuth(msg), s.config)
+}
+
+// dial opens a connection to the SMTP server and performs the TLS handshake.
+func (s *senderExplicitTLS) dial(ctx context.Context) (net.Conn, error) {
+	return s.config.dialTLS(ctx, s.hostAndPort, s.tlsConfig)
+}
+
+// sessionAuth returns the smtp.Auth to authenticate with when sending msg.
+func (s *senderExplicitTLS) sessionAuth(msg Message) smtp.Auth {
+	return messageAuth(msg, s.auth)
+}
+
+// dialSession connects to the SMTP server over TLS and returns a session ready
+// to send emails, authenticated with auth if non-nil.
+func (s *senderExplicitTLS) dialSession(ctx context.Context, auth smtp.Auth) (*session, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := newSession(ctx, conn, s.hostname, false, auth, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return sess, nil
 }
 
 // newSenderWithExplicitTLS constructs a new senderExplicitTLS.
 //
 // If tlsConfig is nil, a sensible default with maximum compatability is
 // generated.
-func newSenderWithExplicitTLS(hostAndPort string, tlsConfig *tls.Config) (*senderExplicitTLS, error) {
+func newSenderWithExplicitTLS(hostAndPort string, auth smtp.Auth, tlsConfig *tls.Config, config *smtpConfig) (*senderExplicitTLS, error) {
 	// Split the hostname from the addr.
 	//
 	// This hostname is used during TLS negotiation and during SMTP
@@ -47,6 +81,9 @@ func newSenderWithExplicitTLS(hostAndPort string, tlsConfig *tls.Config) (*sende
 		// Clone the user-provided TLS config to prevent it being
 		// mutated by the caller.
 		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = hostName
+		}
 	} else {
 		// If there is no TLS config provided, initialise a default.
 		//nolint:gosec // Maximum compatability but please use TLS >= 1.2
@@ -58,6 +95,8 @@ func newSenderWithExplicitTLS(hostAndPort string, tlsConfig *tls.Config) (*sende
 	return &senderExplicitTLS{
 		hostAndPort: hostAndPort,
 		hostname:    hostName,
+		config:      config,
+		auth:        auth,
 
 		tlsConfig: tlsConfig,
 	}, nil
@@ -2,7 +2,38 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
+	"net/smtp"
+)
+
+// ErrStartTLSUnavailable is returned when the StartTLSMandatory policy is
+// configured, but the SMTP server does not support the STARTTLS command.
+var ErrStartTLSUnavailable = errors.New("mailyak: STARTTLS is required but not supported by the server")
+
+// StartTLSPolicy controls if MailYak instances created with New upgrade the
+// connection to the SMTP server using STARTTLS.
+type StartTLSPolicy int
+
+const (
+	// StartTLSOpportunistic upgrades the connection if the server advertises
+	// support for STARTTLS, and otherwise continues in plain-text. This is the
+	// default.
+	//
+	// Opportunistic TLS does not protect against an attacker stripping the
+	// STARTTLS capability from the server's response.
+	StartTLSOpportunistic StartTLSPolicy = iota
+
+	// StartTLSMandatory requires the connection is upgraded using STARTTLS,
+	// failing the send with ErrStartTLSUnavailable if the server does not
+	// support it.
+	StartTLSMandatory
+
+	// StartTLSDisabled never upgrades the connection, sending all traffic in
+	// plain-text.
+	StartTLSDisabled
 )
 
 // senderWithStartTLS connects to the remote SMTP server, upgrades the
@@ -11,19 +42,71 @@ type senderWithStartTLS struct {
 	hostAndPort string
 	hostname    string
 	buf         *bytes.Buffer
+	config      *smtpConfig
+
+	// auth is used to authenticate sessions for messages without their own
+	// credentials.
+	auth smtp.Auth
 }
 
-func (s *senderWithStartTLS) Send(m sendableMail) error {
-	conn, err := net.Dial("tcp", s.hostAndPort)
+func (s *senderWithStartTLS) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	conn, err := s.dial(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = conn.Close() }()
 
-	return smtpExchange(m, conn, s.hostname, true)
+	return smtpExchange(ctx, msg, conn, s.hostname, true, s.sessionAuth(msg), s.config)
+}
+
+// dial opens a plain-text connection to the SMTP server.
+func (s *senderWithStartTLS) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := s.config.dialContext(ctx)
+	defer cancel()
+
+	return s.config.dial(ctx, s.hostAndPort)
+}
+
+// sessionAuth returns the smtp.Auth to authenticate with when sending msg.
+func (s *senderWithStartTLS) sessionAuth(msg Message) smtp.Auth {
+	return messageAuth(msg, s.auth)
+}
+
+// dialSession connects to the SMTP server and returns a session ready to send
+// emails, authenticated with auth if non-nil.
+func (s *senderWithStartTLS) dialSession(ctx context.Context, auth smtp.Auth) (*session, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := newSession(ctx, conn, s.hostname, true, auth, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// startTLSConfig returns the tls.Config used to upgrade a connection to
+// serverName, using the configured tls.Config if set.
+func (c *smtpConfig) startTLSConfig(serverName string) *tls.Config {
+	if c.tlsConfig == nil {
+		//nolint:gosec // Maximum compatability but please use TLS >= 1.2
+		return &tls.Config{
+			ServerName: serverName,
+		}
+	}
+
+	tlsConfig := c.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	return tlsConfig
 }
 
-func newSenderWithStartTLS(hostAndPort string) *senderWithStartTLS {
+func newSenderWithStartTLS(hostAndPort string, auth smtp.Auth, config *smtpConfig) *senderWithStartTLS {
 	hostName, _, err := net.SplitHostPort(hostAndPort)
 	if err != nil {
 		// Really this should be an error, but we can't return it from the New()
@@ -43,5 +126,7 @@ func newSenderWithStartTLS(hostAndPort string) *senderWithStartTLS {
 		hostAndPort: hostAndPort,
 		hostname:    hostName,
 		buf:         &bytes.Buffer{},
+		config:      config,
+		auth:        auth,
 	}
 }
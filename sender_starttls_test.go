@@ -0,0 +1,126 @@
+package mailyak
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestStartTLSPolicy ensures the STARTTLS upgrade is performed according to the
+// configured policy.
+func TestStartTLSPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		mode   smtptest.Mode
+		policy StartTLSPolicy
+
+		wantTLS bool
+		wantErr error
+	}{
+		{
+			name:    "opportunistic upgrade",
+			mode:    smtptest.ModeStartTLS,
+			policy:  StartTLSOpportunistic,
+			wantTLS: true,
+		},
+		{
+			name:    "opportunistic plain-text",
+			mode:    smtptest.ModePlain,
+			policy:  StartTLSOpportunistic,
+			wantTLS: false,
+		},
+		{
+			name:    "mandatory upgrade",
+			mode:    smtptest.ModeStartTLS,
+			policy:  StartTLSMandatory,
+			wantTLS: true,
+		},
+		{
+			name:    "mandatory unavailable",
+			mode:    smtptest.ModePlain,
+			policy:  StartTLSMandatory,
+			wantErr: ErrStartTLSUnavailable,
+		},
+		{
+			name:    "disabled",
+			mode:    smtptest.ModeStartTLS,
+			policy:  StartTLSDisabled,
+			wantTLS: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(tt.mode)
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			m.StartTLS(tt.policy)
+			m.StartTLSConfig(srv.ClientTLSConfig())
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			mail.Subject("STARTTLS")
+
+			err := m.SendContext(context.Background(), mail)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if got := len(srv.Transactions()); got != 0 {
+					t.Errorf("got %d transactions, want 0", got)
+				}
+				return
+			}
+
+			txns := srv.Transactions()
+			if len(txns) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txns))
+			}
+			if txns[0].TLS != tt.wantTLS {
+				t.Errorf("got TLS %v, want %v", txns[0].TLS, tt.wantTLS)
+			}
+		})
+	}
+}
+
+// TestStartTLSConfig ensures the configured tls.Config is used to verify the
+// server certificate, with the ServerName defaulting to the SMTP hostname.
+func TestStartTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewServer(smtptest.ModeStartTLS)
+	defer srv.Close()
+
+	// Without the test CA, the self-signed server certificate is rejected.
+	m := New(srv.Addr, nil)
+	m.StartTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+
+	mail := m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	if err := m.Send(mail); err == nil {
+		t.Fatal("expected certificate verification error")
+	}
+
+	// Trusting the test CA succeeds, without setting the ServerName.
+	cfg := srv.ClientTLSConfig()
+	cfg.ServerName = ""
+	m.StartTLSConfig(cfg)
+
+	mail = m.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	if err := m.Send(mail); err != nil {
+		t.Fatal(err)
+	}
+}
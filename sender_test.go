@@ -108,6 +108,22 @@ func (c *connAsserts) Respond(put string) {
 	}
 }
 
+// ReadData reads the message content sent after a DATA command, up to and
+// including the terminating "\r\n.\r\n" sequence.
+func (c *connAsserts) ReadData() string {
+	c.buf.Reset()
+
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(c.buf.Bytes(), []byte("\r\n.\r\n")) {
+		if _, err := io.ReadFull(c.Conn, b); err != nil {
+			c.t.Fatalf("got error %v after reading %q", err, c.buf.String())
+		}
+		c.buf.Write(b)
+	}
+
+	return c.buf.String()
+}
+
 func newConnAsserts(c net.Conn, t *testing.T) *connAsserts {
 	return &connAsserts{
 		Conn: c,
@@ -116,7 +132,7 @@ func newConnAsserts(c net.Conn, t *testing.T) *connAsserts {
 	}
 }
 
-// mockMail provides the methods for a sendableMail, allowing for deterministic
+// mockMail provides the methods for a Message, allowing for deterministic
 // MIME content in tests.
 type mockMail struct {
 	toAddrs  []string
@@ -125,15 +141,13 @@ type mockMail struct {
 	mime     string
 }
 
-// toAddrs should return a slice of email addresses to be added to the RCPT
-// TO command.
-func (m *mockMail) getToAddrs() []string {
-	return m.toAddrs
-}
-
-// fromAddr should return the address to be used in the MAIL FROM command.
-func (m *mockMail) getFromAddr() string {
-	return m.fromAddr
+// Envelope returns the addresses to be used in the MAIL FROM and RCPT TO
+// commands.
+func (m *mockMail) Envelope() Envelope {
+	return Envelope{
+		From: m.fromAddr,
+		To:   m.toAddrs,
+	}
 }
 
 // auth should return the smtp.Auth if configured, nil if not.
@@ -141,11 +155,11 @@ func (m *mockMail) getAuth() smtp.Auth {
 	return m.auth
 }
 
-// buildMime should write the generated MIME to w.
+// WriteMIME should write the generated MIME to w.
 //
-// The emailSender implementation is responsible for providing appropriate
+// The Transport implementation is responsible for providing appropriate
 // buffering of writes.
-func (m *mockMail) buildMime(w io.Writer) error {
+func (m *mockMail) WriteMIME(w io.Writer) error {
 	_, err := w.Write([]byte(m.mime))
 	return err
 }
@@ -274,7 +288,8 @@ func TestSMTPProtocolExchange(t *testing.T) {
 
 		conn, err := l.Accept()
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		defer conn.Close()
 
@@ -331,10 +346,11 @@ func TestSMTPProtocolExchange(t *testing.T) {
 				}
 
 				// Call into the sender directly, giving it the mock
-				// sendableEmail
+				// Message
 				sendErr := make(chan error)
 				go func() {
-					sendErr <- m.sender.Send(tt.mail)
+					_, err := m.sender.Send(context.Background(), tt.mail)
+					sendErr <- err
 				}()
 
 				// Wait for the SMTP conversation to complete
@@ -375,10 +391,11 @@ func TestSMTPProtocolExchange(t *testing.T) {
 				m := New(socket.Addr().String(), nil)
 
 				// Call into the sender directly, giving it the mock
-				// sendableEmail
+				// Message
 				sendErr := make(chan error)
 				go func() {
-					sendErr <- m.sender.Send(tt.mail)
+					_, err := m.sender.Send(context.Background(), tt.mail)
+					sendErr <- err
 				}()
 
 				// Wait for the SMTP conversation to complete
@@ -402,3 +419,273 @@ func TestSMTPProtocolExchange(t *testing.T) {
 		})
 	}
 }
+
+// TestSMTPExchangeTimeouts ensures a stalled SMTP server cannot block a send
+// indefinitely, with the conversation aborted by either the context or the
+// configured command timeout.
+func TestSMTPExchangeTimeouts(t *testing.T) {
+	t.Parallel()
+
+	const testTimeout = 15 * time.Second
+
+	tests := []struct {
+		name           string
+		ctxTimeout     time.Duration
+		commandTimeout time.Duration
+
+		// Called once the Send() method is invoked, impersonating and asserting
+		// the client/server conversation.
+		connFn func(c *connAsserts)
+
+		wantErr func(err error) bool
+	}{
+		{
+			name:       "context deadline during RCPT",
+			ctxTimeout: 100 * time.Millisecond,
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				// Never respond
+			},
+			wantErr: func(err error) bool {
+				return err == context.DeadlineExceeded
+			},
+		},
+		{
+			name:           "command timeout during greeting",
+			commandTimeout: 100 * time.Millisecond,
+			connFn: func(c *connAsserts) {
+				// Never send the greeting
+			},
+			wantErr: func(err error) bool {
+				netErr, ok := err.(net.Error)
+				return ok && netErr.Timeout()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			socket, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to bind to localhost: %v", err)
+			}
+			defer socket.Close()
+
+			// Hold the connection open until the test completes so the
+			// client is not unblocked by the server closing the socket.
+			release := make(chan struct{})
+			defer close(release)
+			go func() {
+				conn, err := socket.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				tt.connFn(newConnAsserts(conn, t))
+				<-release
+			}()
+
+			m := New(socket.Addr().String(), nil)
+			m.CommandTimeout(tt.commandTimeout)
+
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+
+			sendErr := make(chan error, 1)
+			go func() {
+				_, err := m.sender.Send(ctx, &mockMail{
+					toAddrs:  []string{"to@example.org"},
+					fromAddr: "from@example.org",
+					mime:     "bananas",
+				})
+				sendErr <- err
+			}()
+
+			select {
+			case <-time.After(testTimeout):
+				t.Fatal("timeout waiting for Send() to return")
+			case err := <-sendErr:
+				if !tt.wantErr(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestSMTPRecipientResults ensures the per-recipient replies are reported in
+// the SendResult, and rejected recipients are skipped when partial delivery is
+// allowed.
+func TestSMTPRecipientResults(t *testing.T) {
+	t.Parallel()
+
+	const testTimeout = 15 * time.Second
+
+	mail := &mockMail{
+		toAddrs: []string{
+			"to@example.org",
+			"nope@example.com",
+			"dom@itsallbroken.com",
+		},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+	}
+
+	accepted := []RecipientStatus{
+		{Address: "to@example.org", Code: 250, EnhancedCode: "2.1.5", Message: "OK"},
+		{Address: "dom@itsallbroken.com", Code: 250, Message: "OK"},
+	}
+	rejected := []RecipientStatus{
+		{Address: "nope@example.com", Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+	}
+
+	tests := []struct {
+		name         string
+		allowPartial bool
+
+		connFn func(c *connAsserts)
+
+		wantResult *SendResult
+		wantErr    error
+	}{
+		{
+			name: "abort on rejection",
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("250 2.1.5 OK\r\n")
+				c.Expect("RCPT TO:<nope@example.com>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantResult: &SendResult{
+				Accepted: accepted[:1],
+				Rejected: rejected,
+			},
+			wantErr: &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+		},
+		{
+			name:         "partial delivery",
+			allowPartial: true,
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("250 2.1.5 OK\r\n")
+				c.Expect("RCPT TO:<nope@example.com>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+				c.Expect("RCPT TO:<dom@itsallbroken.com>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("DATA\r\n")
+				c.Respond("354 OK\r\n")
+				c.Expect("bananas\r\n.\r\n")
+				c.Respond("250 Will do friend\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantResult: &SendResult{
+				Accepted: accepted,
+				Rejected: rejected,
+			},
+			wantErr: &PartialDeliveryError{Rejected: rejected},
+		},
+		{
+			name:         "all rejected",
+			allowPartial: true,
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("451 4.7.1 Greylisted\r\n")
+				c.Expect("RCPT TO:<nope@example.com>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+				c.Expect("RCPT TO:<dom@itsallbroken.com>\r\n")
+				c.Respond("451 4.7.1 Greylisted\r\n")
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantResult: &SendResult{
+				Rejected: []RecipientStatus{
+					{Address: "to@example.org", Code: 451, EnhancedCode: "4.7.1", Message: "Greylisted"},
+					rejected[0],
+					{Address: "dom@itsallbroken.com", Code: 451, EnhancedCode: "4.7.1", Message: "Greylisted"},
+				},
+			},
+			wantErr: &SMTPError{Code: 451, EnhancedCode: "4.7.1", Message: "Greylisted"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			socket, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to bind to localhost: %v", err)
+			}
+			defer socket.Close()
+
+			handlerDone := make(chan struct{})
+			go func() {
+				defer close(handlerDone)
+				conn, err := socket.Accept()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				tt.connFn(newConnAsserts(conn, t))
+			}()
+
+			m := New(socket.Addr().String(), nil)
+			m.AllowPartialDelivery(tt.allowPartial)
+
+			type sendReturn struct {
+				result *SendResult
+				err    error
+			}
+			sendDone := make(chan sendReturn, 1)
+			go func() {
+				result, err := m.sender.Send(context.Background(), mail)
+				sendDone <- sendReturn{result, err}
+			}()
+
+			select {
+			case <-time.After(testTimeout):
+				t.Fatal("timeout waiting for Send() to return")
+			case got := <-sendDone:
+				if !reflect.DeepEqual(got.result, tt.wantResult) {
+					t.Errorf("got result %+v, want %+v", got.result, tt.wantResult)
+				}
+				if !reflect.DeepEqual(got.err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", got.err, tt.wantErr)
+				}
+			}
+			<-handlerDone
+		})
+	}
+}
@@ -111,6 +111,60 @@ func (m *Mail) From(addr string) {
 	m.fromAddr = trimRegex.ReplaceAllString(addr, "")
 }
 
+// EnvelopeFrom sets the envelope sender address used in the SMTP MAIL FROM
+// command, which receiving servers send bounces to and record in the
+// Return-Path header. Defaults to the From address.
+//
+// An empty address (or "<>") sends the email with a null sender, as used for
+// auto-replies and delivery notifications that must not generate bounces.
+func (m *Mail) EnvelopeFrom(addr string) {
+	addr = trimRegex.ReplaceAllString(addr, "")
+	if addr == "<>" {
+		addr = ""
+	}
+
+	m.envelopeFrom = addr
+	m.hasEnvelope = true
+}
+
+// DSN requests delivery status notifications (RFC 3461) are sent to the
+// envelope sender when the email is delivered, delayed or fails, according to
+// opts.
+//
+//	mail.DSN(mailyak.DSN{
+//		Return:     mailyak.DSNReturnHeaders,
+//		EnvelopeID: "invoice-1234",
+//		Notify:     mailyak.DSNNotifySuccess | mailyak.DSNNotifyFailure,
+//	})
+//
+// Notifications are only requested if the SMTP server advertises the DSN
+// extension. If it does not, the email is sent without them unless
+// opts.Required is set, in which case ErrDSNUnsupported is returned.
+func (m *Mail) DSN(opts DSN) {
+	m.dsn = &opts
+}
+
+// VERP enables variable envelope return paths, sending the email to each
+// recipient in a separate mail transaction with an envelope sender generated
+// from template.
+//
+// The template placeholders "{local}" and "{domain}" are replaced with the
+// local part and domain of the recipient address, so the recipient of any
+// bounce can be identified from the address it is sent to:
+//
+//	mail.VERP("bounces+{local}={domain}@example.org")
+//
+// Sends to "dom@itsallbroken.com" with the envelope sender
+// "bounces+dom=itsallbroken.com@example.org". The MIME content is built once
+// and shared by every transaction, and enabling PoolSize allows the
+// transactions to reuse a single connection.
+//
+// If the email is delivered to some recipients but not others, a
+// *VERPDeliveryError is returned. An empty template disables VERP.
+func (m *Mail) VERP(template string) {
+	m.verp = trimRegex.ReplaceAllString(template, "")
+}
+
 // FromName sets the sender name.
 //
 // If set, emails typically display as being from:
@@ -145,3 +199,9 @@ func (m *Mail) Subject(sub string) {
 func (m *Mail) AddHeader(name, value string) {
 	m.headers[trimRegex.ReplaceAllString(name, "")] = mime.QEncoding.Encode("UTF-8", trimRegex.ReplaceAllString(value, ""))
 }
+
+// RoutingTag sets a tag used by a Router to select the Transport the email is
+// sent with. The tag is not included in the email.
+func (m *Mail) RoutingTag(tag string) {
+	m.routingTag = tag
+}
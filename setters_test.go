@@ -1,7 +1,9 @@
 package mailyak
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -227,3 +229,66 @@ func TestMailAddHeader(t *testing.T) {
 		})
 	}
 }
+
+// TestMailEnvelopeFrom ensures the envelope sender defaults to the From
+// address, and can be set separately, including to the null sender.
+func TestMailEnvelopeFrom(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		envelope *string
+		want     string
+	}{
+		{
+			name: "default",
+			want: "from@example.org",
+		},
+		{
+			name:     "bounce address",
+			envelope: stringPtr("bounces@example.org\r\n"),
+			want:     "bounces@example.org",
+		},
+		{
+			name:     "null sender",
+			envelope: stringPtr(""),
+			want:     "",
+		},
+		{
+			name:     "null sender brackets",
+			envelope: stringPtr("<>"),
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := getMail()
+			defer putMail(m)
+
+			m.From("from@example.org")
+			if tt.envelope != nil {
+				m.EnvelopeFrom(*tt.envelope)
+			}
+
+			if got := m.Envelope().From; got != tt.want {
+				t.Errorf("got envelope from %q, want %q", got, tt.want)
+			}
+
+			// The From header is unchanged.
+			buf := &bytes.Buffer{}
+			if err := m.writeHeaders(buf); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "From: from@example.org\r\n") {
+				t.Errorf("From header not found in %q", buf.String())
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
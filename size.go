@@ -0,0 +1,187 @@
+package mailyak
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// errSizeUnknown is returned when the size of an email cannot be computed
+// without consuming an attachment that cannot be rewound.
+var errSizeUnknown = errors.New("mailyak: size cannot be computed as an attachment cannot be rewound")
+
+// MessageSizeError is returned when an email is larger than the maximum size
+// accepted by the SMTP server (as advertised with the SIZE extension), or the
+// maximum configured with MaxMessageSize.
+//
+// The error is returned before the MAIL FROM command, so no content has been
+// transferred - unless the size of the email could not be computed ahead of
+// sending (see MaxMessageSize), in which case the transfer is aborted once the
+// limit is exceeded and the email is not delivered.
+type MessageSizeError struct {
+	// Size is the encoded size of the email in bytes, or the number of bytes
+	// written when the limit was exceeded if the transfer was aborted.
+	Size int64
+
+	// Limit is the maximum size in bytes that was exceeded.
+	Limit int64
+
+	// Local is true if Limit is the maximum configured with MaxMessageSize,
+	// and false if it was advertised by the server.
+	Local bool
+}
+
+// Error returns a description of the size and exceeded limit.
+func (e *MessageSizeError) Error() string {
+	source := "server"
+	if e.Local {
+		source = "configured"
+	}
+	return fmt.Sprintf("mailyak: message size %d exceeds %s limit of %d bytes", e.Size, source, e.Limit)
+}
+
+// sizedMessage is implemented by messages that can be written more than once,
+// allowing their size to be computed ahead of sending, such as Mail.
+type sizedMessage interface {
+	// writeSized should write the MIME content as writeMIMEEncoded does,
+	// leaving the message ready to be written again, or return
+	// errSizeUnknown if it cannot be.
+	writeSized(w io.Writer, enc transferEncoding) error
+}
+
+// MaxMessageSize sets the maximum size in bytes of the emails sent, failing
+// the send of larger emails with a *MessageSizeError, before any content is
+// transferred where possible. A size of 0 (the default) applies no limit.
+//
+// Emails are also checked against the limit advertised by servers supporting
+// the SIZE extension (RFC 1870), regardless of this setting.
+//
+// The size of an email can only be checked ahead of sending if its
+// attachments can be read twice - attachments must implement io.Seeker, or be
+// added with AttachOpener. Emails with other attachments are counted as they
+// are sent instead, and the transfer is aborted with a *MessageSizeError once
+// the limit is exceeded. Such emails are not checked against the limit
+// advertised by the server.
+func (m *MailYak) MaxMessageSize(size int64) {
+	m.config.maxMessageSize = size
+}
+
+// Size returns the exact size in bytes of the MIME content written by
+// WriteMIME, without holding the content in memory.
+//
+// The attachments are read to compute the size, and then rewound so the email
+// can still be sent. An error is returned if any attachment cannot be rewound
+// (see MaxMessageSize).
+func (m *Mail) Size() (int64, error) {
+	var w sizeCounter
+	if err := m.writeSized(&w, encoding7Bit); err != nil {
+		return 0, err
+	}
+	return w.n, nil
+}
+
+func (m *Mail) writeSized(w io.Writer, enc transferEncoding) error {
+	if !m.rewindable() {
+		return errSizeUnknown
+	}
+	if err := m.rewind(); err != nil {
+		return err
+	}
+
+	if err := m.writeMIMEEncoded(w, enc); err != nil {
+		return err
+	}
+	return m.rewind()
+}
+
+func (m *mxMessage) writeSized(w io.Writer, _ transferEncoding) error {
+	return m.WriteMIME(w)
+}
+
+func (m *spooledMessage) writeSized(w io.Writer, _ transferEncoding) error {
+	return m.WriteMIME(w)
+}
+
+// sizeCounter discards the content written to it, counting the bytes.
+//
+// If crlf is true, bare LF line breaks are counted as CRLF, matching the
+// content sent after a DATA command.
+type sizeCounter struct {
+	n      int64
+	crlf   bool
+	lastCR bool
+}
+
+func (w *sizeCounter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if !w.crlf || len(p) == 0 {
+		return len(p), nil
+	}
+
+	for i, c := range p {
+		if c == '\n' && !(i == 0 && w.lastCR || i > 0 && p[i-1] == '\r') {
+			w.n++
+		}
+	}
+	w.lastCR = p[len(p)-1] == '\r'
+	return len(p), nil
+}
+
+// sizeParam checks the encoded size of msg against maxSize and the limit
+// advertised by the server, returning the SIZE parameter for the MAIL FROM
+// command (if supported) or a *MessageSizeError if msg is too large.
+//
+// The size is only computed if the server supports SIZE or maxSize is
+// positive. If chunking is false, the size includes the conversion of bare LF
+// line breaks by the DATA command.
+//
+// If msg does not implement sizedMessage, or its size cannot be computed,
+// errSizeUnknown is returned and maxSize must be enforced with a limitWriter
+// as the content is sent.
+func sizeParam(c extensionChecker, msg Message, enc transferEncoding, chunking bool, maxSize int64) (string, error) {
+	supported, limit := c.Extension("SIZE")
+	if !supported && maxSize <= 0 {
+		return "", nil
+	}
+
+	m, ok := msg.(sizedMessage)
+	if !ok {
+		return "", errSizeUnknown
+	}
+	w := sizeCounter{crlf: !chunking}
+	if err := m.writeSized(&w, enc); err != nil {
+		return "", err
+	}
+
+	if maxSize > 0 && w.n > maxSize {
+		return "", &MessageSizeError{Size: w.n, Limit: maxSize, Local: true}
+	}
+	if !supported {
+		return "", nil
+	}
+
+	// A limit of 0 (or no limit) means the server has no fixed maximum.
+	if n, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64); err == nil && n > 0 && w.n > n {
+		return "", &MessageSizeError{Size: w.n, Limit: n}
+	}
+
+	return "SIZE=" + strconv.FormatInt(w.n, 10), nil
+}
+
+// limitWriter writes to w, counting the bytes as sizeCounter does, and fails
+// with a *MessageSizeError once more than limit bytes have been written.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     sizeCounter
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	_, _ = l.n.Write(p)
+	if l.n.n > l.limit {
+		return 0, &MessageSizeError{Size: l.n.n, Limit: l.limit, Local: true}
+	}
+	return l.w.Write(p)
+}
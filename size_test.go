@@ -0,0 +1,264 @@
+package mailyak
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestMailSize ensures the computed size matches the MIME content written, and
+// the attachments can still be sent afterwards.
+func TestMailSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		setup   func(m *Mail)
+		wantErr error
+	}{
+		{
+			name: "body only",
+			setup: func(m *Mail) {
+				m.Plain().SetString("Grüße\nline two")
+				m.HTML().SetString("<p>Grüße</p>")
+			},
+		},
+		{
+			name: "seekable attachment",
+			setup: func(m *Mail) {
+				m.Attach("a.txt", strings.NewReader(strings.Repeat("bananas ", 100)))
+			},
+		},
+		{
+			name: "opener attachment",
+			setup: func(m *Mail) {
+				m.AttachOpener("b.txt", func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(strings.NewReader("apples")), nil
+				})
+			},
+		},
+		{
+			name: "unseekable attachment",
+			setup: func(m *Mail) {
+				m.Attach("c.txt", io.MultiReader(strings.NewReader("pears")))
+			},
+			wantErr: errSizeUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mail := New("", nil).NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			mail.Subject("Size")
+			tt.setup(mail)
+
+			size, err := mail.Size()
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := mail.WriteMIME(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if size != int64(buf.Len()) {
+				t.Errorf("got size %d, want %d", size, buf.Len())
+			}
+		})
+	}
+}
+
+// TestMessageSizeLimit ensures the SIZE parameter is sent when supported, and
+// emails exceeding the server or local limits fail before being sent.
+func TestMessageSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		extensions []string
+		maxSize    int64
+
+		wantSize bool
+		wantErr  *MessageSizeError
+	}{
+		{
+			name: "not supported",
+		},
+		{
+			name:       "no server limit",
+			extensions: []string{"SIZE"},
+			wantSize:   true,
+		},
+		{
+			name:       "within server limit",
+			extensions: []string{"SIZE 100000"},
+			wantSize:   true,
+		},
+		{
+			name:       "within server limit with chunking",
+			extensions: []string{"SIZE 100000", "CHUNKING"},
+			wantSize:   true,
+		},
+		{
+			name:       "exceeds server limit",
+			extensions: []string{"SIZE 100"},
+			wantErr:    &MessageSizeError{Limit: 100},
+		},
+		{
+			name:    "exceeds local limit",
+			maxSize: 100,
+			wantErr: &MessageSizeError{Limit: 100, Local: true},
+		},
+		{
+			name:       "exceeds local limit before server limit",
+			extensions: []string{"SIZE 200"},
+			maxSize:    100,
+			wantErr:    &MessageSizeError{Limit: 100, Local: true},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			m.MaxMessageSize(tt.maxSize)
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			mail.Plain().SetString("bananas")
+			mail.Attach("a.txt", strings.NewReader(strings.Repeat("bananas\n", 100)))
+
+			err := m.Send(mail)
+			if tt.wantErr != nil {
+				var sizeErr *MessageSizeError
+				if !errors.As(err, &sizeErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if sizeErr.Limit != tt.wantErr.Limit || sizeErr.Local != tt.wantErr.Local || sizeErr.Size <= sizeErr.Limit {
+					t.Errorf("got %+v, want %+v", sizeErr, tt.wantErr)
+				}
+
+				// Nothing is sent once the size is known to be too large.
+				for _, cmd := range srv.Commands() {
+					if strings.HasPrefix(cmd, "MAIL") {
+						t.Errorf("got command %q, want no MAIL command", cmd)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			txs := srv.Transactions()
+			if len(txs) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(txs))
+			}
+
+			var want []string
+			if tt.wantSize {
+				want = []string{"SIZE=" + strconv.Itoa(len(txs[0].Data))}
+			}
+			if !reflect.DeepEqual(txs[0].MailParams, want) {
+				t.Errorf("got MAIL params %q, want %q", txs[0].MailParams, want)
+			}
+		})
+	}
+}
+
+// TestMessageSizeLimitUnknown ensures emails whose size cannot be computed
+// ahead of sending are counted as they are sent, aborting the transfer once
+// the local limit is exceeded.
+func TestMessageSizeLimitUnknown(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		extensions []string
+		maxSize    int64
+
+		wantErr bool
+	}{
+		{
+			name:    "within local limit",
+			maxSize: 100000,
+		},
+		{
+			name:    "exceeds local limit",
+			maxSize: 1000,
+			wantErr: true,
+		},
+		{
+			name:       "exceeds local limit with chunking",
+			extensions: []string{"8BITMIME", "BINARYMIME", "CHUNKING"},
+			maxSize:    1000,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+
+			m := New(srv.Addr, nil)
+			m.MaxMessageSize(tt.maxSize)
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("to@example.org")
+			mail.Plain().SetString("bananas")
+			mail.Attach("a.txt", io.MultiReader(strings.NewReader(strings.Repeat("bananas\n", 1000))))
+
+			err := m.Send(mail)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(srv.Transactions()); got != 1 {
+					t.Errorf("got %d transactions, want 1", got)
+				}
+				return
+			}
+
+			var sizeErr *MessageSizeError
+			if !errors.As(err, &sizeErr) {
+				t.Fatalf("got error %v, want *MessageSizeError", err)
+			}
+			if sizeErr.Limit != tt.maxSize || !sizeErr.Local || sizeErr.Size <= sizeErr.Limit {
+				t.Errorf("got %+v, want limit %d", sizeErr, tt.maxSize)
+			}
+			if got := len(srv.Transactions()); got != 0 {
+				t.Errorf("got %d transactions, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Package smtptest provides a SMTP server for use in end-to-end tests of code
+// that sends emails, without depending on an external mail server.
+//
+// The server listens on a random localhost port, and supports plain-text,
+// STARTTLS and implicit TLS connections using a generated self-signed
+// certificate, and AUTH PLAIN and LOGIN authentication. Every accepted
+// message is recorded as a Transaction:
+//
+//	srv := smtptest.NewServer(smtptest.ModeImplicitTLS)
+//	defer srv.Close()
+//
+//	my, err := mailyak.NewWithTLS(srv.Addr, nil, srv.ClientTLSConfig())
+//	...
+//	txns := srv.Transactions()
+//
+// Rules can be added to script failures at any point of the SMTP
+// conversation, such as returning an error reply to a RCPT command, dropping
+// the connection part way through the message content, or delaying a reply:
+//
+//	srv.AddRule(smtptest.Rule{
+//		Command: "RCPT",
+//		Arg:     "greylisted@example.org",
+//		Code:    451,
+//		Message: "4.7.1 Greylisted, try again later",
+//		Times:   1,
+//	})
+package smtptest
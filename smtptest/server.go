@@ -0,0 +1,839 @@
+package smtptest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode describes how clients connect to the Server.
+type Mode int
+
+const (
+	// ModePlain accepts plain-text connections, and does not advertise
+	// STARTTLS.
+	ModePlain Mode = iota
+
+	// ModeStartTLS accepts plain-text connections and advertises STARTTLS,
+	// allowing clients to upgrade to TLS.
+	ModeStartTLS
+
+	// ModeImplicitTLS accepts only TLS connections.
+	ModeImplicitTLS
+)
+
+// Transaction is a message accepted by the Server.
+type Transaction struct {
+	// Hello is the hostname given by the client in the EHLO/HELO command.
+	Hello string
+
+	// Username is the authenticated user, or empty if the client did not
+	// authenticate.
+	Username string
+
+	// TLS is true if the message was sent over a TLS connection.
+	TLS bool
+
+	// From is the MAIL FROM address, and MailParams any ESMTP parameters
+	// sent with it (such as "SIZE=1024").
+	From       string
+	MailParams []string
+
+	// To lists the accepted RCPT TO addresses, and RcptParams the ESMTP
+	// parameters sent with each.
+	To         []string
+	RcptParams [][]string
+
+	// Data is the message content, with dot-stuffing and the terminating "."
+	// line removed.
+	Data []byte
+
+	// Chunks is the number of BDAT commands the message content was sent
+	// with, or 0 if it was sent with DATA.
+	Chunks int
+}
+
+// Rule scripts the Server's reply to a SMTP command.
+type Rule struct {
+	// Command is the SMTP command the rule applies to, such as "EHLO",
+	// "AUTH", "MAIL", "RCPT" or "DATA".
+	//
+	// Two pseudo-commands are supported: "CONNECT" applies to the greeting
+	// sent when a client connects, and "MESSAGE" applies to the reply sent
+	// once the message content following a DATA command (or the last BDAT
+	// command) has been received.
+	Command string
+
+	// Arg restricts the rule to commands with arguments containing Arg
+	// (case insensitive), such as a specific RCPT TO address. An empty Arg
+	// matches all commands.
+	Arg string
+
+	// Code and Message are sent as the reply to the command instead of the
+	// default reply. If Code is 0, the default reply is sent.
+	Code    int
+	Message string
+
+	// Delay is the time to wait before replying.
+	Delay time.Duration
+
+	// Disconnect closes the connection instead of replying. For the
+	// "MESSAGE" command, the connection is closed part way through receiving
+	// the message content.
+	Disconnect bool
+
+	// Times is the number of times the rule is applied before it expires, or
+	// 0 to apply it indefinitely.
+	Times int
+
+	used int
+}
+
+// Server is a SMTP server for use in tests.
+type Server struct {
+	// Addr is the address the server is listening on, in the form
+	// "127.0.0.1:port".
+	Addr string
+
+	// Hostname is the name the server uses in the greeting and EHLO replies.
+	Hostname string
+
+	// Extensions lists additional EHLO keywords to advertise, such as
+	// "PIPELINING" or "SIZE 1024".
+	Extensions []string
+
+	// Credentials maps the usernames to the passwords accepted by AUTH. If
+	// nil, any credentials are accepted.
+	Credentials map[string]string
+
+	// NoAuth disables the AUTH extension.
+	NoAuth bool
+
+	// RequireAuth rejects the MAIL command from clients that have not
+	// authenticated.
+	RequireAuth bool
+
+	// TLSConfig is the server TLS configuration used for STARTTLS and
+	// implicit TLS connections, populated with a generated self-signed
+	// certificate by NewUnstartedServer.
+	TLSConfig *tls.Config
+
+	mode     Mode
+	cert     *x509.Certificate
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	rules    []*Rule
+	txns     []Transaction
+	commands []string
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewServer starts and returns a new Server accepting connections in the
+// given mode. The caller should call Close when finished, to shut it down.
+func NewServer(mode Mode) *Server {
+	s := NewUnstartedServer(mode)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server but doesn't start it, allowing the
+// configuration to be changed before calling Start.
+func NewUnstartedServer(mode Mode) *Server {
+	cert, leaf, err := generateCertificate()
+	if err != nil {
+		panic(fmt.Sprintf("smtptest: failed to generate certificate: %v", err))
+	}
+
+	return &Server{
+		Hostname: "localhost",
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+		mode:  mode,
+		cert:  leaf,
+		conns: map[net.Conn]struct{}{},
+	}
+}
+
+// Start starts a server from NewUnstartedServer.
+func (s *Server) Start() {
+	if s.listener != nil {
+		panic("smtptest: server already started")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("smtptest: failed to listen on a port: %v", err))
+	}
+	s.listener = l
+	s.Addr = l.Addr().String()
+
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Close shuts down the server, closing any open connections, and blocks until
+// all connection handlers have returned.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// Certificate returns the self-signed certificate presented by the server.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// ClientTLSConfig returns a client TLS configuration that trusts the server
+// certificate.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+
+	return &tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// AddRule adds a rule scripting the server's reply to a command. Rules are
+// evaluated in the order they were added, with the first matching rule
+// applied.
+//
+// AddRule may be called while the server is running.
+func (s *Server) AddRule(r Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.Command = strings.ToUpper(r.Command)
+	s.rules = append(s.rules, &r)
+}
+
+// Transactions returns the messages accepted by the server, in the order they
+// were received.
+func (s *Server) Transactions() []Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Transaction, len(s.txns))
+	copy(out, s.txns)
+	return out
+}
+
+// Commands returns every command line received by the server across all
+// connections, in the order received. AUTH credentials are not included.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]string, len(s.commands))
+	copy(out, s.commands)
+	return out
+}
+
+// Reset discards all recorded transactions and commands, and removes all
+// rules.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.txns = nil
+	s.commands = nil
+	s.rules = nil
+}
+
+// match returns a copy of the first rule matching cmd and arg, or nil if there
+// is no matching rule.
+func (s *Server) match(cmd, arg string) *Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.rules {
+		if r.Command != cmd {
+			continue
+		}
+		if r.Arg != "" && !strings.Contains(strings.ToLower(arg), strings.ToLower(r.Arg)) {
+			continue
+		}
+		if r.Times > 0 && r.used >= r.Times {
+			continue
+		}
+
+		r.used++
+		match := *r
+		return &match
+	}
+
+	return nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				_ = conn.Close()
+			}()
+
+			if s.mode == ModeImplicitTLS {
+				tlsConn := tls.Server(conn, s.TLSConfig)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				newConn(s, tlsConn, true).serve()
+				return
+			}
+
+			newConn(s, conn, false).serve()
+		}()
+	}
+}
+
+// conn is the state of a single client connection.
+type conn struct {
+	srv  *Server
+	nc   net.Conn
+	text *textproto.Conn
+	tls  bool
+
+	hello    string
+	username string
+	txn      *Transaction
+	chunks   bytes.Buffer
+}
+
+func newConn(srv *Server, nc net.Conn, isTLS bool) *conn {
+	return &conn{
+		srv:  srv,
+		nc:   nc,
+		text: textproto.NewConn(nc),
+		tls:  isTLS,
+	}
+}
+
+// errDisconnect is returned by handlers when a rule closes the connection.
+var errDisconnect = fmt.Errorf("smtptest: disconnect")
+
+// reply writes a (potentially multi-line) reply to the client.
+func (c *conn) reply(code int, msg string) error {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if err := c.text.PrintfLine("%03d%s%s", code, sep, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respond sends the reply scripted by a rule matching cmd and arg, or the
+// default reply if no rule matches.
+//
+// It returns true if a rule provided an alternative reply, in which case the
+// caller should not process the command further.
+func (c *conn) respond(cmd, arg string, code int, msg string) (bool, error) {
+	r := c.srv.match(cmd, arg)
+	if r == nil {
+		return false, c.reply(code, msg)
+	}
+
+	if r.Delay > 0 {
+		time.Sleep(r.Delay)
+	}
+	if r.Disconnect {
+		return true, errDisconnect
+	}
+	if r.Code == 0 {
+		return false, c.reply(code, msg)
+	}
+
+	return true, c.reply(r.Code, r.Message)
+}
+
+func (c *conn) serve() {
+	if _, err := c.respond("CONNECT", "", 220, c.srv.Hostname+" ESMTP smtptest"); err != nil {
+		return
+	}
+
+	for {
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		verb = strings.ToUpper(verb)
+
+		logged := line
+		if verb == "AUTH" {
+			// Don't record the credentials.
+			logged = verb
+			if fields := strings.Fields(arg); len(fields) > 0 {
+				logged += " " + fields[0]
+			}
+		}
+		c.srv.mu.Lock()
+		c.srv.commands = append(c.srv.commands, logged)
+		c.srv.mu.Unlock()
+
+		if err := c.handle(verb, arg); err != nil {
+			return
+		}
+		if verb == "QUIT" {
+			return
+		}
+	}
+}
+
+func (c *conn) handle(verb, arg string) error {
+	switch verb {
+	case "EHLO", "HELO":
+		return c.handleHello(verb, arg)
+	case "STARTTLS":
+		return c.handleStartTLS()
+	case "AUTH":
+		return c.handleAuth(arg)
+	case "MAIL":
+		return c.handleMail(arg)
+	case "RCPT":
+		return c.handleRcpt(arg)
+	case "DATA":
+		return c.handleData()
+	case "BDAT":
+		return c.handleBdat(arg)
+	case "RSET":
+		c.txn = nil
+		c.chunks.Reset()
+		_, err := c.respond(verb, arg, 250, "2.0.0 OK")
+		return err
+	case "NOOP":
+		_, err := c.respond(verb, arg, 250, "2.0.0 OK")
+		return err
+	case "VRFY":
+		_, err := c.respond(verb, arg, 252, "2.5.0 Cannot VRFY user")
+		return err
+	case "QUIT":
+		_, err := c.respond(verb, arg, 221, "2.0.0 Bye")
+		return err
+	default:
+		_, err := c.respond(verb, arg, 502, "5.5.2 Command not recognised")
+		return err
+	}
+}
+
+func (c *conn) handleHello(verb, arg string) error {
+	if arg == "" {
+		return c.reply(501, "5.5.4 Hostname required")
+	}
+
+	lines := []string{c.srv.Hostname + " greets " + arg}
+	if verb == "EHLO" {
+		if c.srv.mode == ModeStartTLS && !c.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		if !c.srv.NoAuth {
+			lines = append(lines, "AUTH PLAIN LOGIN")
+		}
+		lines = append(lines, c.srv.Extensions...)
+	}
+
+	overridden, err := c.respond(verb, arg, 250, strings.Join(lines, "\n"))
+	if err == nil && !overridden {
+		c.hello = arg
+		c.txn = nil
+	}
+	return err
+}
+
+func (c *conn) handleStartTLS() error {
+	if c.srv.mode != ModeStartTLS || c.tls {
+		return c.reply(502, "5.5.1 STARTTLS not available")
+	}
+
+	overridden, err := c.respond("STARTTLS", "", 220, "2.0.0 Ready to start TLS")
+	if err != nil || overridden {
+		return err
+	}
+
+	tlsConn := tls.Server(c.nc, c.srv.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	// Reset the session state as required by RFC 3207.
+	c.nc = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.tls = true
+	c.hello = ""
+	c.username = ""
+	c.txn = nil
+
+	return nil
+}
+
+func (c *conn) handleAuth(arg string) error {
+	if c.srv.NoAuth {
+		return c.reply(502, "5.5.1 AUTH not available")
+	}
+	if c.username != "" {
+		return c.reply(503, "5.5.1 Already authenticated")
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return c.reply(501, "5.5.4 Mechanism required")
+	}
+
+	overridden, err := c.respondAuth(fields[0])
+	if err != nil || overridden {
+		return err
+	}
+
+	var username, password string
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		var resp string
+		if len(fields) > 1 {
+			resp = fields[1]
+		} else if resp, err = c.challenge(""); err != nil {
+			return err
+		}
+
+		decoded, ok := decode(resp)
+		if !ok {
+			return c.reply(501, "5.5.2 Invalid response")
+		}
+		parts := bytes.Split(decoded, []byte{0})
+		if len(parts) != 3 {
+			return c.reply(501, "5.5.2 Invalid response")
+		}
+		username, password = string(parts[1]), string(parts[2])
+
+	case "LOGIN":
+		var user, pass []byte
+		var ok bool
+		resp, err := c.challenge("Username:")
+		if err != nil {
+			return err
+		}
+		if user, ok = decode(resp); !ok {
+			return c.reply(501, "5.5.2 Invalid response")
+		}
+		if resp, err = c.challenge("Password:"); err != nil {
+			return err
+		}
+		if pass, ok = decode(resp); !ok {
+			return c.reply(501, "5.5.2 Invalid response")
+		}
+		username, password = string(user), string(pass)
+
+	default:
+		return c.reply(504, "5.5.4 Unrecognised authentication mechanism")
+	}
+
+	if want, ok := c.srv.Credentials[username]; c.srv.Credentials != nil && (!ok || want != password) {
+		return c.reply(535, "5.7.8 Authentication credentials invalid")
+	}
+
+	c.username = username
+	return c.reply(235, "2.7.0 Authentication successful")
+}
+
+// respondAuth applies any rule matching the AUTH command, returning true if a
+// reply was sent.
+func (c *conn) respondAuth(mechanism string) (bool, error) {
+	r := c.srv.match("AUTH", mechanism)
+	if r == nil {
+		return false, nil
+	}
+
+	if r.Delay > 0 {
+		time.Sleep(r.Delay)
+	}
+	if r.Disconnect {
+		return true, errDisconnect
+	}
+	if r.Code == 0 {
+		return false, nil
+	}
+
+	return true, c.reply(r.Code, r.Message)
+}
+
+// challenge sends a 334 challenge, returning the client's response. A "*"
+// response (cancelling the exchange) is returned as an error after replying.
+func (c *conn) challenge(prompt string) (string, error) {
+	if err := c.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt))); err != nil {
+		return "", err
+	}
+
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if line == "*" {
+		_ = c.reply(501, "5.0.0 Authentication cancelled")
+		return "", errCancelled
+	}
+
+	return line, nil
+}
+
+// errCancelled is returned when the client cancels an AUTH exchange.
+var errCancelled = fmt.Errorf("smtptest: authentication cancelled")
+
+func decode(s string) ([]byte, bool) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return b, err == nil
+}
+
+func (c *conn) handleMail(arg string) error {
+	if c.hello == "" {
+		return c.reply(503, "5.5.1 Send EHLO first")
+	}
+	if c.txn != nil {
+		return c.reply(503, "5.5.1 Nested MAIL command")
+	}
+	if c.srv.RequireAuth && c.username == "" {
+		return c.reply(530, "5.7.0 Authentication required")
+	}
+
+	addr, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return c.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+	}
+
+	overridden, err := c.respond("MAIL", arg, 250, "2.1.0 OK")
+	if err == nil && !overridden {
+		c.txn = &Transaction{
+			Hello:      c.hello,
+			Username:   c.username,
+			TLS:        c.tls,
+			From:       addr,
+			MailParams: params,
+		}
+	}
+	return err
+}
+
+func (c *conn) handleRcpt(arg string) error {
+	if c.txn == nil {
+		return c.reply(503, "5.5.1 Need MAIL command")
+	}
+
+	addr, params, ok := parsePath(arg, "TO:")
+	if !ok || addr == "" {
+		return c.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+	}
+
+	overridden, err := c.respond("RCPT", arg, 250, "2.1.5 OK")
+	if err == nil && !overridden {
+		c.txn.To = append(c.txn.To, addr)
+		c.txn.RcptParams = append(c.txn.RcptParams, params)
+	}
+	return err
+}
+
+func (c *conn) handleData() error {
+	if c.txn == nil {
+		return c.reply(503, "5.5.1 Need MAIL command")
+	}
+	if len(c.txn.To) == 0 {
+		return c.reply(554, "5.5.1 No valid recipients")
+	}
+
+	overridden, err := c.respond("DATA", "", 354, "End data with <CR><LF>.<CR><LF>")
+	if err != nil || overridden {
+		return err
+	}
+
+	// Apply any rule for the reply to the message content before reading it,
+	// so the connection can be dropped part way through.
+	r := c.srv.match("MESSAGE", "")
+	if r != nil && r.Disconnect {
+		_, _ = c.text.ReadLine()
+		return errDisconnect
+	}
+
+	data, err := c.readData()
+	if err != nil {
+		return err
+	}
+
+	txn := c.txn
+	c.txn = nil
+
+	if r != nil {
+		if r.Delay > 0 {
+			time.Sleep(r.Delay)
+		}
+		if r.Code != 0 {
+			return c.reply(r.Code, r.Message)
+		}
+	}
+
+	txn.Data = data
+	c.srv.mu.Lock()
+	c.srv.txns = append(c.srv.txns, *txn)
+	c.srv.mu.Unlock()
+
+	return c.reply(250, "2.0.0 OK: queued")
+}
+
+// handleBdat receives a chunk of the message content (RFC 3030), completing
+// the transaction once the LAST chunk has been received.
+//
+// BDAT is accepted whether or not CHUNKING is advertised in Extensions.
+func (c *conn) handleBdat(arg string) error {
+	fields := strings.Fields(arg)
+	var size int64 = -1
+	if len(fields) > 0 {
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil && n >= 0 {
+			size = n
+		}
+	}
+	last := len(fields) == 2 && strings.EqualFold(fields[1], "LAST")
+	if size < 0 || len(fields) > 2 || (len(fields) == 2 && !last) {
+		// The chunk size is unknown, so the connection cannot continue.
+		_ = c.reply(501, "5.5.4 Syntax: BDAT <size> [LAST]")
+		return errDisconnect
+	}
+
+	// As with DATA, the connection can be dropped part way through the
+	// message content.
+	var r *Rule
+	if last && c.txn != nil {
+		if r = c.srv.match("MESSAGE", ""); r != nil && r.Disconnect {
+			return errDisconnect
+		}
+	}
+
+	// The chunk is always read, even if it is rejected.
+	if _, err := io.CopyN(&c.chunks, c.text.R, size); err != nil {
+		return err
+	}
+
+	switch {
+	case c.txn == nil:
+		c.chunks.Reset()
+		return c.reply(503, "5.5.1 Need MAIL command")
+	case len(c.txn.To) == 0:
+		c.chunks.Reset()
+		return c.reply(554, "5.5.1 No valid recipients")
+	}
+
+	c.txn.Chunks++
+	if !last {
+		_, err := c.respond("BDAT", arg, 250, fmt.Sprintf("2.0.0 %d octets received", size))
+		return err
+	}
+
+	txn := c.txn
+	c.txn = nil
+	txn.Data = append([]byte(nil), c.chunks.Bytes()...)
+	c.chunks.Reset()
+
+	if r != nil {
+		if r.Delay > 0 {
+			time.Sleep(r.Delay)
+		}
+		if r.Code != 0 {
+			return c.reply(r.Code, r.Message)
+		}
+	}
+
+	c.srv.mu.Lock()
+	c.srv.txns = append(c.srv.txns, *txn)
+	c.srv.mu.Unlock()
+
+	return c.reply(250, "2.0.0 OK: queued")
+}
+
+// readData reads the message content up to the terminating "." line, removing
+// any dot-stuffing but otherwise preserving the content as sent.
+func (c *conn) readData() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := c.text.R.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return buf.Bytes(), nil
+		}
+		buf.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+// parsePath parses a MAIL FROM or RCPT TO argument, returning the address and
+// any ESMTP parameters.
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+
+	var params []string
+	if rest := strings.TrimSpace(arg[end+1:]); rest != "" {
+		params = strings.Fields(rest)
+	}
+
+	return arg[1:end], params, true
+}
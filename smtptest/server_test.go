@@ -0,0 +1,352 @@
+package smtptest
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/smtp"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dial connects a net/smtp client to srv, upgrading to TLS as appropriate for
+// the server mode.
+func dial(t *testing.T, srv *Server, mode Mode) *smtp.Client {
+	t.Helper()
+
+	var c *smtp.Client
+	var err error
+	switch mode {
+	case ModeImplicitTLS:
+		conn, dialErr := tls.Dial("tcp", srv.Addr, srv.ClientTLSConfig())
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		c, err = smtp.NewClient(conn, "127.0.0.1")
+	default:
+		c, err = smtp.Dial(srv.Addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode == ModeStartTLS {
+		if err := c.StartTLS(srv.ClientTLSConfig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return c
+}
+
+// send performs a mail transaction over c.
+func send(c *smtp.Client, from string, to []string, body string) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// TestServerModes ensures messages are received and recorded over each
+// connection mode, with and without authentication.
+func TestServerModes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		mode Mode
+		auth bool
+
+		wantTLS bool
+	}{
+		{
+			name: "plain",
+			mode: ModePlain,
+		},
+		{
+			name:    "starttls",
+			mode:    ModeStartTLS,
+			wantTLS: true,
+		},
+		{
+			name:    "implicit tls",
+			mode:    ModeImplicitTLS,
+			wantTLS: true,
+		},
+		{
+			name:    "authenticated",
+			mode:    ModeStartTLS,
+			auth:    true,
+			wantTLS: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewServer(tt.mode)
+			defer srv.Close()
+
+			c := dial(t, srv, tt.mode)
+			if tt.auth {
+				if err := c.Auth(smtp.PlainAuth("", "user", "pass", "127.0.0.1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := send(c, "from@example.org", []string{"a@example.org", "b@example.org"}, "Subject: test\r\n\r\n.dot\r\nbananas\r\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Quit(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := Transaction{
+				Hello:      "localhost",
+				TLS:        tt.wantTLS,
+				From:       "from@example.org",
+				To:         []string{"a@example.org", "b@example.org"},
+				RcptParams: [][]string{nil, nil},
+				Data:       []byte("Subject: test\r\n\r\n.dot\r\nbananas\r\n"),
+			}
+			if tt.auth {
+				want.Username = "user"
+			}
+
+			got := srv.Transactions()
+			if len(got) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(got))
+			}
+			if !reflect.DeepEqual(got[0], want) {
+				t.Errorf("got %+v, want %+v", got[0], want)
+			}
+		})
+	}
+}
+
+// TestServerAuth ensures configured credentials are enforced for both PLAIN
+// and LOGIN authentication.
+func TestServerAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		auth     smtp.Auth
+		wantCode int
+	}{
+		{
+			name: "plain",
+			auth: smtp.PlainAuth("", "user", "pass", "127.0.0.1"),
+		},
+		{
+			name:     "plain wrong password",
+			auth:     smtp.PlainAuth("", "user", "bananas", "127.0.0.1"),
+			wantCode: 535,
+		},
+		{
+			name: "login",
+			auth: &loginAuth{username: "user", password: "pass"},
+		},
+		{
+			name:     "login unknown user",
+			auth:     &loginAuth{username: "bob", password: "pass"},
+			wantCode: 535,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := NewUnstartedServer(ModeStartTLS)
+			srv.Credentials = map[string]string{"user": "pass"}
+			srv.Start()
+			defer srv.Close()
+
+			c := dial(t, srv, ModeStartTLS)
+			defer c.Close()
+
+			err := c.Auth(tt.auth)
+			if code := replyCode(err); code != tt.wantCode {
+				t.Errorf("got %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestServerRules ensures rules script replies to the matching commands.
+func TestServerRules(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(ModePlain)
+	defer srv.Close()
+
+	srv.AddRule(Rule{
+		Command: "RCPT",
+		Arg:     "greylisted@example.org",
+		Code:    451,
+		Message: "4.7.1 Greylisted",
+		Times:   1,
+	})
+	srv.AddRule(Rule{
+		Command: "MESSAGE",
+		Code:    554,
+		Message: "5.7.1 Spam",
+		Delay:   50 * time.Millisecond,
+		Times:   1,
+	})
+
+	c := dial(t, srv, ModePlain)
+	defer c.Close()
+
+	// The first RCPT for the greylisted address is rejected.
+	err := send(c, "from@example.org", []string{"greylisted@example.org"}, "hello\r\n")
+	if code := replyCode(err); code != 451 {
+		t.Fatalf("got %v, want code 451", err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rule has expired, so the recipient is accepted, but the message is
+	// rejected after the delay.
+	start := time.Now()
+	err = send(c, "from@example.org", []string{"greylisted@example.org"}, "hello\r\n")
+	if code := replyCode(err); code != 554 {
+		t.Fatalf("got %v, want code 554", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("reply sent after %v, want delay", d)
+	}
+
+	// Both rules have now expired.
+	if err := send(c, "from@example.org", []string{"greylisted@example.org"}, "hello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.Transactions()); got != 1 {
+		t.Errorf("got %d transactions, want 1", got)
+	}
+}
+
+// TestServerDisconnect ensures a MESSAGE rule drops the connection part way
+// through receiving the message content.
+func TestServerDisconnect(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(ModePlain)
+	defer srv.Close()
+
+	srv.AddRule(Rule{Command: "MESSAGE", Disconnect: true})
+
+	c := dial(t, srv, ModePlain)
+	defer c.Close()
+
+	err := send(c, "from@example.org", []string{"to@example.org"}, "hello\r\n")
+	if err == nil {
+		t.Fatal("expected error for dropped connection")
+	}
+	if got := len(srv.Transactions()); got != 0 {
+		t.Errorf("got %d transactions, want 0", got)
+	}
+}
+
+// replyCode returns the SMTP reply code of err, 0 if err is nil, or -1 if err
+// is not a SMTP reply.
+func replyCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which is not
+// provided by net/smtp.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("unexpected challenge")
+	}
+}
+
+// TestServerBDAT ensures message content sent in BDAT chunks is received and
+// recorded.
+func TestServerBDAT(t *testing.T) {
+	t.Parallel()
+
+	srv := NewUnstartedServer(ModePlain)
+	srv.Extensions = []string{"CHUNKING"}
+	srv.Start()
+	defer srv.Close()
+
+	c := dial(t, srv, ModePlain)
+	defer c.Close()
+
+	if err := c.Mail("from@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("to@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks are sent as-is, without dot-stuffing.
+	for _, chunk := range []string{"BDAT 8\r\n.hello\r\n", "BDAT 7 LAST\r\nworld\r\n"} {
+		if _, err := io.WriteString(c.Text.W, chunk); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Text.W.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Text.ReadResponse(250); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txns := srv.Transactions()
+	if len(txns) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(txns))
+	}
+	if got, want := string(txns[0].Data), ".hello\r\nworld\r\n"; got != want {
+		t.Errorf("got data %q, want %q", got, want)
+	}
+	if txns[0].Chunks != 2 {
+		t.Errorf("got %d chunks, want 2", txns[0].Chunks)
+	}
+}
@@ -0,0 +1,186 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SplitDeliveryError is returned when the recipients of an email were split
+// across several mail transactions (see MaxRecipients), and the email was
+// delivered in some transactions but not others.
+//
+// If the email could not be delivered in any transaction, the error for the
+// first transaction is returned instead.
+type SplitDeliveryError struct {
+	// Failed lists the recipients the email could not be delivered to.
+	Failed []RecipientError
+}
+
+func (e *SplitDeliveryError) partialDelivery() {}
+
+// Error returns a description of the failed recipients.
+func (e *SplitDeliveryError) Error() string {
+	var b strings.Builder
+	b.WriteString("mailyak: delivery failed for ")
+	b.WriteString(strconv.Itoa(len(e.Failed)))
+	b.WriteString(" recipient(s): ")
+
+	for i, r := range e.Failed {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Address)
+		b.WriteString(" (")
+		b.WriteString(r.Err.Error())
+		b.WriteString(")")
+	}
+
+	return b.String()
+}
+
+// MaxRecipients sets the maximum number of recipients in each mail
+// transaction. A limit of 0 (the default) sends every email in a single
+// transaction.
+//
+// Many SMTP servers limit the recipients of a transaction (often to 50 or
+// 100), rejecting the excess with a 452 reply. Emails with more recipients
+// than n are sent in several transactions over the same connection, each to
+// at most n recipients, with the MIME content built only once. The results of
+// the transactions are combined in the returned SendResult.
+//
+// If some transactions fail while others succeed, a *SplitDeliveryError is
+// returned. If partial delivery is allowed (see AllowPartialDelivery) and
+// every transaction succeeds, recipients rejected by the server are reported
+// by a *PartialDeliveryError.
+func (m *MailYak) MaxRecipients(n int) {
+	m.config.maxRecipients = n
+}
+
+// sendSplit sends msg in a separate mail transaction for each group of up to
+// max recipients, combining the results.
+func (s *session) sendSplit(ctx context.Context, msg Message, max int) (*SendResult, error) {
+	envelope := msg.Envelope()
+
+	// The MIME content is sent in each transaction, so is built once up
+	// front using the encodings supported by the server.
+	enc, _, _ := transferMode(s.client, msg)
+	var mime bytes.Buffer
+	if err := writeMIMEEncoded(&mime, msg, enc); err != nil {
+		return nil, err
+	}
+
+	result := &SendResult{}
+	var failed []RecipientError
+	var stopErr error
+	sent := 0
+	for start := 0; start < len(envelope.To); start += max {
+		end := start + max
+		if end > len(envelope.To) {
+			end = len(envelope.To)
+		}
+		to := envelope.To[start:end]
+
+		if stopErr == nil {
+			stopErr = ctx.Err()
+		}
+		if stopErr != nil {
+			// The session can no longer be used, so the remaining
+			// recipients are not attempted.
+			for _, addr := range to {
+				failed = append(failed, RecipientError{Address: addr, Err: stopErr})
+			}
+			continue
+		}
+
+		r, err := s.transaction(ctx, &splitMessage{
+			envelope: Envelope{From: envelope.From, To: to},
+			mime:     mime.Bytes(),
+			source:   msg,
+		})
+		if r != nil {
+			result.Accepted = append(result.Accepted, r.Accepted...)
+			result.Rejected = append(result.Rejected, r.Rejected...)
+		}
+
+		if err == nil || isPartialDelivery(err) {
+			sent++
+			continue
+		}
+
+		// Record each recipient's own rejection if it has one, otherwise the
+		// transaction error.
+		rejected := map[string]error{}
+		if r != nil {
+			for _, status := range r.Rejected {
+				rejected[status.Address] = status.Err()
+			}
+		}
+		for _, addr := range to {
+			rcptErr, ok := rejected[addr]
+			if !ok {
+				rcptErr = err
+			}
+			failed = append(failed, RecipientError{Address: addr, Err: rcptErr})
+		}
+
+		// Abort the failed transaction before starting the next.
+		if s.broken {
+			stopErr = err
+		} else if resetErr := s.reset(ctx); resetErr != nil {
+			s.broken = true
+			stopErr = resetErr
+		}
+	}
+
+	switch {
+	case len(failed) == 0 && len(result.Rejected) > 0:
+		return result, &PartialDeliveryError{Rejected: result.Rejected}
+	case len(failed) == 0:
+		return result, nil
+	case sent == 0:
+		return result, failed[0].Err
+	default:
+		return result, &SplitDeliveryError{Failed: failed}
+	}
+}
+
+// splitMessage is a Message with pre-built MIME content, sent to a subset of
+// the recipients of source in a single mail transaction.
+type splitMessage struct {
+	envelope Envelope
+	mime     []byte
+	source   Message
+}
+
+func (m *splitMessage) Envelope() Envelope {
+	return m.envelope
+}
+
+func (m *splitMessage) WriteMIME(w io.Writer) error {
+	_, err := w.Write(m.mime)
+	return err
+}
+
+// writeMIMEEncoded writes the pre-built MIME content, which was built with the
+// encodings supported by the server the transaction is sent to.
+func (m *splitMessage) writeMIMEEncoded(w io.Writer, _ transferEncoding) error {
+	return m.WriteMIME(w)
+}
+
+func (m *splitMessage) writeSized(w io.Writer, _ transferEncoding) error {
+	return m.WriteMIME(w)
+}
+
+func (m *splitMessage) requiresSMTPUTF8() bool {
+	return requiresSMTPUTF8(m.source)
+}
+
+func (m *splitMessage) getDSN() *DSN {
+	if d, ok := m.source.(dsnMessage); ok {
+		return d.getDSN()
+	}
+	return nil
+}
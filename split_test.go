@@ -0,0 +1,191 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestMaxRecipients ensures emails with more recipients than the maximum are
+// sent in several transactions over one connection, with the same MIME
+// content, combining the results.
+func TestMaxRecipients(t *testing.T) {
+	t.Parallel()
+
+	rejectC := smtptest.Rule{Command: "RCPT", Arg: "c@example.org", Code: 550, Message: "5.1.1 No such user"}
+
+	tests := []struct {
+		name         string
+		extensions   []string
+		allowPartial bool
+		rules        []smtptest.Rule
+
+		wantTransactions [][]string
+		wantAccepted     int
+		wantFailed       []string
+		wantPartial      bool
+		wantCode         int
+	}{
+		{
+			name:             "all delivered",
+			wantTransactions: [][]string{{"a@example.org", "b@example.org"}, {"c@example.org", "d@example.org"}, {"e@example.org"}},
+			wantAccepted:     5,
+		},
+		{
+			name:             "transaction failed",
+			rules:            []smtptest.Rule{rejectC},
+			wantTransactions: [][]string{{"a@example.org", "b@example.org"}, {"e@example.org"}},
+			wantAccepted:     3,
+			wantFailed:       []string{"c@example.org", "d@example.org"},
+		},
+		{
+			name:             "transaction failed with pipelining",
+			extensions:       []string{"PIPELINING"},
+			rules:            []smtptest.Rule{rejectC},
+			wantTransactions: [][]string{{"a@example.org", "b@example.org"}, {"e@example.org"}},
+			wantAccepted:     4,
+			wantFailed:       []string{"c@example.org", "d@example.org"},
+		},
+		{
+			name:             "partial delivery",
+			allowPartial:     true,
+			rules:            []smtptest.Rule{rejectC},
+			wantTransactions: [][]string{{"a@example.org", "b@example.org"}, {"d@example.org"}, {"e@example.org"}},
+			wantAccepted:     4,
+			wantPartial:      true,
+		},
+		{
+			name:     "all failed",
+			rules:    []smtptest.Rule{{Command: "MAIL", Code: 451, Message: "4.3.0 Try later"}},
+			wantCode: 451,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewUnstartedServer(smtptest.ModePlain)
+			srv.Extensions = tt.extensions
+			srv.Start()
+			defer srv.Close()
+			for _, r := range tt.rules {
+				srv.AddRule(r)
+			}
+
+			m := New(srv.Addr, nil)
+			m.MaxRecipients(2)
+			m.AllowPartialDelivery(tt.allowPartial)
+
+			mail := m.NewMail()
+			mail.From("from@example.org")
+			mail.To("a@example.org", "b@example.org", "c@example.org")
+			mail.Bcc("d@example.org", "e@example.org")
+			mail.Plain().SetString("bananas")
+			mail.Attach("a.txt", strings.NewReader("apples"))
+
+			result, err := m.Deliver(context.Background(), mail)
+
+			var splitErr *SplitDeliveryError
+			var partialErr *PartialDeliveryError
+			var smtpErr *SMTPError
+			switch {
+			case tt.wantFailed != nil:
+				if !errors.As(err, &splitErr) {
+					t.Fatalf("got error %v, want *SplitDeliveryError", err)
+				}
+				var failed []string
+				for _, f := range splitErr.Failed {
+					failed = append(failed, f.Address)
+				}
+				if !reflect.DeepEqual(failed, tt.wantFailed) {
+					t.Errorf("got failed %q, want %q", failed, tt.wantFailed)
+				}
+			case tt.wantPartial:
+				if !errors.As(err, &partialErr) || len(partialErr.Rejected) != 1 || partialErr.Rejected[0].Address != "c@example.org" {
+					t.Fatalf("got error %v, want c@example.org rejected", err)
+				}
+			case tt.wantCode != 0:
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("got error %v, want code %d", err, tt.wantCode)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if got := len(result.Accepted); got != tt.wantAccepted {
+				t.Errorf("got %d accepted, want %d", got, tt.wantAccepted)
+			}
+
+			txs := srv.Transactions()
+			var got [][]string
+			for _, tx := range txs {
+				got = append(got, tx.To)
+				if !bytes.Equal(tx.Data, txs[0].Data) {
+					t.Error("transactions sent with different MIME content")
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantTransactions) {
+				t.Errorf("got transactions to %q, want %q", got, tt.wantTransactions)
+			}
+
+			// Every transaction is sent over the same connection.
+			hellos := 0
+			for _, cmd := range srv.Commands() {
+				if strings.HasPrefix(cmd, "EHLO") {
+					hellos++
+				}
+			}
+			if hellos != 1 {
+				t.Errorf("got %d connections, want 1", hellos)
+			}
+		})
+	}
+}
+
+// TestMaxRecipientsMessagesPerConn ensures each transaction of a split email
+// counts towards the messages sent over a pooled connection.
+func TestMaxRecipientsMessagesPerConn(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+
+	m := New(srv.Addr, nil)
+	defer m.Close()
+	m.PoolSize(1)
+	m.MaxMessagesPerConn(2)
+	m.MaxRecipients(1)
+
+	for _, to := range [][]string{{"a@example.org", "b@example.org"}, {"c@example.org"}} {
+		mail := m.NewMail()
+		mail.From("from@example.org")
+		mail.To(to...)
+		mail.Plain().SetString("bananas")
+		if err := m.Send(mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := len(srv.Transactions()); got != 3 {
+		t.Fatalf("got %d transactions, want 3", got)
+	}
+
+	// The first email reaches the limit, so the second is sent over a new
+	// connection.
+	hellos := 0
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "EHLO") {
+			hellos++
+		}
+	}
+	if hellos != 2 {
+		t.Errorf("got %d connections, want 2", hellos)
+	}
+}
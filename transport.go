@@ -0,0 +1,120 @@
+package mailyak
+
+import (
+	"context"
+	"io"
+	"net/smtp"
+	"time"
+)
+
+// Transport delivers emails built with MailYak.
+//
+// MailYak includes SMTP transports (used by New and NewWithTLS), but any
+// implementation can be used with NewWithTransport, such as one submitting the
+// email to a HTTP API or writing it to a local spool.
+//
+// Implementations must be safe for concurrent use.
+type Transport interface {
+	// Send delivers msg, returning a description of the outcome.
+	//
+	// Implementations should abort the delivery and return promptly if ctx
+	// is cancelled. A nil SendResult may be returned if the transport has no
+	// per-recipient information to report.
+	Send(ctx context.Context, msg Message) (*SendResult, error)
+}
+
+// Message is a read-only view of an email, as passed to a Transport.
+//
+// Mail implements Message.
+type Message interface {
+	// Envelope returns the sender and recipient addresses used to route the
+	// email, as opposed to the addresses in the message headers.
+	Envelope() Envelope
+
+	// WriteMIME writes the raw MIME content of the email to w.
+	//
+	// Attachments are read from their source as the MIME is written, so
+	// WriteMIME should be called once per delivery attempt. The Transport is
+	// responsible for providing appropriate buffering of writes.
+	WriteMIME(w io.Writer) error
+}
+
+// Envelope describes the addresses used to route an email, typically sent in
+// the SMTP MAIL FROM and RCPT TO commands.
+type Envelope struct {
+	// From is the envelope sender address.
+	From string
+
+	// To lists all the recipient addresses, including any CC and BCC
+	// recipients.
+	To []string
+}
+
+// authenticatedMessage is implemented by messages that carry their own SMTP
+// credentials, such as a Mail created by MailYak.NewMail.
+type authenticatedMessage interface {
+	// getAuth should return the smtp.Auth if configured, nil if not.
+	getAuth() smtp.Auth
+}
+
+// rewinder is implemented by messages that can prepare their content to be
+// written again after a failed delivery attempt, such as Mail.
+type rewinder interface {
+	// rewind should return an error if the content cannot be written again.
+	rewind() error
+}
+
+// messageAuth returns the smtp.Auth configured on msg, falling back to def if
+// msg has no credentials of its own.
+func messageAuth(msg Message, def smtp.Auth) smtp.Auth {
+	if m, ok := msg.(authenticatedMessage); ok {
+		if auth := m.getAuth(); auth != nil {
+			return auth
+		}
+	}
+	return def
+}
+
+// NewWithTransport returns an instance of MailYak that delivers emails using
+// t instead of connecting to a SMTP server.
+//
+//	my := mailyak.NewWithTransport(myHTTPAPITransport)
+//	mail := my.NewMail()
+//	mail.To("dom@itsallbroken.com")
+//	...
+//	err := my.Send(mail)
+//
+// The SMTP connection settings (such as PoolSize and the timeouts) have no
+// effect on instances using a custom Transport, but Retry does.
+func NewWithTransport(t Transport) *MailYak {
+	return &MailYak{
+		sender: t,
+		config: &smtpConfig{},
+	}
+}
+
+// Envelope returns the SMTP envelope for the email, with the From address as
+// the sender and the To, CC and BCC addresses as recipients.
+func (m *Mail) Envelope() Envelope {
+	return Envelope{
+		From: m.getFromAddr(),
+		To:   m.getToAddrs(),
+	}
+}
+
+// WriteMIME writes the raw MIME content of the email to w, using the date the
+// Mail was created (or last sent) as the Date header.
+//
+// Attachments are read as the MIME is written.
+func (m *Mail) WriteMIME(w io.Writer) error {
+	return m.writeMIMEEncoded(w, encoding7Bit)
+}
+
+// writeMIMEEncoded writes the raw MIME content of the email to w, as
+// WriteMIME, using the transfer encodings allowed by enc.
+func (m *Mail) writeMIMEEncoded(w io.Writer, enc transferEncoding) error {
+	if m.date == "" {
+		m.date = time.Now().Format(mailDateFormat)
+	}
+	return m.buildMime(w, enc)
+}
@@ -0,0 +1,137 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTransport records the envelope and MIME content of each message sent.
+type fakeTransport struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+	mimes     []string
+
+	err error
+}
+
+func (f *fakeTransport) Send(ctx context.Context, msg Message) (*SendResult, error) {
+	buf := &bytes.Buffer{}
+	if err := msg.WriteMIME(buf); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.envelopes = append(f.envelopes, msg.Envelope())
+	f.mimes = append(f.mimes, buf.String())
+
+	return nil, f.err
+}
+
+// TestNewWithTransport ensures emails are handed to a custom Transport with the
+// correct envelope and MIME content.
+func TestNewWithTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := &fakeTransport{}
+	my := NewWithTransport(transport)
+
+	// SMTP settings are ignored for custom transports.
+	my.PoolSize(10)
+
+	mail := my.NewMail()
+	mail.From("from@example.org")
+	mail.To("to@example.org")
+	mail.Cc("cc@example.org")
+	mail.Bcc("bcc@example.org")
+	mail.Subject("Custom transport")
+	mail.Plain().SetString("Sent without SMTP")
+	mail.Attach("advice.txt", strings.NewReader("Don't Panic"))
+
+	if err := my.Send(mail); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	wantEnvelope := []Envelope{{
+		From: "from@example.org",
+		To:   []string{"to@example.org", "cc@example.org", "bcc@example.org"},
+	}}
+	if !reflect.DeepEqual(transport.envelopes, wantEnvelope) {
+		t.Errorf("got envelopes %+v, want %+v", transport.envelopes, wantEnvelope)
+	}
+
+	for _, want := range []string{
+		"Subject: Custom transport\r\n",
+		"Sent without SMTP",
+		"filename=\"advice.txt\"",
+	} {
+		if !strings.Contains(transport.mimes[0], want) {
+			t.Errorf("MIME missing %q:\n%s", want, transport.mimes[0])
+		}
+	}
+
+	if err := my.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+// TestNewWithTransportError ensures errors returned by a custom Transport are
+// passed to the caller.
+func TestNewWithTransportError(t *testing.T) {
+	t.Parallel()
+
+	wantErr := errors.New("bananas")
+	my := NewWithTransport(&fakeTransport{err: wantErr})
+
+	mail := my.NewMail()
+	mail.To("to@example.org")
+
+	if err := my.Send(mail); err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+}
+
+// TestMessageAuth ensures credentials configured on a message take precedence
+// over the transport credentials.
+func TestMessageAuth(t *testing.T) {
+	t.Parallel()
+
+	transportAuth := smtp.PlainAuth("", "transport", "pass", "127.0.0.1")
+	mailAuth := smtp.PlainAuth("", "mail", "pass", "127.0.0.1")
+
+	tests := []struct {
+		name string
+		msg  Message
+		want smtp.Auth
+	}{
+		{"message auth", &mockMail{auth: mailAuth}, mailAuth},
+		{"no message auth", &mockMail{}, transportAuth},
+		{"not authenticated message", &fakeMessage{}, transportAuth},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := messageAuth(tt.msg, transportAuth); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeMessage is a Message without any SMTP credentials.
+type fakeMessage struct{}
+
+func (f *fakeMessage) Envelope() Envelope { return Envelope{} }
+
+func (f *fakeMessage) WriteMIME(w io.Writer) error { return nil }
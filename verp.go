@@ -0,0 +1,171 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// RecipientError describes the failure to deliver an email to a single
+// recipient.
+type RecipientError struct {
+	// Address is the recipient email address.
+	Address string
+
+	// Err is the error delivering to Address.
+	Err error
+}
+
+// VERPDeliveryError is returned when an email sent with VERP was delivered to
+// some recipients, but not others.
+//
+// If the email could not be delivered to any recipient, the error for the
+// first recipient is returned instead.
+type VERPDeliveryError struct {
+	// Failed lists the recipients the email could not be delivered to.
+	Failed []RecipientError
+}
+
+func (e *VERPDeliveryError) partialDelivery() {}
+
+// Error returns a description of the failed recipients.
+func (e *VERPDeliveryError) Error() string {
+	var b strings.Builder
+	b.WriteString("mailyak: delivery failed for ")
+	b.WriteString(strconv.Itoa(len(e.Failed)))
+	b.WriteString(" recipient(s): ")
+
+	for i, r := range e.Failed {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Address)
+		b.WriteString(" (")
+		b.WriteString(r.Err.Error())
+		b.WriteString(")")
+	}
+
+	return b.String()
+}
+
+// verpMessage is implemented by messages sent with variable envelope return
+// paths, such as a Mail with VERP set.
+type verpMessage interface {
+	// getVERP should return the VERP template if configured, or an empty
+	// string if not.
+	getVERP() string
+}
+
+// sendVERP sends msg to each recipient in a separate mail transaction, using
+// an envelope sender generated from template.
+func (m *MailYak) sendVERP(ctx context.Context, msg Message, template string) (*SendResult, error) {
+	envelope := msg.Envelope()
+	if len(envelope.To) == 0 {
+		// Let the server reject the transaction as it would without VERP.
+		return m.sendTransaction(ctx, msg)
+	}
+
+	// The MIME content is sent in each transaction, so is built once up
+	// front.
+	var mime bytes.Buffer
+	if err := msg.WriteMIME(&mime); err != nil {
+		return nil, err
+	}
+
+	result := &SendResult{}
+	var failed []RecipientError
+	sent := 0
+	for _, to := range envelope.To {
+		if err := ctx.Err(); err != nil {
+			// Don't attempt the remaining recipients once cancelled.
+			failed = append(failed, RecipientError{Address: to, Err: err})
+			continue
+		}
+
+		r, err := m.sendTransaction(ctx, &verpRecipientMessage{
+			envelope: Envelope{From: verpAddress(template, to), To: []string{to}},
+			mime:     mime.Bytes(),
+			source:   msg,
+		})
+		if r != nil {
+			result.Accepted = append(result.Accepted, r.Accepted...)
+			result.Rejected = append(result.Rejected, r.Rejected...)
+		}
+		if err != nil {
+			failed = append(failed, RecipientError{Address: to, Err: err})
+			continue
+		}
+		sent++
+	}
+
+	switch {
+	case len(failed) == 0:
+		return result, nil
+	case sent == 0:
+		return result, failed[0].Err
+	default:
+		return result, &VERPDeliveryError{Failed: failed}
+	}
+}
+
+// verpRecipientMessage is a Message with pre-built MIME content, sent to a
+// single recipient of source with a VERP envelope sender.
+//
+// The routing tag, credentials, DSN and SMTPUTF8 options of source are
+// retained, so the transaction is routed and sent as source would be.
+type verpRecipientMessage struct {
+	envelope Envelope
+	mime     []byte
+	source   Message
+}
+
+func (m *verpRecipientMessage) Envelope() Envelope {
+	return m.envelope
+}
+
+func (m *verpRecipientMessage) WriteMIME(w io.Writer) error {
+	_, err := w.Write(m.mime)
+	return err
+}
+
+func (m *verpRecipientMessage) writeSized(w io.Writer, _ transferEncoding) error {
+	return m.WriteMIME(w)
+}
+
+func (m *verpRecipientMessage) getRoutingTag() string {
+	return RoutingTag(m.source)
+}
+
+func (m *verpRecipientMessage) getHeaderFrom() string {
+	return headerFrom(m.source)
+}
+
+func (m *verpRecipientMessage) getAuth() smtp.Auth {
+	return messageAuth(m.source, nil)
+}
+
+func (m *verpRecipientMessage) getDSN() *DSN {
+	if d, ok := m.source.(dsnMessage); ok {
+		return d.getDSN()
+	}
+	return nil
+}
+
+func (m *verpRecipientMessage) requiresSMTPUTF8() bool {
+	return requiresSMTPUTF8(m.source)
+}
+
+// verpAddress returns the envelope sender for rcpt, replacing the "{local}"
+// and "{domain}" placeholders in template with the parts of the recipient
+// address.
+func verpAddress(template, rcpt string) string {
+	local, domain := rcpt, ""
+	if i := strings.LastIndexByte(rcpt, '@'); i >= 0 {
+		local, domain = rcpt[:i], rcpt[i+1:]
+	}
+
+	return strings.NewReplacer("{local}", local, "{domain}", domain).Replace(template)
+}
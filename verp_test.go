@@ -0,0 +1,200 @@
+package mailyak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/smtp"
+	"reflect"
+	"testing"
+
+	"github.com/xenking/mailyak/v3/smtptest"
+)
+
+// TestVERP ensures each recipient is sent the email in a separate transaction
+// with a generated envelope sender, and failures are reported per recipient.
+func TestVERP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		rules []smtptest.Rule
+
+		wantFrom   []string // envelope senders of the transactions received
+		wantFailed []string
+		wantErr    error
+	}{
+		{
+			name: "delivered",
+			wantFrom: []string{
+				"bounces+a=example.org@example.com",
+				"bounces+b=example.net@example.com",
+				"bounces+c=example.org@example.com",
+			},
+		},
+		{
+			name:  "partial",
+			rules: []smtptest.Rule{{Command: "RCPT", Arg: "b@example.net", Code: 550, Message: "5.1.1 No such user"}},
+			wantFrom: []string{
+				"bounces+a=example.org@example.com",
+				"bounces+c=example.org@example.com",
+			},
+			wantFailed: []string{"b@example.net"},
+		},
+		{
+			name:    "all failed",
+			rules:   []smtptest.Rule{{Command: "RCPT", Code: 550, Message: "5.1.1 No such user"}},
+			wantErr: &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := smtptest.NewServer(smtptest.ModePlain)
+			defer srv.Close()
+			for _, r := range tt.rules {
+				srv.AddRule(r)
+			}
+
+			m := New(srv.Addr, nil)
+			defer m.Close()
+			m.PoolSize(1)
+
+			mail := m.NewMail()
+			mail.From("news@example.com")
+			mail.To("a@example.org", "b@example.net")
+			mail.Bcc("c@example.org")
+			mail.Subject("VERP")
+			mail.Plain().SetString("bananas")
+			mail.VERP("bounces+{local}={domain}@example.com")
+
+			result, err := m.Deliver(context.Background(), mail)
+
+			var gotFailed []string
+			var verpErr *VERPDeliveryError
+			switch {
+			case errors.As(err, &verpErr):
+				for _, f := range verpErr.Failed {
+					gotFailed = append(gotFailed, f.Address)
+				}
+			case !reflect.DeepEqual(err, tt.wantErr):
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotFailed, tt.wantFailed) {
+				t.Errorf("got failed %v, want %v", gotFailed, tt.wantFailed)
+			}
+
+			var gotFrom []string
+			var data []byte
+			for _, tx := range srv.Transactions() {
+				gotFrom = append(gotFrom, tx.From)
+				if len(tx.To) != 1 {
+					t.Errorf("got %d recipients in transaction, want 1", len(tx.To))
+				}
+				if data != nil && !bytes.Equal(tx.Data, data) {
+					t.Error("MIME content differs between transactions")
+				}
+				data = tx.Data
+			}
+			if !reflect.DeepEqual(gotFrom, tt.wantFrom) {
+				t.Errorf("got envelope senders %v, want %v", gotFrom, tt.wantFrom)
+			}
+			if got := len(result.Accepted); got != len(tt.wantFrom) {
+				t.Errorf("got %d accepted, want %d", got, len(tt.wantFrom))
+			}
+		})
+	}
+}
+
+// TestVERPRouter ensures each VERP transaction is sent using the Transport
+// selected by the routing tag, with the credentials, From address, DSN and
+// SMTPUTF8 options of the email.
+func TestVERPRouter(t *testing.T) {
+	t.Parallel()
+
+	auth := smtp.PlainAuth("", "user", "pass", "localhost")
+	dsn := DSN{Notify: DSNNotifyFailure}
+
+	fallback := &fakeTransport{}
+	tagged := &optionsTransport{sent: make(chan sentOptions, 2)}
+
+	r := NewRouter(fallback)
+	r.Tag("newsletter", tagged)
+
+	m := NewWithTransport(r)
+	mail := m.NewMail()
+	mail.From("news@example.com")
+	mail.To("a@example.org", "用户@例子.广告")
+	mail.VERP("bounces+{local}={domain}@example.com")
+	mail.DSN(dsn)
+	mail.RoutingTag("newsletter")
+	mail.auth = auth
+
+	if err := m.Send(mail); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sentOptions{
+		DSN:        &dsn,
+		RoutingTag: "newsletter",
+		From:       "news@example.com",
+		SMTPUTF8:   true,
+		Auth:       auth,
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-tagged.sent; !reflect.DeepEqual(got, want) {
+			t.Errorf("got options %+v, want %+v", got, want)
+		}
+	}
+	if got := len(fallback.envelopes); got != 0 {
+		t.Errorf("fallback got %d messages, want 0", got)
+	}
+}
+
+// TestNullSender ensures an email with a null envelope sender is sent with an
+// empty MAIL FROM path.
+func TestNullSender(t *testing.T) {
+	t.Parallel()
+
+	srv := smtptest.NewServer(smtptest.ModePlain)
+	defer srv.Close()
+
+	m := New(srv.Addr, nil)
+	mail := m.NewMail()
+	mail.From("autoreply@example.org")
+	mail.EnvelopeFrom("")
+	mail.To("to@example.org")
+
+	if err := m.Send(mail); err != nil {
+		t.Fatal(err)
+	}
+
+	txs := srv.Transactions()
+	if len(txs) != 1 || txs[0].From != "" {
+		t.Fatalf("got transactions %+v, want one with null sender", txs)
+	}
+}
+
+// TestVERPAddress ensures the recipient is substituted into the template.
+func TestVERPAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		template string
+		rcpt     string
+		want     string
+	}{
+		{"bounces+{local}={domain}@example.com", "dom@itsallbroken.com", "bounces+dom=itsallbroken.com@example.com"},
+		{"bounces-{local}-at-{domain}@example.com", "a.b@example.org", "bounces-a.b-at-example.org@example.com"},
+		{"bounces@example.com", "dom@itsallbroken.com", "bounces@example.com"},
+		{"b+{local}={domain}@example.com", "nodomain", "b+nodomain=@example.com"},
+	}
+	for _, tt := range tests {
+		if got := verpAddress(tt.template, tt.rcpt); got != tt.want {
+			t.Errorf("verpAddress(%q, %q) = %q, want %q", tt.template, tt.rcpt, got, tt.want)
+		}
+	}
+}